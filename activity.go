@@ -0,0 +1,98 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+)
+
+// activityTracker buffers room activity timestamps so that busy rooms do not
+// issue one UPDATE per websocket message. Pending timestamps are written in a
+// single transaction by flush.
+type activityTracker struct {
+	mu      sync.Mutex
+	pending map[int]time.Time
+}
+
+var roomActivity = &activityTracker{pending: make(map[int]time.Time)}
+
+func (t *activityTracker) touch(roomID int, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if last, exists := t.pending[roomID]; !exists || at.After(last) {
+		t.pending[roomID] = at
+	}
+}
+
+func (t *activityTracker) flush(database *sql.DB) error {
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = make(map[int]time.Time)
+	t.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	requeue := func() {
+		for roomID, at := range pending {
+			t.touch(roomID, at)
+		}
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		requeue()
+		return err
+	}
+	defer tx.Rollback()
+
+	statement, err := tx.Prepare("UPDATE rooms SET lastActive = GREATEST(lastActive, $1) WHERE id = $2")
+	if err != nil {
+		requeue()
+		return err
+	}
+	defer statement.Close()
+
+	for roomID, at := range pending {
+		if _, err := statement.Exec(at, roomID); err != nil {
+			requeue()
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		requeue()
+		return err
+	}
+	return nil
+}
+
+// touchRoom marks the room as active now, in memory and in the write buffer.
+func touchRoom(game *Game) {
+	now := time.Now()
+	game.lastActive = now
+	if game.roomID != 0 {
+		roomActivity.touch(game.roomID, now)
+	}
+}
+
+func runActivityFlusher(database *sql.DB, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			if err := roomActivity.flush(database); err != nil {
+				log.Printf("Error flushing room activity on shutdown: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := roomActivity.flush(database); err != nil {
+				log.Printf("Error flushing room activity: %v", err)
+			}
+		}
+	}
+}
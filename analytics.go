@@ -0,0 +1,358 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const defaultAnalyticsDays = 90
+
+// AnalyticsReport aggregates the recorded rounds and estimates of one room,
+// or of every room owned by a user, over a period.
+type AnalyticsReport struct {
+	RoomUUID                 *string              `json:"roomUUID,omitempty"`
+	UserUUID                 *string              `json:"userUUID,omitempty"`
+	TeamUUID                 *string              `json:"teamUUID,omitempty"`
+	Rooms                    int                  `json:"rooms"`
+	From                     time.Time            `json:"from"`
+	To                       time.Time            `json:"to"`
+	Rounds                   int                  `json:"rounds"`
+	IssuesEstimated          int                  `json:"issuesEstimated"`
+	ConsensusRate            *float64             `json:"consensusRate"`
+	AverageRoundsToConsensus *float64             `json:"averageRoundsToConsensus"`
+	Sessions                 []AnalyticsSession   `json:"sessions"`
+	Dispersion               []DispersionPoint    `json:"dispersion"`
+	EstimateDistribution     map[string]int       `json:"estimateDistribution"`
+	TimePerIssue             IssueTimingAnalytics `json:"timePerIssue"`
+}
+
+// AnalyticsSession is a day of planning in a room.
+type AnalyticsSession struct {
+	RoomUUID        string `json:"roomUUID"`
+	Date            string `json:"date"`
+	Rounds          int    `json:"rounds"`
+	IssuesEstimated int    `json:"issuesEstimated"`
+}
+
+// DispersionPoint summarizes how far apart the votes were in the rounds
+// revealed during a week, starting on Monday.
+type DispersionPoint struct {
+	Week          string   `json:"week"`
+	Rounds        int      `json:"rounds"`
+	AverageStdDev *float64 `json:"averageStdDev"`
+	ConsensusRate *float64 `json:"consensusRate"`
+}
+
+// IssueTimingAnalytics is the voting time of the issues, from the start of
+// each round to its reveal.
+type IssueTimingAnalytics struct {
+	Issues         int      `json:"issues"`
+	AverageSeconds *float64 `json:"averageSeconds"`
+	MedianSeconds  *float64 `json:"medianSeconds"`
+}
+
+// analyticsScope selects rooms by id, by owner or by team. Serial ids start
+// at 1, so the zero value of the other parameters never matches.
+type analyticsScope struct {
+	roomID  int
+	ownerID int
+	teamID  int
+}
+
+// roomsQuery returns the query selecting the ids of the rooms in scope, with
+// its placeholders numbered from first, and the arguments for them.
+func (s analyticsScope) roomsQuery(first int) (string, []interface{}) {
+	query := fmt.Sprintf("SELECT id FROM rooms WHERE id = $%d OR admin = $%d OR team_id = $%d", first, first+1, first+2)
+	return query, []interface{}{s.roomID, s.ownerID, s.teamID}
+}
+
+func parseAnalyticsPeriod(r *http.Request) (time.Time, time.Time, error) {
+	to := time.Now().UTC()
+	from := to.AddDate(0, 0, -defaultAnalyticsDays)
+
+	parse := func(field string, value string) (time.Time, error) {
+		for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+			if parsed, err := time.Parse(layout, value); err == nil {
+				return parsed.UTC(), nil
+			}
+		}
+		return time.Time{}, errInvalidField(field, field+" must be a date (2006-01-02) or an RFC 3339 timestamp")
+	}
+
+	var err error
+	if value := r.URL.Query().Get("from"); value != "" {
+		if from, err = parse("from", value); err != nil {
+			return from, to, err
+		}
+	}
+	if value := r.URL.Query().Get("to"); value != "" {
+		if to, err = parse("to", value); err != nil {
+			return from, to, err
+		}
+	}
+	if !from.Before(to) {
+		return from, to, errInvalidField("from", "from must be before to")
+	}
+	return from, to, nil
+}
+
+func buildAnalytics(database *sql.DB, scope analyticsScope, from time.Time, to time.Time) (AnalyticsReport, error) {
+	report := AnalyticsReport{
+		From:                 from,
+		To:                   to,
+		Sessions:             []AnalyticsSession{},
+		Dispersion:           []DispersionPoint{},
+		EstimateDistribution: map[string]int{},
+	}
+
+	roomUUIDs := map[int]string{}
+	scopeRooms, scopeArgs := scope.roomsQuery(1)
+	rows, err := database.Query("SELECT id, uuid FROM rooms WHERE id IN ("+scopeRooms+")", scopeArgs...)
+	if err != nil {
+		return report, fmt.Errorf("error fetching analytics rooms: %v", err)
+	}
+	for rows.Next() {
+		var id int
+		var roomUUID string
+		if err := rows.Scan(&id, &roomUUID); err != nil {
+			rows.Close()
+			return report, err
+		}
+		roomUUIDs[id] = roomUUID
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+	report.Rooms = len(roomUUIDs)
+
+	type sessionKey struct {
+		roomID int
+		date   string
+	}
+	sessions := map[sessionKey]*AnalyticsSession{}
+	session := func(roomID int, at time.Time) *AnalyticsSession {
+		key := sessionKey{roomID, at.Format("2006-01-02")}
+		if sessions[key] == nil {
+			sessions[key] = &AnalyticsSession{RoomUUID: roomUUIDs[roomID], Date: key.date}
+		}
+		return sessions[key]
+	}
+
+	type weekTotals struct {
+		rounds, withStdDev, consensus, numeric int
+		stdDev                                 float64
+	}
+	weeks := map[string]*weekTotals{}
+	issueSeconds := map[int]float64{}
+	consensusRounds, numericRounds := 0, 0
+
+	// The period comes first, the scope takes the placeholders after it
+	scopeRooms, scopeArgs = scope.roomsQuery(3)
+	periodArgs := append([]interface{}{from, to}, scopeArgs...)
+
+	// Rounds come ordered by id with their votes next to each other
+	rows, err = database.Query(`SELECT ro.id, ro.room_id, COALESCE(ro.issue_id, 0), ro.started_at, ro.revealed_at, rv.vote
+		FROM rounds ro
+		LEFT JOIN round_votes rv ON rv.round_id = ro.id
+		WHERE ro.revealed_at >= $1 AND ro.revealed_at < $2 AND ro.room_id IN (`+scopeRooms+`)
+		ORDER BY ro.id, rv.id`, periodArgs...)
+	if err != nil {
+		return report, fmt.Errorf("error fetching analytics rounds: %v", err)
+	}
+	defer rows.Close()
+
+	currentRoundID := 0
+	var roomID, issueID int
+	var revealedAt time.Time
+	var votes []string
+	flush := func() {
+		if currentRoundID == 0 {
+			return
+		}
+		report.Rounds++
+		session(roomID, revealedAt).Rounds++
+
+		stats := computeRoundStats(votes)
+		weekStart := revealedAt.AddDate(0, 0, -((int(revealedAt.Weekday()) + 6) % 7))
+		week := weekStart.Format("2006-01-02")
+		if weeks[week] == nil {
+			weeks[week] = &weekTotals{}
+		}
+		weeks[week].rounds++
+		if stats.NumericVotes > 0 {
+			numericRounds++
+			weeks[week].numeric++
+			if stats.Consensus {
+				consensusRounds++
+				weeks[week].consensus++
+			}
+		}
+		if stats.StdDev != nil {
+			weeks[week].withStdDev++
+			weeks[week].stdDev += *stats.StdDev
+		}
+	}
+
+	for rows.Next() {
+		var id, room, issue int
+		var startedAt sql.NullTime
+		var revealed time.Time
+		var vote sql.NullString
+		if err := rows.Scan(&id, &room, &issue, &startedAt, &revealed, &vote); err != nil {
+			return report, fmt.Errorf("error scanning analytics round: %v", err)
+		}
+		if id != currentRoundID {
+			flush()
+			currentRoundID, roomID, issueID, revealedAt, votes = id, room, issue, revealed.UTC(), nil
+			if issueID != 0 && startedAt.Valid && revealed.After(startedAt.Time) {
+				issueSeconds[issueID] += revealed.Sub(startedAt.Time).Seconds()
+			}
+		}
+		if vote.Valid {
+			votes = append(votes, vote.String)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+	flush()
+
+	if numericRounds > 0 {
+		rate := float64(consensusRounds) / float64(numericRounds)
+		report.ConsensusRate = &rate
+	}
+	for week, totals := range weeks {
+		point := DispersionPoint{Week: week, Rounds: totals.rounds}
+		if totals.withStdDev > 0 {
+			average := totals.stdDev / float64(totals.withStdDev)
+			point.AverageStdDev = &average
+		}
+		if totals.numeric > 0 {
+			rate := float64(totals.consensus) / float64(totals.numeric)
+			point.ConsensusRate = &rate
+		}
+		report.Dispersion = append(report.Dispersion, point)
+	}
+	sort.Slice(report.Dispersion, func(i, j int) bool { return report.Dispersion[i].Week < report.Dispersion[j].Week })
+
+	issueRows, err := database.Query(`SELECT i.room_id, i.estimate, i.estimated_at, COUNT(ro.id)
+		FROM issues i
+		LEFT JOIN rounds ro ON ro.issue_id = i.id
+		WHERE i.estimate IS NOT NULL AND i.estimated_at >= $1 AND i.estimated_at < $2 AND i.room_id IN (`+scopeRooms+`)
+		GROUP BY i.id, i.room_id, i.estimate, i.estimated_at`, periodArgs...)
+	if err != nil {
+		return report, fmt.Errorf("error fetching analytics issues: %v", err)
+	}
+	defer issueRows.Close()
+
+	roundsNeeded, votedIssues := 0, 0
+	for issueRows.Next() {
+		var room, rounds int
+		var estimate string
+		var estimatedAt time.Time
+		if err := issueRows.Scan(&room, &estimate, &estimatedAt, &rounds); err != nil {
+			return report, fmt.Errorf("error scanning analytics issue: %v", err)
+		}
+		report.IssuesEstimated++
+		report.EstimateDistribution[estimate]++
+		session(room, estimatedAt.UTC()).IssuesEstimated++
+		if rounds > 0 {
+			roundsNeeded += rounds
+			votedIssues++
+		}
+	}
+	if err := issueRows.Err(); err != nil {
+		return report, err
+	}
+	if votedIssues > 0 {
+		average := float64(roundsNeeded) / float64(votedIssues)
+		report.AverageRoundsToConsensus = &average
+	}
+
+	for _, s := range sessions {
+		report.Sessions = append(report.Sessions, *s)
+	}
+	sort.Slice(report.Sessions, func(i, j int) bool {
+		if report.Sessions[i].Date != report.Sessions[j].Date {
+			return report.Sessions[i].Date < report.Sessions[j].Date
+		}
+		return report.Sessions[i].RoomUUID < report.Sessions[j].RoomUUID
+	})
+
+	report.TimePerIssue = issueTiming(issueSeconds)
+	return report, nil
+}
+
+func issueTiming(issueSeconds map[int]float64) IssueTimingAnalytics {
+	timing := IssueTimingAnalytics{Issues: len(issueSeconds)}
+	if len(issueSeconds) == 0 {
+		return timing
+	}
+
+	seconds := make([]float64, 0, len(issueSeconds))
+	total := 0.0
+	for _, value := range issueSeconds {
+		seconds = append(seconds, value)
+		total += value
+	}
+	sort.Float64s(seconds)
+
+	average := total / float64(len(seconds))
+	median := seconds[len(seconds)/2]
+	if len(seconds)%2 == 0 {
+		median = (seconds[len(seconds)/2-1] + seconds[len(seconds)/2]) / 2
+	}
+	timing.AverageSeconds = &average
+	timing.MedianSeconds = &median
+	return timing
+}
+
+func apiRoomAnalytics(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomUUID := mux.Vars(r)["roomUUID"]
+		roomID, err := findRoomID(database, roomUUID)
+		if handleError(w, err) {
+			return
+		}
+		from, to, err := parseAnalyticsPeriod(r)
+		if handleError(w, err) {
+			return
+		}
+
+		report, err := buildAnalytics(database, analyticsScope{roomID: roomID}, from, to)
+		if handleError(w, err) {
+			return
+		}
+		report.RoomUUID = &roomUUID
+
+		sendResponse(w, report)
+	}
+}
+
+func apiOwnerAnalytics(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userUUID := mux.Vars(r)["userUUID"]
+		userID, err := findUserID(database, userUUID)
+		if handleError(w, err) {
+			return
+		}
+		from, to, err := parseAnalyticsPeriod(r)
+		if handleError(w, err) {
+			return
+		}
+
+		report, err := buildAnalytics(database, analyticsScope{ownerID: userID}, from, to)
+		if handleError(w, err) {
+			return
+		}
+		report.UserUUID = &userUUID
+
+		sendResponse(w, report)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"database/sql"
+	"math/rand"
+)
+
+// AnonymousVotes are the revealed votes of an anonymous room: a shuffled list
+// and a count per card, without saying who voted what.
+type AnonymousVotes struct {
+	Votes        []string       `json:"votes"`
+	Distribution map[string]int `json:"distribution"`
+}
+
+// discardsAttribution reports whether rounds recorded now must not store who
+// cast each vote.
+func discardsAttribution(game *Game) bool {
+	return game.settings.Anonymous && !game.settings.KeepVoteAttribution
+}
+
+// anonymousVotes returns the revealed votes of an anonymous room, or nil when
+// the room is not anonymous or the cards are hidden.
+func anonymousVotes(game *Game) *AnonymousVotes {
+	if !game.settings.Anonymous || !game.showCards {
+		return nil
+	}
+
+	result := &AnonymousVotes{
+		Votes:        []string{},
+		Distribution: map[string]int{},
+	}
+	for _, player := range game.Players {
+		if player == nil || !player.Voted || player.Vote == nil {
+			continue
+		}
+		result.Votes = append(result.Votes, *player.Vote)
+		result.Distribution[*player.Vote]++
+	}
+	rand.Shuffle(len(result.Votes), func(i, j int) {
+		result.Votes[i], result.Votes[j] = result.Votes[j], result.Votes[i]
+	})
+	return result
+}
+
+// setRoomAnonymous switches anonymous voting and whether the recorded history
+// keeps attribution while it is on. nil leaves a setting unchanged.
+func setRoomAnonymous(database *sql.DB, roomUUID string, anonymous *bool, keepVoteAttribution *bool) error {
+	_, err := updateRoomSettings(database, roomUUID, RoomSettingsPatch{Anonymous: anonymous, KeepVoteAttribution: keepVoteAttribution})
+	return err
+}
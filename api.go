@@ -0,0 +1,786 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// registerAPIRoutes mounts the resource-oriented API under /api/v1. The
+// legacy verb routes registered in main.go share the same operations. It
+// returns the method routers keyed by their full path.
+func registerAPIRoutes(r *mux.Router, database *sql.DB) map[string]methodRouter {
+	r.NotFoundHandler = enableCors(func(w http.ResponseWriter, r *http.Request) {
+		handleError(w, newAPIError(http.StatusNotFound, "route_not_found", "Route not found"))
+	})
+
+	api := r.PathPrefix("/api/v1").Subrouter()
+
+	// Each path is registered once and dispatches on the method itself: mux
+	// reports method mismatches inside subrouters as 404s.
+	routes := make(map[string]methodRouter)
+	handle := func(path string, method string, handler http.HandlerFunc) {
+		router, exists := routes["/api/v1"+path]
+		if !exists {
+			router = make(methodRouter)
+			routes["/api/v1"+path] = router
+			api.HandleFunc(path, enableCors(router.ServeHTTP))
+		}
+		router[method] = handler
+	}
+
+	handle("/rooms", http.MethodGet, listRooms(database))
+	handle("/rooms", http.MethodPost, apiCreateRoom(database))
+	handle("/rooms/{roomUUID}", http.MethodGet, apiGetRoom(database))
+	handle("/rooms/{roomUUID}", http.MethodPatch, apiUpdateRoom(database))
+	handle("/rooms/{roomUUID}", http.MethodDelete, apiDeleteRoom(database))
+	handle("/rooms/{roomUUID}/settings", http.MethodGet, apiGetRoomSettings(database))
+	handle("/rooms/{roomUUID}/settings", http.MethodPatch, apiUpdateRoomSettings(database))
+	handle("/rooms/{roomUUID}/sessions", http.MethodGet, apiListSessions(database))
+	handle("/rooms/{roomUUID}/sessions", http.MethodPost, apiStartSession(database))
+	handle("/rooms/{roomUUID}/export", http.MethodGet, apiExportRoom(database))
+	handle("/rooms/{roomUUID}/leaderboard", http.MethodGet, apiLeaderboard(database))
+	handle("/rooms/{roomUUID}/analytics", http.MethodGet, apiRoomAnalytics(database))
+	handle("/users/{userUUID}/scores", http.MethodGet, apiUserScores(database))
+	handle("/users/{userUUID}/analytics", http.MethodGet, apiOwnerAnalytics(database))
+	handle("/users/{userUUID}/teams", http.MethodGet, apiListUserTeams(database))
+	handle("/users/{userUUID}/templates", http.MethodGet, apiListTemplates(database))
+	handle("/users/{userUUID}/templates", http.MethodPost, apiCreateTemplate(database))
+	handle("/users/{userUUID}/templates/{templateUUID}", http.MethodGet, apiGetTemplate(database))
+	handle("/users/{userUUID}/templates/{templateUUID}", http.MethodPut, apiReplaceTemplate(database))
+	handle("/users/{userUUID}/templates/{templateUUID}", http.MethodDelete, apiDeleteTemplate(database))
+
+	handle("/teams", http.MethodPost, apiCreateTeam(database))
+	handle("/teams/{teamUUID}", http.MethodGet, apiGetTeam(database))
+	handle("/teams/{teamUUID}", http.MethodDelete, apiDeleteTeam(database))
+	handle("/teams/{teamUUID}/members/{memberUUID}", http.MethodPut, apiSetTeamMember(database))
+	handle("/teams/{teamUUID}/members/{memberUUID}", http.MethodDelete, apiRemoveTeamMember(database))
+	handle("/teams/{teamUUID}/rooms", http.MethodGet, apiListTeamRooms(database))
+	handle("/teams/{teamUUID}/analytics", http.MethodGet, apiTeamAnalytics(database))
+
+	handle("/rooms/{roomUUID}/players", http.MethodGet, apiListPlayers(database))
+	handle("/rooms/{roomUUID}/players", http.MethodPost, apiJoinRoom(database))
+	handle("/rooms/{roomUUID}/players/{userUUID}", http.MethodPatch, apiUpdatePlayer(database))
+	handle("/rooms/{roomUUID}/players/{userUUID}", http.MethodDelete, apiRemovePlayer(database))
+
+	handle("/rooms/{roomUUID}/issues", http.MethodGet, apiListIssues(database))
+	handle("/rooms/{roomUUID}/issues", http.MethodPost, apiCreateIssue(database))
+	handle("/rooms/{roomUUID}/issues/order", http.MethodPut, apiReorderIssues(database))
+	handle("/rooms/{roomUUID}/issues/bulk", http.MethodPost, apiBulkImportIssues(database))
+	handle("/rooms/{roomUUID}/issues/import/{provider}", http.MethodPost, apiImportIssues(database))
+	handle("/rooms/{roomUUID}/issues/{issueUUID}", http.MethodPatch, apiUpdateIssue(database))
+	handle("/rooms/{roomUUID}/issues/{issueUUID}", http.MethodDelete, apiDeleteIssue(database))
+	handle("/rooms/{roomUUID}/issues/{issueUUID}/estimate", http.MethodPut, apiSetIssueEstimate(database))
+	handle("/rooms/{roomUUID}/issues/{issueUUID}/comments", http.MethodGet, apiListComments(database))
+	handle("/rooms/{roomUUID}/issues/{issueUUID}/comments", http.MethodPost, apiCreateComment(database))
+	handle("/rooms/{roomUUID}/issues/{issueUUID}/comments/{commentUUID}", http.MethodDelete, apiDeleteComment(database))
+
+	handle("/rooms/{roomUUID}/rounds", http.MethodPost, apiStartRound(database))
+	handle("/rooms/{roomUUID}/rounds/current", http.MethodGet, apiGetRound(database))
+	handle("/rooms/{roomUUID}/rounds/current", http.MethodPatch, apiUpdateRound(database))
+	handle("/rooms/{roomUUID}/rounds/current/timer", http.MethodPost, apiControlTimer(database))
+	handle("/rooms/{roomUUID}/rounds/current/revote", http.MethodPost, apiRevote(database))
+	handle("/rooms/{roomUUID}/rounds/current/votes/{userUUID}", http.MethodPut, apiCastVote(database))
+	handle("/rooms/{roomUUID}/rounds/current/votes/{userUUID}", http.MethodDelete, apiWithdrawVote(database))
+
+	handle("/rooms/{roomUUID}/chat", http.MethodGet, apiListChat(database))
+	handle("/rooms/{roomUUID}/chat", http.MethodPost, apiPostChat(database))
+	handle("/rooms/{roomUUID}/chat/{messageUUID}", http.MethodDelete, apiDeleteChat(database))
+
+	handle("/rooms/{roomUUID}/webhooks", http.MethodGet, apiListWebhooks(database))
+	handle("/rooms/{roomUUID}/webhooks", http.MethodPost, apiCreateWebhook(database))
+	handle("/rooms/{roomUUID}/webhooks/{webhookUUID}", http.MethodDelete, apiDeleteWebhook(database))
+	handle("/rooms/{roomUUID}/webhooks/{webhookUUID}/deliveries", http.MethodGet, apiListWebhookDeliveries(database))
+	handle("/rooms/{roomUUID}/webhooks/{webhookUUID}/test", http.MethodPost, apiTestWebhook(database))
+
+	return routes
+}
+
+// methodRouter maps HTTP methods to the handlers of a single path.
+type methodRouter map[string]http.HandlerFunc
+
+func (m methodRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler, exists := m[r.Method]
+	if !exists {
+		allowed := make([]string, 0, len(m))
+		for method := range m {
+			allowed = append(allowed, method)
+		}
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		handleError(w, newAPIError(http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed"))
+		return
+	}
+	handler(w, r)
+}
+
+type UpdateRoomRequest struct {
+	Name                *string `json:"name"`
+	AutoShowCards       *bool   `json:"autoShowCards"`
+	Anonymous           *bool   `json:"anonymous"`
+	KeepVoteAttribution *bool   `json:"keepVoteAttribution"`
+	Persistent          *bool   `json:"persistent"`
+	// A team of the user, or "" to take the room out of its team
+	TeamUUID *string `json:"teamUUID"`
+	// Required: the name and the settings are reserved to the owner and the
+	// team admins, and the team to the owner
+	UserUUID string `json:"userUUID"`
+}
+
+type JoinRoomBody struct {
+	UserUUID string `json:"userUUID"`
+	// Observers watch the room without voting
+	Observer *bool `json:"observer"`
+}
+
+// UpdatePlayerRequest renames a player. UserUUID must be the player being
+// renamed.
+type UpdatePlayerRequest struct {
+	UserUUID string `json:"userUUID"`
+	Name     string `json:"name"`
+}
+
+// Starting and revealing rounds through the API is reserved to the room
+// admin. The userUUID of a revote is only required when the room restricts
+// who may reset.
+type StartRoundRequest struct {
+	UserUUID  string  `json:"userUUID"`
+	IssueUUID *string `json:"issueUUID"`
+}
+
+type UpdateRoundRequest struct {
+	UserUUID string `json:"userUUID"`
+	Revealed bool   `json:"revealed"`
+}
+
+type RevoteRequest struct {
+	UserUUID string `json:"userUUID"`
+}
+
+type ReorderIssuesRequest struct {
+	// Must be the room admin or a team admin
+	UserUUID string   `json:"userUUID"`
+	Issues   []string `json:"issues"`
+}
+
+type EstimateRequest struct {
+	// Must be the room admin or a team admin
+	UserUUID     string  `json:"userUUID"`
+	Estimate     string  `json:"estimate"`
+	DecisionNote *string `json:"decisionNote"`
+}
+
+type VoteRequest struct {
+	Vote string `json:"vote"`
+}
+
+type RoundVote struct {
+	UserUUID string  `json:"userUUID"`
+	Name     string  `json:"name"`
+	Voted    bool    `json:"voted"`
+	Vote     *string `json:"vote"`
+}
+
+type PlayersResponse struct {
+	Players []*Player `json:"players"`
+}
+
+type IssuesResponse struct {
+	Issues []Issue `json:"issues"`
+}
+
+// IssuePageResponse is one page of a room's backlog. NextOffset is null on
+// the last page.
+type IssuePageResponse struct {
+	Issues     []Issue `json:"issues"`
+	Total      int     `json:"total"`
+	Limit      int     `json:"limit"`
+	Offset     int     `json:"offset"`
+	NextOffset *int    `json:"nextOffset"`
+}
+
+type IssueResponse struct {
+	Issue Issue `json:"issue"`
+}
+
+type RoundState struct {
+	RoomUUID       string          `json:"roomUUID"`
+	IssueUUID      *string         `json:"issueUUID"`
+	StartedAt      *time.Time      `json:"startedAt"`
+	Revealed       bool            `json:"revealed"`
+	AutoShowCards  bool            `json:"autoShowCards"`
+	Timer          *TimerState     `json:"timer"`
+	Votes          []RoundVote     `json:"votes"`
+	PreviousRounds []RoundResult   `json:"previousRounds"`
+	AnonymousVotes *AnonymousVotes `json:"anonymousVotes"`
+}
+
+// roundResource lists who voted in the current round. Votes are only
+// included once the cards are revealed.
+func roundResource(game *Game) RoundState {
+	votes := []RoundVote{}
+	for _, player := range game.Players {
+		if player.Observer {
+			continue
+		}
+		vote := RoundVote{
+			UserUUID: player.UUID,
+			Name:     player.Name,
+			Voted:    player.Voted,
+		}
+		if game.showCards && !game.settings.Anonymous {
+			vote.Vote = player.Vote
+		}
+		votes = append(votes, vote)
+	}
+
+	round := RoundState{
+		RoomUUID:       game.roomUUID,
+		Revealed:       game.showCards,
+		AutoShowCards:  game.settings.AutoReveal,
+		Timer:          timerState(game),
+		Votes:          votes,
+		PreviousRounds: previousRoundResults(game),
+		AnonymousVotes: anonymousVotes(game),
+	}
+	if game.currentIssueUUID != "" {
+		round.IssueUUID = &game.currentIssueUUID
+	}
+	if !game.roundStartedAt.IsZero() {
+		round.StartedAt = &game.roundStartedAt
+	}
+	return round
+}
+
+func apiCreateRoom(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RoomRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+
+		response, err := openRoom(database, req)
+		if handleError(w, err) {
+			return
+		}
+
+		sendResponseWithStatus(w, http.StatusCreated, response)
+	}
+}
+
+func apiGetRoom(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		game, err := loadGame(database, mux.Vars(r)["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+
+		sendResponse(w, roomState(game))
+	}
+}
+
+func apiUpdateRoom(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomUUID := mux.Vars(r)["roomUUID"]
+
+		var req UpdateRoomRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+
+		game, err := loadGame(database, roomUUID)
+		if handleError(w, err) {
+			return
+		}
+
+		// Checked before anything changes, so a forbidden field does not
+		// leave the others applied
+		if req.Name != nil || req.AutoShowCards != nil || req.Anonymous != nil || req.KeepVoteAttribution != nil || req.Persistent != nil {
+			if _, err := requireRoomAdmin(database, game.roomID, req.UserUUID); handleError(w, err) {
+				return
+			}
+		}
+		// Moving the room between teams changes who administers it, so
+		// team admins cannot do it
+		if req.TeamUUID != nil {
+			if _, err := requireRoomOwner(database, game.roomID, req.UserUUID); handleError(w, err) {
+				return
+			}
+		}
+
+		if req.Persistent != nil {
+			if err := setRoomPersistent(database, game, *req.Persistent); handleError(w, err) {
+				return
+			}
+		}
+		if req.TeamUUID != nil {
+			var team *Team
+			if *req.TeamUUID != "" {
+				found, err := findMemberTeam(database, *req.TeamUUID, req.UserUUID)
+				if handleError(w, err) {
+					return
+				}
+				team = &found
+			}
+			if err := setRoomTeam(database, game, team); handleError(w, err) {
+				return
+			}
+		}
+		if req.Name != nil {
+			if err := renameRoom(database, roomUUID, *req.Name); handleError(w, err) {
+				return
+			}
+		}
+		if req.AutoShowCards != nil {
+			if err := setRoomAutoShowCards(database, roomUUID, *req.AutoShowCards); handleError(w, err) {
+				return
+			}
+		}
+		if req.Anonymous != nil || req.KeepVoteAttribution != nil {
+			if err := setRoomAnonymous(database, roomUUID, req.Anonymous, req.KeepVoteAttribution); handleError(w, err) {
+				return
+			}
+		}
+
+		sendResponse(w, roomState(game))
+	}
+}
+
+func apiDeleteRoom(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, err := deleteRoomAs(database, mux.Vars(r)["roomUUID"], r.URL.Query().Get("userUUID"))
+		if handleError(w, err) {
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func apiListPlayers(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		game, err := loadGame(database, mux.Vars(r)["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+
+		sendResponse(w, PlayersResponse{
+			Players: visiblePlayers(game, 0),
+		})
+	}
+}
+
+func apiJoinRoom(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req JoinRoomBody
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+
+		response, err := addPlayerToRoom(database, mux.Vars(r)["roomUUID"], req.UserUUID, req.Observer)
+		if handleError(w, err) {
+			return
+		}
+
+		sendResponseWithStatus(w, http.StatusCreated, response)
+	}
+}
+
+func apiUpdatePlayer(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		var req UpdatePlayerRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+
+		if req.UserUUID != vars["userUUID"] {
+			handleError(w, newAPIError(http.StatusForbidden, "forbidden", "Players can only rename themselves"))
+			return
+		}
+		if err := renamePlayer(database, vars["roomUUID"], vars["userUUID"], req.Name); handleError(w, err) {
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func apiRemovePlayer(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		// Players may leave on their own; removing anyone else is a kick
+		var err error
+		if callerUUID := r.URL.Query().Get("userUUID"); callerUUID == vars["userUUID"] {
+			err = removePlayerFromRoom(database, vars["roomUUID"], vars["userUUID"], true)
+		} else {
+			err = kickFromRoom(database, vars["roomUUID"], callerUUID, vars["userUUID"])
+		}
+		if handleError(w, err) {
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func apiListIssues(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := IssueFilter{Limit: 50}
+		if value := query.Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 || parsed > 200 {
+				handleError(w, errInvalidField("limit", "limit must be between 1 and 200"))
+				return
+			}
+			filter.Limit = parsed
+		}
+		if value := query.Get("offset"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 {
+				handleError(w, errInvalidField("offset", "offset must be a non-negative integer"))
+				return
+			}
+			filter.Offset = parsed
+		}
+		if value := query.Get("estimated"); value != "" {
+			estimated, err := strconv.ParseBool(value)
+			if err != nil {
+				handleError(w, errInvalidField("estimated", "estimated must be true or false"))
+				return
+			}
+			filter.Estimated = &estimated
+		}
+
+		roomID, err := findRoomID(database, mux.Vars(r)["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+
+		issues, err := fetchIssuePage(database, roomID, filter)
+		if handleError(w, err) {
+			return
+		}
+		total, err := countIssues(database, roomID, filter)
+		if handleError(w, err) {
+			return
+		}
+
+		page := IssuePageResponse{
+			Issues: issues,
+			Total:  total,
+			Limit:  filter.Limit,
+			Offset: filter.Offset,
+		}
+		if next := filter.Offset + len(issues); next < total {
+			page.NextOffset = &next
+		}
+		sendResponse(w, page)
+	}
+}
+
+func apiCreateIssue(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req IssueRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+		if req.Title == "" {
+			handleError(w, errMissingField("title"))
+			return
+		}
+
+		game, err := loadGame(database, mux.Vars(r)["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+		if game.archived {
+			handleError(w, errRoomArchived)
+			return
+		}
+		if _, err := requireRoomAdmin(database, game.roomID, req.UserUUID); handleError(w, err) {
+			return
+		}
+
+		issue, err := addIssue(database, game, req)
+		if handleError(w, err) {
+			return
+		}
+		touchRoom(game)
+		sendGameState(game)
+
+		sendResponseWithStatus(w, http.StatusCreated, IssueResponse{
+			Issue: issue,
+		})
+	}
+}
+
+// writableGame loads the room for a handler that modifies it.
+func writableGame(database *sql.DB, roomUUID string) (*Game, error) {
+	game, err := loadGame(database, roomUUID)
+	if err != nil {
+		return nil, err
+	}
+	if game.archived {
+		return nil, errRoomArchived
+	}
+	return game, nil
+}
+
+func apiUpdateIssue(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		var req UpdateIssueRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+
+		game, err := writableGame(database, vars["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+		if _, err := requireRoomAdmin(database, game.roomID, req.UserUUID); handleError(w, err) {
+			return
+		}
+
+		issue, err := updateIssue(database, game, vars["issueUUID"], req)
+		if handleError(w, err) {
+			return
+		}
+		touchRoom(game)
+		sendGameState(game)
+
+		sendResponse(w, IssueResponse{
+			Issue: issue,
+		})
+	}
+}
+
+func apiDeleteIssue(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		game, err := writableGame(database, vars["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+		if _, err := requireRoomAdmin(database, game.roomID, r.URL.Query().Get("userUUID")); handleError(w, err) {
+			return
+		}
+
+		if err := deleteIssue(database, game, vars["issueUUID"]); handleError(w, err) {
+			return
+		}
+		touchRoom(game)
+		sendGameState(game)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func apiReorderIssues(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ReorderIssuesRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+
+		game, err := writableGame(database, mux.Vars(r)["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+		if _, err := requireRoomAdmin(database, game.roomID, req.UserUUID); handleError(w, err) {
+			return
+		}
+
+		if err := reorderIssues(database, game, req.Issues); handleError(w, err) {
+			return
+		}
+		touchRoom(game)
+		sendGameState(game)
+
+		sendResponse(w, IssuesResponse{
+			Issues: game.issues,
+		})
+	}
+}
+
+func apiSetIssueEstimate(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		var req EstimateRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+
+		game, err := writableGame(database, vars["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+		if _, err := requireRoomAdmin(database, game.roomID, req.UserUUID); handleError(w, err) {
+			return
+		}
+
+		issue, err := setIssueEstimate(database, game, vars["issueUUID"], req.Estimate, req.DecisionNote)
+		if handleError(w, err) {
+			return
+		}
+		touchRoom(game)
+		sendGameState(game)
+
+		sendResponse(w, IssueResponse{
+			Issue: issue,
+		})
+	}
+}
+
+func apiStartRound(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomUUID := mux.Vars(r)["roomUUID"]
+
+		game, err := loadGame(database, roomUUID)
+		if handleError(w, err) {
+			return
+		}
+
+		// The body is optional: without an issue the round keeps the current one
+		var req StartRoundRequest
+		if err := decodeJSON(r, &req); err != nil && !errors.Is(err, io.EOF) {
+			handleError(w, err)
+			return
+		}
+
+		if _, err := requireRoomAdmin(database, game.roomID, req.UserUUID); handleError(w, err) {
+			return
+		}
+		if req.IssueUUID != nil {
+			if _, err := setCurrentIssue(database, game, *req.IssueUUID); handleError(w, err) {
+				return
+			}
+		}
+		if err := resetRoomVotes(database, roomUUID); handleError(w, err) {
+			return
+		}
+		touchRoom(game)
+
+		sendResponseWithStatus(w, http.StatusCreated, roundResource(game))
+	}
+}
+
+func apiRevote(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		game, err := loadGame(database, mux.Vars(r)["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+
+		// The body is optional, like the one of a new round
+		var req RevoteRequest
+		if err := decodeJSON(r, &req); err != nil && !errors.Is(err, io.EOF) {
+			handleError(w, err)
+			return
+		}
+		if err := checkRoomPolicy(database, game, game.settings.ResetPolicy, req.UserUUID); handleError(w, err) {
+			return
+		}
+
+		if err := revote(database, game); handleError(w, err) {
+			return
+		}
+		touchRoom(game)
+
+		sendResponseWithStatus(w, http.StatusCreated, roundResource(game))
+	}
+}
+
+func apiGetRound(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		game, err := loadGame(database, mux.Vars(r)["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+
+		sendResponse(w, roundResource(game))
+	}
+}
+
+func apiUpdateRound(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomUUID := mux.Vars(r)["roomUUID"]
+
+		var req UpdateRoundRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+
+		game, err := loadGame(database, roomUUID)
+		if handleError(w, err) {
+			return
+		}
+
+		if _, err := requireRoomAdmin(database, game.roomID, req.UserUUID); handleError(w, err) {
+			return
+		}
+		if _, err := setRoomShowCards(database, roomUUID, &req.Revealed); handleError(w, err) {
+			return
+		}
+		touchRoom(game)
+
+		sendResponse(w, roundResource(game))
+	}
+}
+
+func apiCastVote(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req VoteRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+		if req.Vote == "" {
+			handleError(w, errMissingField("vote"))
+			return
+		}
+
+		apiSetVote(database, w, r, &req.Vote)
+	}
+}
+
+func apiWithdrawVote(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		apiSetVote(database, w, r, nil)
+	}
+}
+
+func apiSetVote(database *sql.DB, w http.ResponseWriter, r *http.Request, vote *string) {
+	vars := mux.Vars(r)
+
+	game, err := writableGame(database, vars["roomUUID"])
+	if handleError(w, err) {
+		return
+	}
+
+	userID, err := findUserID(database, vars["userUUID"])
+	if handleError(w, err) {
+		return
+	}
+
+	if err := setPlayerVote(database, game, userID, vote); handleError(w, err) {
+		return
+	}
+	touchRoom(game)
+	sendGameState(game)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+const maxBulkIssues = 500
+
+// BulkImportRequest carries a backlog pasted from a spreadsheet (CSV) or a
+// Markdown/plain text list.
+type BulkImportRequest struct {
+	// Must be the room admin or a team admin
+	UserUUID  string     `json:"userUUID"`
+	Format    string     `json:"format"`
+	Content   string     `json:"content"`
+	Columns   CSVColumns `json:"columns"`
+	NoHeader  bool       `json:"noHeader"`
+	Delimiter string     `json:"delimiter"`
+}
+
+// CSVColumns maps issue fields to CSV columns, either by header name or by
+// 1-based column number. Without a mapping the columns named title,
+// description and link are used.
+type CSVColumns struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Link        string `json:"link"`
+}
+
+type BulkImportResponse struct {
+	Imported []Issue `json:"imported"`
+}
+
+// parseBulkIssues turns the request content into issues, without touching
+// the database.
+func parseBulkIssues(req BulkImportRequest) ([]Issue, error) {
+	if strings.TrimSpace(req.Content) == "" {
+		return nil, errMissingField("content")
+	}
+
+	var issues []Issue
+	var err error
+	switch req.Format {
+	case "csv":
+		issues, err = parseCSVIssues(req)
+	case "markdown", "text":
+		issues = parseListIssues(req.Content)
+	case "":
+		return nil, errMissingField("format")
+	default:
+		return nil, errInvalidField("format", "format must be csv, markdown or text")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(issues) == 0 {
+		return nil, errInvalidField("content", "No issues found in content")
+	}
+	if len(issues) > maxBulkIssues {
+		return nil, errInvalidField("content", fmt.Sprintf("At most %d issues can be imported at once", maxBulkIssues))
+	}
+	for i, issue := range issues {
+		if utf8.RuneCountInString(issue.Title) > 255 {
+			return nil, errInvalidField("content", fmt.Sprintf("Title of issue %d is longer than 255 characters", i+1))
+		}
+	}
+	return issues, nil
+}
+
+func parseCSVIssues(req BulkImportRequest) ([]Issue, error) {
+	reader := csv.NewReader(strings.NewReader(req.Content))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comma = detectDelimiter(req.Content)
+	if req.Delimiter != "" {
+		delimiter, size := utf8.DecodeRuneInString(req.Delimiter)
+		if size != len(req.Delimiter) {
+			return nil, errInvalidField("delimiter", "delimiter must be a single character")
+		}
+		reader.Comma = delimiter
+	}
+
+	var header []string
+	if !req.NoHeader {
+		record, err := reader.Read()
+		if err != nil {
+			return nil, errInvalidField("content", "Could not read the CSV header: "+err.Error())
+		}
+		header = record
+	}
+
+	columns := req.Columns
+	if columns.Title == "" {
+		columns = CSVColumns{Title: "title", Description: "description", Link: "link"}
+		if req.NoHeader {
+			columns = CSVColumns{Title: "1", Description: "2", Link: "3"}
+		}
+	}
+
+	title, err := csvColumnIndex(header, columns.Title, "title", true)
+	if err != nil {
+		return nil, err
+	}
+	description, err := csvColumnIndex(header, columns.Description, "description", req.Columns.Description != "")
+	if err != nil {
+		return nil, err
+	}
+	link, err := csvColumnIndex(header, columns.Link, "link", req.Columns.Link != "")
+	if err != nil {
+		return nil, err
+	}
+
+	var issues []Issue
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errInvalidField("content", "Invalid CSV: "+err.Error())
+		}
+
+		issue := Issue{
+			Title:       csvField(record, title),
+			Description: csvField(record, description),
+			Link:        csvField(record, link),
+		}
+		if issue.Title == "" {
+			// Spreadsheets often export trailing blank rows
+			continue
+		}
+		issues = append(issues, issue)
+	}
+	return issues, nil
+}
+
+// detectDelimiter picks ";" over "," when the first line has more of them,
+// which is what spreadsheets in pt-BR locales export.
+func detectDelimiter(content string) rune {
+	firstLine := content
+	if index := strings.IndexByte(content, '\n'); index >= 0 {
+		firstLine = content[:index]
+	}
+	if strings.Count(firstLine, ";") > strings.Count(firstLine, ",") {
+		return ';'
+	}
+	return ','
+}
+
+// csvColumnIndex resolves a column reference to an index, or -1 when an
+// optional column is absent.
+func csvColumnIndex(header []string, column string, field string, required bool) (int, error) {
+	if number, err := strconv.Atoi(column); err == nil {
+		if number < 1 {
+			return 0, errInvalidField("columns."+field, "Column numbers start at 1")
+		}
+		return number - 1, nil
+	}
+
+	for i, name := range header {
+		if strings.EqualFold(strings.TrimSpace(name), column) {
+			return i, nil
+		}
+	}
+	if required {
+		return 0, errInvalidField("columns."+field, "Column "+column+" not found in the CSV header")
+	}
+	return -1, nil
+}
+
+func csvField(record []string, index int) string {
+	if index < 0 || index >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[index])
+}
+
+var (
+	headingPattern      = regexp.MustCompile(`^#{1,6}(\s|$)`)
+	listItemPattern     = regexp.MustCompile(`^(?:[-*+]|\d+[.)])(?:\s+|$)(?:\[[ xX]\](?:\s+|$))?`)
+	markdownLinkPattern = regexp.MustCompile(`^\[([^\]]+)\]\(([^)\s]+)\)$`)
+)
+
+// parseListIssues reads one issue per line or bullet. "[title](url)" items
+// set the link, and indented lines below an item become its description.
+// Headings, blank lines and horizontal rules are ignored.
+func parseListIssues(content string) []Issue {
+	var issues []Issue
+	for _, line := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || headingPattern.MatchString(trimmed) || trimmed == "---" || trimmed == "***" {
+			continue
+		}
+
+		indented := strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+		if indented && len(issues) > 0 && !listItemPattern.MatchString(trimmed) {
+			last := &issues[len(issues)-1]
+			if last.Description != "" {
+				last.Description += "\n"
+			}
+			last.Description += trimmed
+			continue
+		}
+
+		title := strings.TrimSpace(listItemPattern.ReplaceAllString(trimmed, ""))
+		if title == "" {
+			continue
+		}
+
+		issue := Issue{Title: title}
+		if match := markdownLinkPattern.FindStringSubmatch(title); match != nil {
+			issue.Title = match[1]
+			issue.Link = match[2]
+		}
+		issues = append(issues, issue)
+	}
+	return issues
+}
+
+func apiBulkImportIssues(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req BulkImportRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+
+		issues, err := parseBulkIssues(req)
+		if handleError(w, err) {
+			return
+		}
+
+		game, err := writableGame(database, mux.Vars(r)["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+		if _, err := requireRoomAdmin(database, game.roomID, req.UserUUID); handleError(w, err) {
+			return
+		}
+
+		imported, err := addIssues(database, game, issues)
+		if handleError(w, err) {
+			return
+		}
+		touchRoom(game)
+		sendGameState(game)
+
+		sendResponseWithStatus(w, http.StatusCreated, BulkImportResponse{
+			Imported: imported,
+		})
+	}
+}
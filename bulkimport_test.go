@@ -0,0 +1,177 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBulkIssuesCSV(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      BulkImportRequest
+		want     []Issue
+		wantCode string
+	}{
+		{
+			name: "default columns",
+			req:  BulkImportRequest{Format: "csv", Content: "Title,Description,Link\nLogin,\"Form, with comma\",https://example.com/1\n"},
+			want: []Issue{{Title: "Login", Description: "Form, with comma", Link: "https://example.com/1"}},
+		},
+		{
+			name: "semicolons detected",
+			req:  BulkImportRequest{Format: "csv", Content: "title;description\nLogin;Form, with comma\nLogout;\n"},
+			want: []Issue{{Title: "Login", Description: "Form, with comma"}, {Title: "Logout"}},
+		},
+		{
+			name: "mapped by header name",
+			req:  BulkImportRequest{Format: "csv", Content: "Key,Summary\nABC-1,Login\n", Columns: CSVColumns{Title: "summary", Description: "key"}},
+			want: []Issue{{Title: "Login", Description: "ABC-1"}},
+		},
+		{
+			name: "mapped by column number without header",
+			req:  BulkImportRequest{Format: "csv", Content: "ABC-1\tLogin\n", NoHeader: true, Delimiter: "\t", Columns: CSVColumns{Title: "2"}},
+			want: []Issue{{Title: "Login"}},
+		},
+		{
+			name: "trailing blank rows skipped",
+			req:  BulkImportRequest{Format: "csv", Content: "title\nLogin\n,\n\n"},
+			want: []Issue{{Title: "Login"}},
+		},
+		{
+			name: "short rows",
+			req:  BulkImportRequest{Format: "csv", Content: "title,description,link\nLogin\n"},
+			want: []Issue{{Title: "Login"}},
+		},
+		{
+			name:     "missing title column",
+			req:      BulkImportRequest{Format: "csv", Content: "summary\nLogin\n"},
+			wantCode: "invalid_field",
+		},
+		{
+			name:     "mapped column missing",
+			req:      BulkImportRequest{Format: "csv", Content: "title\nLogin\n", Columns: CSVColumns{Title: "title", Link: "url"}},
+			wantCode: "invalid_field",
+		},
+		{
+			name:     "column number zero",
+			req:      BulkImportRequest{Format: "csv", Content: "Login\n", NoHeader: true, Columns: CSVColumns{Title: "0"}},
+			wantCode: "invalid_field",
+		},
+		{
+			name:     "multi-character delimiter",
+			req:      BulkImportRequest{Format: "csv", Content: "title\nLogin\n", Delimiter: "||"},
+			wantCode: "invalid_field",
+		},
+		{
+			name:     "unterminated quote",
+			req:      BulkImportRequest{Format: "csv", Content: "title\n\"Login\n"},
+			wantCode: "invalid_field",
+		},
+		{
+			name:     "header only",
+			req:      BulkImportRequest{Format: "csv", Content: "title\n"},
+			wantCode: "invalid_field",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkBulkIssues(t, tt.req, tt.want, tt.wantCode)
+		})
+	}
+}
+
+func TestParseBulkIssuesList(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      BulkImportRequest
+		want     []Issue
+		wantCode string
+	}{
+		{
+			name: "bullets, numbers and task boxes",
+			req:  BulkImportRequest{Format: "markdown", Content: "- Login\n* Logout\n1. Signup\n2) Reset password\n- [ ] Profile\n- [x] Settings\n"},
+			want: []Issue{{Title: "Login"}, {Title: "Logout"}, {Title: "Signup"}, {Title: "Reset password"}, {Title: "Profile"}, {Title: "Settings"}},
+		},
+		{
+			name: "headings, rules and blank lines ignored",
+			req:  BulkImportRequest{Format: "markdown", Content: "# Sprint 12\n\n## Auth\n- Login\n---\n***\n\r\n- Logout\r\n"},
+			want: []Issue{{Title: "Login"}, {Title: "Logout"}},
+		},
+		{
+			name: "links",
+			req:  BulkImportRequest{Format: "markdown", Content: "- [Login](https://example.com/1)\n- [Logout] (not a link)\n"},
+			want: []Issue{{Title: "Login", Link: "https://example.com/1"}, {Title: "[Logout] (not a link)"}},
+		},
+		{
+			name: "indented lines become the description",
+			req:  BulkImportRequest{Format: "markdown", Content: "- Login\n  Use the new form\n\tKeep SSO\n  - Nested item\n"},
+			want: []Issue{{Title: "Login", Description: "Use the new form\nKeep SSO"}, {Title: "Nested item"}},
+		},
+		{
+			name: "plain text lines",
+			req:  BulkImportRequest{Format: "text", Content: "Login\nLogout\n"},
+			want: []Issue{{Title: "Login"}, {Title: "Logout"}},
+		},
+		{
+			name: "empty bullets skipped",
+			req:  BulkImportRequest{Format: "markdown", Content: "- Login\n- \n-\n- [ ]\n"},
+			want: []Issue{{Title: "Login"}},
+		},
+		{
+			name:     "only headings",
+			req:      BulkImportRequest{Format: "markdown", Content: "# Sprint 12\n"},
+			wantCode: "invalid_field",
+		},
+		{
+			name:     "title too long",
+			req:      BulkImportRequest{Format: "text", Content: strings.Repeat("é", 256)},
+			wantCode: "invalid_field",
+		},
+		{
+			name:     "too many issues",
+			req:      BulkImportRequest{Format: "text", Content: strings.Repeat("Issue\n", maxBulkIssues+1)},
+			wantCode: "invalid_field",
+		},
+		{
+			name:     "blank content",
+			req:      BulkImportRequest{Format: "text", Content: " \n "},
+			wantCode: "missing_field",
+		},
+		{
+			name:     "missing format",
+			req:      BulkImportRequest{Content: "Login"},
+			wantCode: "missing_field",
+		},
+		{
+			name:     "unknown format",
+			req:      BulkImportRequest{Format: "xlsx", Content: "Login"},
+			wantCode: "invalid_field",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkBulkIssues(t, tt.req, tt.want, tt.wantCode)
+		})
+	}
+}
+
+func checkBulkIssues(t *testing.T, req BulkImportRequest, want []Issue, wantCode string) {
+	t.Helper()
+	issues, err := parseBulkIssues(req)
+	if wantCode != "" {
+		if err == nil {
+			t.Fatalf("got issues %+v, want error %s", issues, wantCode)
+		}
+		if code := toAPIError(err).Code; code != wantCode {
+			t.Fatalf("got error %s (%v), want %s", code, err, wantCode)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(issues, want) {
+		t.Errorf("got %+v, want %+v", issues, want)
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// ChatPolicy limits the size and pace of chat messages.
+type ChatPolicy struct {
+	MaxLength int
+	RateLimit int
+	Window    time.Duration
+	Backfill  int
+}
+
+// chatPolicy holds the defaults until loadChatPolicy reads the CHAT_*
+// variables at startup.
+var chatPolicy = ChatPolicy{
+	MaxLength: 1000,
+	RateLimit: 10,
+	Window:    30 * time.Second,
+	Backfill:  50,
+}
+
+// chatMu guards the send times of every game.
+var chatMu sync.Mutex
+
+var errChatMessageNotFound = newAPIError(http.StatusNotFound, "chat_message_not_found", "Chat message not found")
+
+// ChatMessage is a message of the room chat. IssueUUID is set when the
+// message is about an issue of the backlog.
+type ChatMessage struct {
+	UUID      string    `json:"uuid"`
+	UserUUID  *string   `json:"userUUID"`
+	Name      string    `json:"name"`
+	Text      string    `json:"text"`
+	IssueUUID *string   `json:"issueUUID"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type ChatRequest struct {
+	UserUUID  string `json:"userUUID"`
+	Text      string `json:"text"`
+	IssueUUID string `json:"issueUUID"`
+}
+
+type ChatMessageResponse struct {
+	Message ChatMessage `json:"message"`
+}
+
+type ChatHistoryResponse struct {
+	Messages []ChatMessage `json:"messages"`
+}
+
+// Websocket events of the chat
+type ChatEvent struct {
+	Type    string      `json:"type"`
+	Message ChatMessage `json:"message"`
+}
+
+type ChatDeletedEvent struct {
+	Type        string `json:"type"`
+	MessageUUID string `json:"messageUUID"`
+}
+
+type ChatHistoryEvent struct {
+	Type     string        `json:"type"`
+	Messages []ChatMessage `json:"messages"`
+}
+
+type ChatRejectedEvent struct {
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func loadChatPolicy() ChatPolicy {
+	return ChatPolicy{
+		MaxLength: envInt("CHAT_MAX_LENGTH", 1000),
+		RateLimit: envInt("CHAT_RATE_LIMIT", 10),
+		Window:    time.Duration(envInt("CHAT_RATE_WINDOW_SECONDS", 30)) * time.Second,
+		Backfill:  envInt("CHAT_BACKFILL", 50),
+	}
+}
+
+// postChatMessage stores a message from userID and broadcasts it to the room.
+func postChatMessage(database *sql.DB, game *Game, userID int, text string, issueUUID string) (ChatMessage, error) {
+	if game.archived {
+		return ChatMessage{}, errRoomArchived
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return ChatMessage{}, errMissingField("text")
+	}
+	if utf8.RuneCountInString(text) > chatPolicy.MaxLength {
+		return ChatMessage{}, errInvalidField("text", "text must be at most "+strconv.Itoa(chatPolicy.MaxLength)+" characters")
+	}
+
+	issueID := 0
+	if issueUUID != "" {
+		issue, ok := findIssue(game, issueUUID)
+		if !ok {
+			return ChatMessage{}, errIssueNotFound
+		}
+		issueID = issue.ID
+	}
+
+	chatMu.Lock()
+	if game.chatSent == nil {
+		game.chatSent = map[int][]time.Time{}
+	}
+	allowed := allowRate(game.chatSent, userID, time.Now(), chatPolicy.RateLimit, chatPolicy.Window)
+	chatMu.Unlock()
+	if !allowed {
+		return ChatMessage{}, newAPIError(http.StatusTooManyRequests, "rate_limited", "Too many messages, slow down")
+	}
+
+	name, userUUID, err := authorOf(database, game, userID)
+	if err != nil {
+		return ChatMessage{}, err
+	}
+
+	message := ChatMessage{
+		UUID: generateUuid(),
+		Name: name,
+		Text: text,
+	}
+	err = database.QueryRow(`INSERT INTO chat_messages (uuid, room_id, issue_id, user_id, name, body)
+		VALUES ($1, $2, NULLIF($3, 0), $4, $5, $6)
+		RETURNING created_at`,
+		message.UUID, game.roomID, issueID, userID, name, text).Scan(&message.CreatedAt)
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	message.UserUUID = &userUUID
+	if issueUUID != "" {
+		message.IssueUUID = &issueUUID
+	}
+
+	broadcast(game, ChatEvent{Type: "chat", Message: message})
+	return message, nil
+}
+
+// deleteChatMessage hides a message from the history. Only the room admin
+// moderates the chat.
+func deleteChatMessage(database *sql.DB, game *Game, userID int, messageUUID string) error {
+	if game.archived {
+		return errRoomArchived
+	}
+	if !isFacilitator(game, userID) {
+		return errForbidden
+	}
+	if !isValidUUID(messageUUID) {
+		return errChatMessageNotFound
+	}
+
+	result, err := database.Exec(`UPDATE chat_messages SET deleted_at = CURRENT_TIMESTAMP, deleted_by = $1
+		WHERE room_id = $2 AND uuid = $3 AND deleted_at IS NULL`, userID, game.roomID, messageUUID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return errChatMessageNotFound
+	}
+
+	broadcast(game, ChatDeletedEvent{Type: "chatDeleted", MessageUUID: messageUUID})
+	return nil
+}
+
+// fetchChatHistory returns up to limit messages, oldest first, optionally
+// only those about an issue and only those older than the before message.
+func fetchChatHistory(database *sql.DB, roomID int, issueUUID string, before string, limit int) ([]ChatMessage, error) {
+	rows, err := database.Query(`SELECT c.uuid, u.uuid, c.name, c.body, i.uuid, c.created_at
+		FROM chat_messages c
+		LEFT JOIN users u ON u.id = c.user_id
+		LEFT JOIN issues i ON i.id = c.issue_id
+		WHERE c.room_id = $1 AND c.deleted_at IS NULL
+			AND ($2 = '' OR i.uuid::text = $2)
+			AND ($3 = '' OR c.id < (SELECT id FROM chat_messages WHERE room_id = $1 AND uuid::text = $3))
+		ORDER BY c.id DESC
+		LIMIT $4`, roomID, issueUUID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []ChatMessage{}
+	for rows.Next() {
+		var message ChatMessage
+		var userUUID, name, issue sql.NullString
+		if err := rows.Scan(&message.UUID, &userUUID, &name, &message.Text, &issue, &message.CreatedAt); err != nil {
+			return nil, err
+		}
+		message.Name = name.String
+		if userUUID.Valid {
+			message.UserUUID = &userUUID.String
+		}
+		if issue.Valid {
+			message.IssueUUID = &issue.String
+		}
+		messages = append(messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+// sendChatHistory backfills the latest messages to a new connection.
+func sendChatHistory(database *sql.DB, game *Game, ws *websocket.Conn) {
+	if chatPolicy.Backfill <= 0 {
+		return
+	}
+	messages, err := fetchChatHistory(database, game.roomID, "", "", chatPolicy.Backfill)
+	if err != nil {
+		log.Printf("Error fetching chat history of room %s: %v", game.roomUUID, err)
+		return
+	}
+	if err := ws.WriteJSON(ChatHistoryEvent{Type: "chatHistory", Messages: messages}); err != nil {
+		log.Printf("Error writing chat history: %v", err)
+	}
+}
+
+func rejectChat(ws *websocket.Conn, err error) {
+	if ws == nil {
+		return
+	}
+	apiErr := toAPIError(err)
+	if werr := ws.WriteJSON(ChatRejectedEvent{Type: "chatRejected", Code: apiErr.Code, Message: apiErr.Message}); werr != nil {
+		log.Printf("Error writing chat rejection: %v", werr)
+	}
+}
+
+func apiListChat(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		limit := chatPolicy.Backfill
+		if value := query.Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 || parsed > 200 {
+				handleError(w, errInvalidField("limit", "limit must be between 1 and 200"))
+				return
+			}
+			limit = parsed
+		}
+		before := query.Get("before")
+		if before != "" && !isValidUUID(before) {
+			handleError(w, errInvalidField("before", "before must be a message uuid"))
+			return
+		}
+
+		roomID, err := findRoomID(database, mux.Vars(r)["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+		if _, err := requireRoomMember(database, roomID, query.Get("userUUID")); handleError(w, err) {
+			return
+		}
+
+		messages, err := fetchChatHistory(database, roomID, query.Get("issueUUID"), before, limit)
+		if handleError(w, err) {
+			return
+		}
+		sendResponse(w, ChatHistoryResponse{Messages: messages})
+	}
+}
+
+func apiPostChat(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+
+		game, err := loadGame(database, mux.Vars(r)["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+		userID, err := requireRoomMember(database, game.roomID, req.UserUUID)
+		if handleError(w, err) {
+			return
+		}
+
+		message, err := postChatMessage(database, game, userID, req.Text, req.IssueUUID)
+		if handleError(w, err) {
+			return
+		}
+		touchRoom(game)
+
+		sendResponseWithStatus(w, http.StatusCreated, ChatMessageResponse{Message: message})
+	}
+}
+
+func apiDeleteChat(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		game, err := loadGame(database, vars["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+		userID, err := findUserID(database, r.URL.Query().Get("userUUID"))
+		if handleError(w, err) {
+			return
+		}
+
+		if err := deleteChatMessage(database, game, userID, vars["messageUUID"]); handleError(w, err) {
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
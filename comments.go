@@ -0,0 +1,322 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// maxCommentLength bounds comments and decision notes, in characters.
+const maxCommentLength = 4000
+
+var errCommentNotFound = newAPIError(http.StatusNotFound, "comment_not_found", "Comment not found")
+
+// IssueComment is a note left on an issue during estimation. Replies answer
+// a comment and are nested under it, oldest first.
+type IssueComment struct {
+	UUID       string         `json:"uuid"`
+	ParentUUID *string        `json:"parentUUID"`
+	UserUUID   *string        `json:"userUUID"`
+	Name       string         `json:"name"`
+	Text       string         `json:"text"`
+	CreatedAt  time.Time      `json:"createdAt"`
+	Replies    []IssueComment `json:"replies"`
+}
+
+type CommentRequest struct {
+	UserUUID   string `json:"userUUID"`
+	Text       string `json:"text"`
+	ParentUUID string `json:"parentUUID"`
+}
+
+type CommentResponse struct {
+	Comment IssueComment `json:"comment"`
+}
+
+type CommentsResponse struct {
+	Comments []IssueComment `json:"comments"`
+}
+
+// Websocket events of the comments
+type CommentEvent struct {
+	Type      string       `json:"type"`
+	IssueUUID string       `json:"issueUUID"`
+	Comment   IssueComment `json:"comment"`
+}
+
+type CommentDeletedEvent struct {
+	Type        string `json:"type"`
+	IssueUUID   string `json:"issueUUID"`
+	CommentUUID string `json:"commentUUID"`
+}
+
+func findIssue(game *Game, issueUUID string) (Issue, bool) {
+	for _, issue := range game.issues {
+		if issue.UUID == issueUUID {
+			return issue, true
+		}
+	}
+	return Issue{}, false
+}
+
+// addIssueComment stores a comment, or a reply when parentUUID is set, and
+// broadcasts it to the room.
+func addIssueComment(database *sql.DB, game *Game, userID int, issueUUID string, parentUUID string, text string) (IssueComment, error) {
+	if game.archived {
+		return IssueComment{}, errRoomArchived
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return IssueComment{}, errMissingField("text")
+	}
+	if utf8.RuneCountInString(text) > maxCommentLength {
+		return IssueComment{}, errInvalidField("text", fmt.Sprintf("text must be at most %d characters", maxCommentLength))
+	}
+
+	issue, ok := findIssue(game, issueUUID)
+	if !ok {
+		return IssueComment{}, errIssueNotFound
+	}
+
+	var parentID sql.NullInt64
+	if parentUUID != "" {
+		if !isValidUUID(parentUUID) {
+			return IssueComment{}, errCommentNotFound
+		}
+		err := database.QueryRow("SELECT id FROM issue_comments WHERE issue_id = $1 AND uuid = $2", issue.ID, parentUUID).Scan(&parentID)
+		if err == sql.ErrNoRows {
+			return IssueComment{}, errCommentNotFound
+		}
+		if err != nil {
+			return IssueComment{}, err
+		}
+	}
+
+	name, userUUID, err := authorOf(database, game, userID)
+	if err != nil {
+		return IssueComment{}, err
+	}
+
+	comment := IssueComment{
+		UUID:    generateUuid(),
+		Name:    name,
+		Text:    text,
+		Replies: []IssueComment{},
+	}
+	err = database.QueryRow(`INSERT INTO issue_comments (uuid, issue_id, parent_id, user_id, name, body)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at`,
+		comment.UUID, issue.ID, parentID, userID, name, text).Scan(&comment.CreatedAt)
+	if err != nil {
+		return IssueComment{}, err
+	}
+	comment.UserUUID = &userUUID
+	if parentUUID != "" {
+		comment.ParentUUID = &parentUUID
+	}
+
+	broadcast(game, CommentEvent{Type: "issueComment", IssueUUID: issueUUID, Comment: comment})
+	return comment, nil
+}
+
+// deleteIssueComment removes a comment with its replies. Authors delete
+// their own comments; the room admin deletes any.
+func deleteIssueComment(database *sql.DB, game *Game, userID int, issueUUID string, commentUUID string) error {
+	if game.archived {
+		return errRoomArchived
+	}
+	issue, ok := findIssue(game, issueUUID)
+	if !ok {
+		return errIssueNotFound
+	}
+	if !isValidUUID(commentUUID) {
+		return errCommentNotFound
+	}
+
+	var commentID int
+	var authorID sql.NullInt64
+	err := database.QueryRow("SELECT id, user_id FROM issue_comments WHERE issue_id = $1 AND uuid = $2", issue.ID, commentUUID).Scan(&commentID, &authorID)
+	if err == sql.ErrNoRows {
+		return errCommentNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !isFacilitator(game, userID) && (!authorID.Valid || int(authorID.Int64) != userID) {
+		return errForbidden
+	}
+
+	if _, err := database.Exec("DELETE FROM issue_comments WHERE id = $1", commentID); err != nil {
+		return err
+	}
+
+	broadcast(game, CommentDeletedEvent{Type: "issueCommentDeleted", IssueUUID: issueUUID, CommentUUID: commentUUID})
+	return nil
+}
+
+// fetchIssueComments returns the comment threads of an issue.
+func fetchIssueComments(database *sql.DB, issueID int) ([]IssueComment, error) {
+	comments, err := queryComments(database, "c.issue_id = $1", issueID)
+	if err != nil {
+		return nil, err
+	}
+	return threadComments(comments[issueID]), nil
+}
+
+// fetchRoomComments returns the comment threads of every issue of the room,
+// keyed by issue id, with a single query.
+func fetchRoomComments(database *sql.DB, roomID int) (map[int][]IssueComment, error) {
+	comments, err := queryComments(database, "c.issue_id IN (SELECT id FROM issues WHERE room_id = $1)", roomID)
+	if err != nil {
+		return nil, err
+	}
+	for issueID := range comments {
+		comments[issueID] = threadComments(comments[issueID])
+	}
+	return comments, nil
+}
+
+// queryComments reads the comments matching where, in posting order and
+// grouped by issue id but not threaded yet.
+func queryComments(database *sql.DB, where string, args ...interface{}) (map[int][]IssueComment, error) {
+	rows, err := database.Query(`SELECT c.issue_id, c.uuid, p.uuid, u.uuid, c.name, c.body, c.created_at
+		FROM issue_comments c
+		LEFT JOIN issue_comments p ON p.id = c.parent_id
+		LEFT JOIN users u ON u.id = c.user_id
+		WHERE `+where+`
+		ORDER BY c.id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching issue comments: %v", err)
+	}
+	defer rows.Close()
+
+	comments := make(map[int][]IssueComment)
+	for rows.Next() {
+		var issueID int
+		var comment IssueComment
+		var parentUUID, userUUID, name sql.NullString
+		if err := rows.Scan(&issueID, &comment.UUID, &parentUUID, &userUUID, &name, &comment.Text, &comment.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning issue comment: %v", err)
+		}
+		comment.Name = name.String
+		if parentUUID.Valid {
+			comment.ParentUUID = &parentUUID.String
+		}
+		if userUUID.Valid {
+			comment.UserUUID = &userUUID.String
+		}
+		comments[issueID] = append(comments[issueID], comment)
+	}
+	return comments, rows.Err()
+}
+
+// threadComments nests every comment under its parent.
+func threadComments(comments []IssueComment) []IssueComment {
+	replies := make(map[string][]IssueComment)
+	roots := []IssueComment{}
+	for _, comment := range comments {
+		if comment.ParentUUID == nil {
+			roots = append(roots, comment)
+		} else {
+			replies[*comment.ParentUUID] = append(replies[*comment.ParentUUID], comment)
+		}
+	}
+
+	var attach func(thread []IssueComment) []IssueComment
+	attach = func(thread []IssueComment) []IssueComment {
+		if thread == nil {
+			return []IssueComment{}
+		}
+		for i := range thread {
+			thread[i].Replies = attach(replies[thread[i].UUID])
+		}
+		return thread
+	}
+	return attach(roots)
+}
+
+// walkComments visits every comment of the threads depth first, in the order
+// they were posted.
+func walkComments(comments []IssueComment, depth int, visit func(comment IssueComment, depth int)) {
+	for _, comment := range comments {
+		visit(comment, depth)
+		walkComments(comment.Replies, depth+1, visit)
+	}
+}
+
+func apiListComments(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		game, err := loadGame(database, vars["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+		if _, err := requireRoomAccess(database, game.roomID, r.URL.Query().Get("userUUID")); handleError(w, err) {
+			return
+		}
+		issue, ok := findIssue(game, vars["issueUUID"])
+		if !ok {
+			handleError(w, errIssueNotFound)
+			return
+		}
+
+		comments, err := fetchIssueComments(database, issue.ID)
+		if handleError(w, err) {
+			return
+		}
+		sendResponse(w, CommentsResponse{Comments: comments})
+	}
+}
+
+func apiCreateComment(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		var req CommentRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+
+		game, err := loadGame(database, vars["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+		userID, err := requireRoomMember(database, game.roomID, req.UserUUID)
+		if handleError(w, err) {
+			return
+		}
+
+		comment, err := addIssueComment(database, game, userID, vars["issueUUID"], req.ParentUUID, req.Text)
+		if handleError(w, err) {
+			return
+		}
+		touchRoom(game)
+
+		sendResponseWithStatus(w, http.StatusCreated, CommentResponse{Comment: comment})
+	}
+}
+
+func apiDeleteComment(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		game, err := loadGame(database, vars["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+		userID, err := findUserID(database, r.URL.Query().Get("userUUID"))
+		if handleError(w, err) {
+			return
+		}
+
+		if err := deleteIssueComment(database, game, userID, vars["issueUUID"], vars["commentUUID"]); handleError(w, err) {
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
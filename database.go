@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -38,27 +39,44 @@ func setupDatabase() *sql.DB {
 func fetchGameFromDB(db *sql.DB, roomUUID string) (*Game, error) {
 	query := `
 		SELECT 
-			r.id, r.uuid, r.name, r.showCards, r.autoShowCards, r.admin, r.lastActive
+			r.id, r.uuid, r.name, r.showCards, r.admin, r.lastActive, r.archived_at IS NOT NULL,
+			COALESCE(r.current_issue_id, 0), COALESCE(ci.uuid::text, ''), r.round_started_at, r.deck, r.settings,
+			r.persistent, COALESCE(r.team_id, 0), COALESCE(t.uuid::text, '')
 		FROM 
 			rooms r
+		LEFT JOIN 
+			issues ci ON ci.id = r.current_issue_id
+		LEFT JOIN 
+			teams t ON t.id = r.team_id
 		WHERE 
 			r.uuid = $1
 	`
 
 	var game Game
 	var lastActive sql.NullTime
+	var roundStartedAt sql.NullTime
+	var deck sql.NullString
+	var settings []byte
 
 	err := db.QueryRow(query, roomUUID).Scan(
 		&game.roomID,
 		&game.roomUUID,
 		&game.name,
 		&game.showCards,
-		&game.autoShowCards,
 		&game.admin,
 		&lastActive,
+		&game.archived,
+		&game.currentIssueID,
+		&game.currentIssueUUID,
+		&roundStartedAt,
+		&deck,
+		&settings,
+		&game.persistent,
+		&game.teamID,
+		&game.teamUUID,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("error fetching game from DB: %v", err)
+		return nil, fmt.Errorf("error fetching game from DB: %w", err)
 	}
 
 	if lastActive.Valid {
@@ -66,6 +84,10 @@ func fetchGameFromDB(db *sql.DB, roomUUID string) (*Game, error) {
 	} else {
 		game.lastActive = time.Now() // Or set to a default time
 	}
+	if roundStartedAt.Valid {
+		game.roundStartedAt = roundStartedAt.Time
+	}
+	game.settings = parseRoomSettings(settings)
 
 	players, err := fetchPlayersFromDB(db, game.roomID)
 	if err != nil {
@@ -73,13 +95,87 @@ func fetchGameFromDB(db *sql.DB, roomUUID string) (*Game, error) {
 	}
 	game.Players = players
 
+	if deck.Valid && deck.String != "" {
+		if err := json.Unmarshal([]byte(deck.String), &game.deck); err != nil {
+			log.Printf("Error decoding deck of room %s: %v", roomUUID, err)
+		}
+	}
+
+	issues, err := fetchIssuesFromDB(db, game.roomID)
+	if err != nil {
+		return nil, err
+	}
+	game.issues = issues
+
+	if err := refreshTeamAdmins(db, &game); err != nil {
+		return nil, err
+	}
+
+	if game.persistent {
+		session, err := fetchOpenSession(db, game.roomID)
+		if err != nil {
+			return nil, err
+		}
+		game.session = session
+	}
+
 	return &game, nil
 }
 
+// fetchRoomsForUser lists the rooms the user belongs to that were active since the given time.
+func fetchRoomsForUser(db *sql.DB, userID int, activeSince time.Time) ([]RoomSummary, error) {
+	// Buffered activity would otherwise make recently used rooms look stale
+	if err := roomActivity.flush(db); err != nil {
+		log.Printf("Error flushing room activity: %v", err)
+	}
+
+	query := `
+		SELECT 
+			r.uuid, r.name, r.lastActive, r.archived_at IS NOT NULL, r.persistent, COUNT(members.user_id)
+		FROM 
+			rooms r
+		JOIN 
+			room_users ru ON ru.room_id = r.id AND ru.user_id = $1
+		LEFT JOIN 
+			room_users members ON members.room_id = r.id
+		WHERE 
+			r.lastActive >= $2 OR r.persistent
+		GROUP BY 
+			r.id
+		ORDER BY 
+			r.lastActive DESC
+	`
+
+	rows, err := db.Query(query, userID, activeSince)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching rooms from DB: %v", err)
+	}
+	defer rows.Close()
+
+	rooms := []RoomSummary{}
+	for rows.Next() {
+		var room RoomSummary
+		var name sql.NullString
+		if err := rows.Scan(&room.RoomUUID, &name, &room.LastActive, &room.Archived, &room.Persistent, &room.Members); err != nil {
+			return nil, fmt.Errorf("error scanning room from DB: %v", err)
+		}
+		room.Name = name.String
+		rooms = append(rooms, room)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rooms rows: %v", err)
+	}
+
+	return rooms, nil
+}
+
 func fetchPlayersFromDB(db *sql.DB, roomID int) ([]*Player, error) {
 	query := `
 		SELECT 
-			u.id, u.uuid, u.name
+			u.id, u.uuid, u.name,
+			COALESCE((SELECT SUM(ps.points) FROM player_scores ps WHERE ps.room_id = ru.room_id AND ps.user_id = u.id), 0),
+			ru.observer
 		FROM 
 			users u
 		JOIN 
@@ -101,6 +197,8 @@ func fetchPlayersFromDB(db *sql.DB, roomID int) ([]*Player, error) {
 			&player.ID,
 			&player.UUID,
 			&player.Name,
+			&player.Score,
+			&player.Observer,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning player from DB: %v", err)
@@ -114,3 +212,103 @@ func fetchPlayersFromDB(db *sql.DB, roomID int) ([]*Player, error) {
 
 	return players, nil
 }
+
+func fetchIssuesFromDB(db *sql.DB, roomID int) ([]Issue, error) {
+	return fetchIssuePage(db, roomID, IssueFilter{})
+}
+
+// IssueFilter narrows down and pages the issues of a room. A nil Estimated
+// returns estimated and unestimated issues alike, and a zero Limit returns
+// every issue from Offset on.
+type IssueFilter struct {
+	Estimated *bool
+	Limit     int
+	Offset    int
+}
+
+// fetchIssuePage returns the room's issues in backlog order.
+func fetchIssuePage(db *sql.DB, roomID int, filter IssueFilter) ([]Issue, error) {
+	query := `
+		SELECT 
+			i.id, i.uuid, i.title, i.description, i.link, i.sequence, i.estimate, i.decision_note,
+			COALESCE(i.external_source, ''), COALESCE(i.external_key, ''),
+			(SELECT COUNT(*) FROM rounds ro WHERE ro.issue_id = i.id)
+		FROM 
+			issues i
+		WHERE 
+			i.room_id = $1 AND ($2::boolean IS NULL OR (i.estimate IS NOT NULL) = $2)
+		ORDER BY 
+			i.sequence, i.id
+		LIMIT $3 OFFSET $4
+	`
+
+	var limit sql.NullInt64
+	if filter.Limit > 0 {
+		limit = sql.NullInt64{Int64: int64(filter.Limit), Valid: true}
+	}
+
+	rows, err := db.Query(query, roomID, nullableBool(filter.Estimated), limit, filter.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching issues from DB: %v", err)
+	}
+	defer rows.Close()
+
+	issues := []Issue{}
+	for rows.Next() {
+		var issue Issue
+		var title, description, link, estimate, decisionNote sql.NullString
+		var sequence sql.NullInt64
+		err := rows.Scan(
+			&issue.ID,
+			&issue.UUID,
+			&title,
+			&description,
+			&link,
+			&sequence,
+			&estimate,
+			&decisionNote,
+			&issue.Source,
+			&issue.ExternalKey,
+			&issue.Rounds,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning issue from DB: %v", err)
+		}
+		issue.Title = title.String
+		issue.Description = description.String
+		issue.Link = link.String
+		issue.Sequence = int(sequence.Int64)
+		if estimate.Valid {
+			issue.Estimate = &estimate.String
+		}
+		if decisionNote.Valid {
+			issue.DecisionNote = &decisionNote.String
+		}
+		issues = append(issues, issue)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating issues rows: %v", err)
+	}
+
+	return issues, nil
+}
+
+// countIssues returns how many issues of the room match the filter, ignoring
+// its paging.
+func countIssues(db *sql.DB, roomID int, filter IssueFilter) (int, error) {
+	var total int
+	err := db.QueryRow("SELECT COUNT(*) FROM issues WHERE room_id = $1 AND ($2::boolean IS NULL OR (estimate IS NOT NULL) = $2)",
+		roomID, nullableBool(filter.Estimated)).Scan(&total)
+	if err != nil {
+		return 0, fmt.Errorf("error counting issues: %v", err)
+	}
+	return total, nil
+}
+
+func nullableBool(value *bool) sql.NullBool {
+	if value == nil {
+		return sql.NullBool{}
+	}
+	return sql.NullBool{Bool: *value, Valid: true}
+}
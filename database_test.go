@@ -0,0 +1,26 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+)
+
+// openTestDB returns an in-memory SQLite database with the given tables.
+// The queries under test use $n placeholders, which SQLite binds in order.
+func openTestDB(t *testing.T, schema ...string) *sql.DB {
+	t.Helper()
+	database, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: is a new database
+	database.SetMaxOpenConns(1)
+	t.Cleanup(func() { database.Close() })
+
+	for _, statement := range schema {
+		if _, err := database.Exec(statement); err != nil {
+			t.Fatalf("creating test schema: %v", err)
+		}
+	}
+	return database
+}
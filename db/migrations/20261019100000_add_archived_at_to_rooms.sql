@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS rooms_lastactive_idx ON rooms (lastActive) WHERE archived_at IS NULL;
+CREATE INDEX IF NOT EXISTS room_users_user_id_idx ON room_users (user_id);
+CREATE INDEX IF NOT EXISTS rooms_admin_idx ON rooms (admin);
+CREATE INDEX IF NOT EXISTS votes_user_id_idx ON votes (user_id);
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS votes_user_id_idx;
+DROP INDEX IF EXISTS rooms_admin_idx;
+DROP INDEX IF EXISTS room_users_user_id_idx;
+DROP INDEX IF EXISTS rooms_lastactive_idx;
+ALTER TABLE rooms DROP COLUMN IF EXISTS archived_at;
+-- +goose StatementEnd
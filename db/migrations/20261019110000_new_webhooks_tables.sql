@@ -0,0 +1,40 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE issues ADD COLUMN IF NOT EXISTS estimate varchar(16);
+ALTER TABLE issues ADD COLUMN IF NOT EXISTS estimated_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id SERIAL PRIMARY KEY,
+    uuid UUID,
+    room_id INTEGER,
+    url TEXT,
+    secret varchar(64),
+    events TEXT,
+    active BOOLEAN DEFAULT TRUE,
+    FOREIGN KEY(room_id) REFERENCES rooms(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id SERIAL PRIMARY KEY,
+    uuid UUID,
+    webhook_id INTEGER,
+    event varchar(64),
+    payload TEXT,
+    attempt INTEGER,
+    status_code INTEGER,
+    error TEXT,
+    success BOOLEAN DEFAULT FALSE,
+    FOREIGN KEY(webhook_id) REFERENCES webhooks(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, created_at);
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
+ALTER TABLE issues DROP COLUMN IF EXISTS estimated_at;
+ALTER TABLE issues DROP COLUMN IF EXISTS estimate;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE issues ADD COLUMN IF NOT EXISTS external_source varchar(32);
+ALTER TABLE issues ADD COLUMN IF NOT EXISTS external_key varchar(255);
+CREATE UNIQUE INDEX IF NOT EXISTS issues_external_key_idx ON issues (room_id, external_source, external_key) WHERE external_key IS NOT NULL;
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS issues_external_key_idx;
+ALTER TABLE issues DROP COLUMN IF EXISTS external_key;
+ALTER TABLE issues DROP COLUMN IF EXISTS external_source;
+-- +goose StatementEnd
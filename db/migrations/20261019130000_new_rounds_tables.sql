@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS current_issue_id INTEGER REFERENCES issues(id) ON DELETE SET NULL;
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS round_started_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS rounds (
+    id SERIAL PRIMARY KEY,
+    uuid UUID,
+    room_id INTEGER,
+    issue_id INTEGER,
+    started_at TIMESTAMP,
+    revealed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(room_id) REFERENCES rooms(id),
+    FOREIGN KEY(issue_id) REFERENCES issues(id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS rounds_room_id_idx ON rounds (room_id, issue_id);
+
+-- name is a snapshot so history survives guests being purged
+CREATE TABLE IF NOT EXISTS round_votes (
+    id SERIAL PRIMARY KEY,
+    round_id INTEGER,
+    user_id INTEGER,
+    name varchar(255),
+    vote varchar(16),
+    FOREIGN KEY(round_id) REFERENCES rounds(id),
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS round_votes_round_id_idx ON round_votes (round_id);
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS round_votes;
+DROP TABLE IF EXISTS rounds;
+ALTER TABLE rooms DROP COLUMN IF EXISTS round_started_at;
+ALTER TABLE rooms DROP COLUMN IF EXISTS current_issue_id;
+-- +goose StatementEnd
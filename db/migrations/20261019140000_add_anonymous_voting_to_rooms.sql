@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS anonymous BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS keep_vote_attribution BOOLEAN NOT NULL DEFAULT true;
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE rooms DROP COLUMN IF EXISTS keep_vote_attribution;
+ALTER TABLE rooms DROP COLUMN IF EXISTS anonymous;
+-- +goose StatementEnd
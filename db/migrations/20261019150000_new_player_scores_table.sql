@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+-- One row per scored vote: how far it was from the issue's agreed estimate
+CREATE TABLE IF NOT EXISTS player_scores (
+    id SERIAL PRIMARY KEY,
+    room_id INTEGER,
+    issue_id INTEGER,
+    round_id INTEGER,
+    user_id INTEGER,
+    vote varchar(16),
+    estimate varchar(16),
+    distance INTEGER,
+    points INTEGER,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(room_id) REFERENCES rooms(id),
+    FOREIGN KEY(issue_id) REFERENCES issues(id) ON DELETE CASCADE,
+    FOREIGN KEY(round_id) REFERENCES rounds(id) ON DELETE CASCADE,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS player_scores_room_id_idx ON player_scores (room_id, user_id);
+CREATE INDEX IF NOT EXISTS player_scores_user_id_idx ON player_scores (user_id, created_at);
+CREATE INDEX IF NOT EXISTS player_scores_issue_id_idx ON player_scores (issue_id);
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS player_scores;
+-- +goose StatementEnd
@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+-- name is the sender's display name when the message was sent; purging a
+-- guest only nulls user_id, so old messages keep their author
+CREATE TABLE IF NOT EXISTS chat_messages (
+    id SERIAL PRIMARY KEY,
+    uuid UUID,
+    room_id INTEGER,
+    issue_id INTEGER,
+    user_id INTEGER,
+    name varchar(255),
+    body TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP,
+    deleted_by INTEGER,
+    FOREIGN KEY(room_id) REFERENCES rooms(id),
+    FOREIGN KEY(issue_id) REFERENCES issues(id) ON DELETE SET NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE SET NULL,
+    FOREIGN KEY(deleted_by) REFERENCES users(id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS chat_messages_room_id_idx ON chat_messages (room_id, id);
+CREATE UNIQUE INDEX IF NOT EXISTS chat_messages_uuid_idx ON chat_messages (uuid);
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS chat_messages;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE issues ADD COLUMN IF NOT EXISTS decision_note TEXT;
+-- Replies point at the comment they answer; name is a snapshot of the author
+CREATE TABLE IF NOT EXISTS issue_comments (
+    id SERIAL PRIMARY KEY,
+    uuid UUID,
+    issue_id INTEGER,
+    parent_id INTEGER,
+    user_id INTEGER,
+    name varchar(255),
+    body TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(issue_id) REFERENCES issues(id) ON DELETE CASCADE,
+    FOREIGN KEY(parent_id) REFERENCES issue_comments(id) ON DELETE CASCADE,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS issue_comments_issue_id_idx ON issue_comments (issue_id, id);
+CREATE UNIQUE INDEX IF NOT EXISTS issue_comments_uuid_idx ON issue_comments (uuid);
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS issue_comments;
+ALTER TABLE issues DROP COLUMN IF EXISTS decision_note;
+-- +goose StatementEnd
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Room toggles live in one JSON document so new ones need no column
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS settings JSONB NOT NULL DEFAULT '{}';
+UPDATE rooms SET settings = jsonb_build_object(
+    'autoReveal', COALESCE(autoShowCards, false),
+    'anonymous', anonymous,
+    'keepVoteAttribution', keep_vote_attribution
+);
+ALTER TABLE rooms DROP COLUMN IF EXISTS autoShowCards;
+ALTER TABLE rooms DROP COLUMN IF EXISTS anonymous;
+ALTER TABLE rooms DROP COLUMN IF EXISTS keep_vote_attribution;
+ALTER TABLE room_users ADD COLUMN IF NOT EXISTS observer BOOLEAN NOT NULL DEFAULT false;
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE room_users DROP COLUMN IF EXISTS observer;
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS autoShowCards BOOLEAN DEFAULT FALSE;
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS anonymous BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS keep_vote_attribution BOOLEAN NOT NULL DEFAULT true;
+UPDATE rooms SET
+    autoShowCards = COALESCE((settings->>'autoReveal')::boolean, false),
+    anonymous = COALESCE((settings->>'anonymous')::boolean, false),
+    keep_vote_attribution = COALESCE((settings->>'keepVoteAttribution')::boolean, true);
+ALTER TABLE rooms DROP COLUMN IF EXISTS settings;
+-- +goose StatementEnd
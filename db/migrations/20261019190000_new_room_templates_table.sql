@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Deck, settings and issues are stored the way rooms store them
+CREATE TABLE IF NOT EXISTS room_templates (
+    id SERIAL PRIMARY KEY,
+    uuid UUID,
+    owner_id INTEGER,
+    name varchar(255),
+    room_name varchar(255),
+    deck JSONB,
+    settings JSONB,
+    issues JSONB,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(owner_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS room_templates_owner_id_idx ON room_templates (owner_id);
+CREATE UNIQUE INDEX IF NOT EXISTS room_templates_uuid_idx ON room_templates (uuid);
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS room_templates;
+-- +goose StatementEnd
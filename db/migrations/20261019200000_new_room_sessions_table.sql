@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Persistent rooms are never archived and group their rounds in sessions
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS persistent BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE TABLE IF NOT EXISTS room_sessions (
+    id SERIAL PRIMARY KEY,
+    uuid UUID,
+    room_id INTEGER,
+    name varchar(255),
+    started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    ended_at TIMESTAMP,
+    FOREIGN KEY(room_id) REFERENCES rooms(id)
+);
+CREATE INDEX IF NOT EXISTS room_sessions_room_id_idx ON room_sessions (room_id, id);
+CREATE UNIQUE INDEX IF NOT EXISTS room_sessions_uuid_idx ON room_sessions (uuid);
+ALTER TABLE rounds ADD COLUMN IF NOT EXISTS session_id INTEGER REFERENCES room_sessions(id) ON DELETE SET NULL;
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE rounds DROP COLUMN IF EXISTS session_id;
+DROP TABLE IF EXISTS room_sessions;
+ALTER TABLE rooms DROP COLUMN IF EXISTS persistent;
+-- +goose StatementEnd
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	recentEmojiLimit  = 20
+	recentEmojiMaxAge = 5 * time.Minute
+)
+
+// EmojiPolicy restricts the reactions players can throw at each other.
+type EmojiPolicy struct {
+	Allowed   map[string]bool
+	RateLimit int
+	Window    time.Duration
+}
+
+var defaultEmojis = []string{"👍", "👎", "😂", "😮", "🎉", "❤️", "🤔", "☕", "🔥", "👏"}
+
+// emojiPolicy allows the default set until loadEmojiPolicy reads
+// EMOJI_ALLOWED and the rate limit at startup.
+var emojiPolicy = EmojiPolicy{
+	Allowed:   emojiSet(defaultEmojis),
+	RateLimit: 5,
+	Window:    10 * time.Second,
+}
+
+// emojiMu guards the recent reactions and send times of every game.
+var emojiMu sync.Mutex
+
+// EmojiEvent is broadcast on its own for every reaction, instead of a whole
+// gameState.
+type EmojiEvent struct {
+	Type string `json:"type"`
+	EmojiMessage
+}
+
+// EmojiRejected tells the sender why a reaction was dropped.
+type EmojiRejected struct {
+	Type   string `json:"type"`
+	Emoji  string `json:"emoji"`
+	Reason string `json:"reason"`
+}
+
+func loadEmojiPolicy() EmojiPolicy {
+	policy := EmojiPolicy{
+		Allowed:   emojiSet(defaultEmojis),
+		RateLimit: envInt("EMOJI_RATE_LIMIT", 5),
+		Window:    time.Duration(envInt("EMOJI_RATE_WINDOW_SECONDS", 10)) * time.Second,
+	}
+	if value := os.Getenv("EMOJI_ALLOWED"); value != "" {
+		policy.Allowed = emojiSet(strings.Split(value, ","))
+	}
+	return policy
+}
+
+func emojiSet(emojis []string) map[string]bool {
+	set := make(map[string]bool, len(emojis))
+	for _, emoji := range emojis {
+		if emoji = strings.TrimSpace(emoji); emoji != "" {
+			set[emoji] = true
+		}
+	}
+	return set
+}
+
+// throwEmoji validates a reaction and broadcasts it. It returns the reason
+// the reaction was dropped, or "" when it was sent.
+func throwEmoji(game *Game, originUserID int, targetUserID int, emoji string) string {
+	if !game.settings.EmojisEnabled {
+		return "emojis_disabled"
+	}
+	if !emojiPolicy.Allowed[emoji] {
+		return "emoji_not_allowed"
+	}
+	if findPlayer(game, originUserID) == nil || findPlayer(game, targetUserID) == nil {
+		return "player_not_in_room"
+	}
+
+	now := time.Now()
+	message := EmojiMessage{
+		Emoji:        emoji,
+		OriginUserID: originUserID,
+		TargetUserID: targetUserID,
+		SentAt:       now,
+	}
+
+	emojiMu.Lock()
+	if game.emojiSent == nil {
+		game.emojiSent = map[int][]time.Time{}
+	}
+	if !allowRate(game.emojiSent, originUserID, now, emojiPolicy.RateLimit, emojiPolicy.Window) {
+		emojiMu.Unlock()
+		return "rate_limited"
+	}
+
+	game.Emojis = append(game.Emojis, message)
+	if len(game.Emojis) > recentEmojiLimit {
+		game.Emojis = append([]EmojiMessage{}, game.Emojis[len(game.Emojis)-recentEmojiLimit:]...)
+	}
+	emojiMu.Unlock()
+
+	broadcast(game, EmojiEvent{Type: "emoji", EmojiMessage: message})
+	return ""
+}
+
+// recentEmojis returns the last reactions of the room, so players joining
+// late see them too.
+func recentEmojis(game *Game) []EmojiMessage {
+	emojiMu.Lock()
+	defer emojiMu.Unlock()
+
+	recent := []EmojiMessage{}
+	for _, message := range game.Emojis {
+		if time.Since(message.SentAt) < recentEmojiMaxAge {
+			recent = append(recent, message)
+		}
+	}
+	return recent
+}
+
+func findPlayer(game *Game, userID int) *Player {
+	for _, player := range game.Players {
+		if player != nil && player.ID == userID {
+			return player
+		}
+	}
+	return nil
+}
+
+func rejectEmoji(ws *websocket.Conn, emoji string, reason string) {
+	if ws == nil {
+		return
+	}
+	if err := ws.WriteJSON(EmojiRejected{Type: "emojiRejected", Emoji: emoji, Reason: reason}); err != nil {
+		log.Printf("Error writing emoji rejection: %v", err)
+	}
+}
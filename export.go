@@ -0,0 +1,478 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ExportDocument is the JSON export of a room. It is written incrementally,
+// one issue at a time, so large rooms are never held in memory.
+type ExportDocument struct {
+	Room       ExportRoom    `json:"room"`
+	ExportedAt time.Time     `json:"exportedAt"`
+	Issues     []ExportIssue `json:"issues"`
+}
+
+type ExportRoom struct {
+	RoomUUID string `json:"roomUUID"`
+	Name     string `json:"name"`
+}
+
+// ExportIssue is an issue with the rounds voted on it. Rounds that were not
+// about any issue are exported as a last entry with a null uuid.
+type ExportIssue struct {
+	UUID         *string        `json:"uuid"`
+	Sequence     *int           `json:"sequence"`
+	Title        string         `json:"title"`
+	Description  string         `json:"description"`
+	Link         string         `json:"link"`
+	Estimate     *string        `json:"estimate"`
+	DecisionNote *string        `json:"decisionNote"`
+	Comments     []IssueComment `json:"comments"`
+	Rounds       []ExportRound  `json:"rounds"`
+}
+
+type ExportRound struct {
+	Number     int          `json:"number"`
+	StartedAt  *time.Time   `json:"startedAt"`
+	RevealedAt time.Time    `json:"revealedAt"`
+	Votes      []ExportVote `json:"votes"`
+	Stats      RoundStats   `json:"stats"`
+}
+
+type ExportVote struct {
+	UserUUID *string `json:"userUUID"`
+	Name     string  `json:"name"`
+	Vote     string  `json:"vote"`
+}
+
+type exportWriter interface {
+	begin(room ExportRoom, exportedAt time.Time) error
+	issue(issue ExportIssue) error
+	end() error
+}
+
+var exportFormats = map[string]struct {
+	contentType string
+	extension   string
+}{
+	"json":     {"application/json", "json"},
+	"csv":      {"text/csv; charset=utf-8", "csv"},
+	"markdown": {"text/markdown; charset=utf-8", "md"},
+}
+
+// streamRoomExport reads issues, rounds and votes in a single ordered query
+// and hands every issue to emit as soon as all of its rows were read.
+// comments holds the threads of the room by issue id, read beforehand so no
+// other query runs while the export is streamed.
+func streamRoomExport(db *sql.DB, roomID int, comments map[int][]IssueComment, emit func(ExportIssue) error) error {
+	query := `
+		SELECT
+			i.id, i.uuid::text, i.title, i.description, i.link, i.sequence, i.estimate,
+			r.id, r.started_at, r.revealed_at, u.uuid::text, v.name, v.vote, v.id, i.decision_note
+		FROM
+			issues i
+		LEFT JOIN
+			rounds r ON r.issue_id = i.id
+		LEFT JOIN
+			round_votes v ON v.round_id = r.id
+		LEFT JOIN
+			users u ON u.id = v.user_id
+		WHERE
+			i.room_id = $1
+		UNION ALL
+		SELECT
+			NULL, NULL, NULL, NULL, NULL, NULL, NULL,
+			r.id, r.started_at, r.revealed_at, u.uuid::text, v.name, v.vote, v.id, NULL
+		FROM
+			rounds r
+		LEFT JOIN
+			round_votes v ON v.round_id = r.id
+		LEFT JOIN
+			users u ON u.id = v.user_id
+		WHERE
+			r.room_id = $1 AND r.issue_id IS NULL
+		ORDER BY
+			6 NULLS LAST, 1 NULLS LAST, 10, 8, 14
+	`
+
+	rows, err := db.Query(query, roomID)
+	if err != nil {
+		return fmt.Errorf("error fetching export from DB: %v", err)
+	}
+	defer rows.Close()
+
+	var current *ExportIssue
+	var currentIssueID, currentRoundID int64 = -1, -1
+	flush := func() error {
+		if current == nil {
+			return nil
+		}
+		for i := range current.Rounds {
+			votes := make([]string, len(current.Rounds[i].Votes))
+			for j, vote := range current.Rounds[i].Votes {
+				votes[j] = vote.Vote
+			}
+			current.Rounds[i].Stats = computeRoundStats(votes)
+		}
+		if threads, exists := comments[int(currentIssueID)]; exists {
+			current.Comments = threads
+		}
+		return emit(*current)
+	}
+
+	for rows.Next() {
+		var issueID, sequence, roundID, voteID sql.NullInt64
+		var issueUUID, title, description, link, estimate, userUUID, name, vote, decisionNote sql.NullString
+		var startedAt, revealedAt sql.NullTime
+		err := rows.Scan(&issueID, &issueUUID, &title, &description, &link, &sequence, &estimate,
+			&roundID, &startedAt, &revealedAt, &userUUID, &name, &vote, &voteID, &decisionNote)
+		if err != nil {
+			return fmt.Errorf("error scanning export row: %v", err)
+		}
+
+		if current == nil || issueID.Int64 != currentIssueID {
+			if err := flush(); err != nil {
+				return err
+			}
+			current = &ExportIssue{
+				Title:       title.String,
+				Description: description.String,
+				Link:        link.String,
+				Comments:    []IssueComment{},
+				Rounds:      []ExportRound{},
+			}
+			if issueID.Valid {
+				current.UUID = &issueUUID.String
+				position := int(sequence.Int64)
+				current.Sequence = &position
+			}
+			if estimate.Valid {
+				current.Estimate = &estimate.String
+			}
+			if decisionNote.Valid {
+				current.DecisionNote = &decisionNote.String
+			}
+			currentIssueID = issueID.Int64
+			currentRoundID = -1
+		}
+
+		if roundID.Valid && roundID.Int64 != currentRoundID {
+			round := ExportRound{
+				Number:     len(current.Rounds) + 1,
+				RevealedAt: revealedAt.Time,
+				Votes:      []ExportVote{},
+			}
+			if startedAt.Valid {
+				round.StartedAt = &startedAt.Time
+			}
+			current.Rounds = append(current.Rounds, round)
+			currentRoundID = roundID.Int64
+		}
+
+		if voteID.Valid {
+			exportVote := ExportVote{Name: name.String, Vote: vote.String}
+			if userUUID.Valid {
+				exportVote.UserUUID = &userUUID.String
+			}
+			round := &current.Rounds[len(current.Rounds)-1]
+			round.Votes = append(round.Votes, exportVote)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating export rows: %v", err)
+	}
+
+	return flush()
+}
+
+type jsonExportWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (e *jsonExportWriter) begin(room ExportRoom, exportedAt time.Time) error {
+	roomJSON, err := json.Marshal(room)
+	if err != nil {
+		return err
+	}
+	at, _ := exportedAt.MarshalJSON()
+	_, err = fmt.Fprintf(e.w, `{"room":%s,"exportedAt":%s,"issues":[`, roomJSON, at)
+	return err
+}
+
+func (e *jsonExportWriter) issue(issue ExportIssue) error {
+	data, err := json.Marshal(issue)
+	if err != nil {
+		return err
+	}
+	if e.count > 0 {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+	e.count++
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *jsonExportWriter) end() error {
+	_, err := io.WriteString(e.w, "]}\n")
+	return err
+}
+
+// csvExportWriter writes one row per vote. Issues without rounds get a
+// single row with the round columns left empty.
+type csvExportWriter struct {
+	w *csv.Writer
+}
+
+func (e *csvExportWriter) begin(room ExportRoom, exportedAt time.Time) error {
+	return e.w.Write([]string{
+		"issue_sequence", "issue_title", "issue_link", "estimate",
+		"round", "round_started_at", "round_revealed_at", "player", "vote",
+		"round_average", "round_median", "round_min", "round_max", "round_consensus",
+		"decision_note", "comments",
+	})
+}
+
+func (e *csvExportWriter) issue(issue ExportIssue) error {
+	prefix := []string{"", issue.Title, issue.Link, ""}
+	if issue.Sequence != nil {
+		prefix[0] = strconv.Itoa(*issue.Sequence + 1)
+	}
+	if issue.Estimate != nil {
+		prefix[3] = *issue.Estimate
+	}
+	notes := []string{"", csvComments(issue.Comments)}
+	if issue.DecisionNote != nil {
+		notes[0] = *issue.DecisionNote
+	}
+
+	if len(issue.Rounds) == 0 {
+		if err := e.w.Write(append(append(prefix, make([]string, 10)...), notes...)); err != nil {
+			return err
+		}
+	}
+
+	for _, round := range issue.Rounds {
+		roundColumns := []string{strconv.Itoa(round.Number), formatExportTime(round.StartedAt), round.RevealedAt.Format(time.RFC3339)}
+		statsColumns := []string{
+			csvStat(round.Stats.Average), csvStat(round.Stats.Median),
+			csvStat(round.Stats.Min), csvStat(round.Stats.Max),
+			strconv.FormatBool(round.Stats.Consensus),
+		}
+		for _, vote := range round.Votes {
+			record := append(append(append([]string{}, prefix...), roundColumns...), vote.Name, vote.Vote)
+			if err := e.w.Write(append(append(record, statsColumns...), notes...)); err != nil {
+				return err
+			}
+		}
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// csvComments flattens the threads into one cell, a line per comment with
+// replies indented under their parent.
+func csvComments(comments []IssueComment) string {
+	var lines []string
+	walkComments(comments, 0, func(comment IssueComment, depth int) {
+		lines = append(lines, strings.Repeat("  ", depth)+comment.Name+": "+comment.Text)
+	})
+	return strings.Join(lines, "\n")
+}
+
+func (e *csvExportWriter) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type markdownExportWriter struct {
+	w io.Writer
+}
+
+func (e *markdownExportWriter) begin(room ExportRoom, exportedAt time.Time) error {
+	name := room.Name
+	if name == "" {
+		name = room.RoomUUID
+	}
+	_, err := fmt.Fprintf(e.w, "# %s\n\nExported at %s\n", markdownText(name), exportedAt.Format(time.RFC3339))
+	return err
+}
+
+func (e *markdownExportWriter) issue(issue ExportIssue) error {
+	var b strings.Builder
+
+	switch {
+	case issue.Sequence == nil:
+		b.WriteString("\n## Rounds without an issue\n")
+	case markdownURL(issue.Link) != "":
+		fmt.Fprintf(&b, "\n## %d. [%s](%s)\n", *issue.Sequence+1, markdownText(issue.Title), markdownURL(issue.Link))
+	default:
+		fmt.Fprintf(&b, "\n## %d. %s\n", *issue.Sequence+1, markdownText(issue.Title))
+	}
+	if issue.Estimate != nil {
+		fmt.Fprintf(&b, "\n**Estimate:** %s\n", *issue.Estimate)
+	}
+	if issue.Description != "" {
+		fmt.Fprintf(&b, "\n%s\n", issue.Description)
+	}
+	if issue.DecisionNote != nil && *issue.DecisionNote != "" {
+		fmt.Fprintf(&b, "\n**Decision note:** %s\n", markdownText(*issue.DecisionNote))
+	}
+	if len(issue.Comments) > 0 {
+		b.WriteString("\n### Comments\n\n")
+		walkComments(issue.Comments, 0, func(comment IssueComment, depth int) {
+			fmt.Fprintf(&b, "%s- **%s** (%s): %s\n", strings.Repeat("  ", depth), markdownCell(comment.Name),
+				comment.CreatedAt.Format("2006-01-02 15:04"), markdownCell(comment.Text))
+		})
+	}
+
+	for _, round := range issue.Rounds {
+		fmt.Fprintf(&b, "\n### Round %d (%s)\n\n| Player | Vote |\n|--------|------|\n", round.Number, round.RevealedAt.Format("2006-01-02 15:04"))
+		for _, vote := range round.Votes {
+			fmt.Fprintf(&b, "| %s | %s |\n", markdownCell(vote.Name), markdownCell(vote.Vote))
+		}
+
+		consensus := "no"
+		if round.Stats.Consensus {
+			consensus = "yes"
+		}
+		fmt.Fprintf(&b, "\nAverage %s · Median %s · Min %s · Max %s · Consensus: %s\n",
+			formatStat(round.Stats.Average), formatStat(round.Stats.Median),
+			formatStat(round.Stats.Min), formatStat(round.Stats.Max), consensus)
+	}
+
+	_, err := io.WriteString(e.w, b.String())
+	return err
+}
+
+func (e *markdownExportWriter) end() error {
+	return nil
+}
+
+var markdownCellEscaper = strings.NewReplacer("|", "\\|", "\r\n", " ", "\r", " ", "\n", " ")
+
+// markdownCell keeps user text on one line of a table row or list item.
+func markdownCell(value string) string {
+	return markdownCellEscaper.Replace(value)
+}
+
+var markdownEscaper = strings.NewReplacer(
+	"\\", "\\\\", "`", "\\`", "*", "\\*", "_", "\\_", "[", "\\[", "]", "\\]",
+	"<", "\\<", ">", "\\>", "#", "\\#", "!", "\\!", "|", "\\|", "\r", " ", "\n", " ",
+)
+
+// markdownText escapes user text for a single line, so titles and names
+// cannot open links, emphasis or headings of their own.
+func markdownText(value string) string {
+	return markdownEscaper.Replace(value)
+}
+
+// markdownURL returns link percent-encoded for a markdown link target, or ""
+// when it is not an http or https URL.
+func markdownURL(link string) string {
+	parsed, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return ""
+	}
+	return strings.NewReplacer("(", "%28", ")", "%29", " ", "%20", "<", "%3C", ">", "%3E").Replace(parsed.String())
+}
+
+func formatStat(value *float64) string {
+	if value == nil {
+		return "-"
+	}
+	return strconv.FormatFloat(*value, 'f', -1, 64)
+}
+
+func csvStat(value *float64) string {
+	if value == nil {
+		return ""
+	}
+	return formatStat(value)
+}
+
+func formatExportTime(value *time.Time) string {
+	if value == nil {
+		return ""
+	}
+	return value.Format(time.RFC3339)
+}
+
+func apiExportRoom(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomUUID := mux.Vars(r)["roomUUID"]
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "json"
+		}
+		spec, exists := exportFormats[format]
+		if !exists {
+			handleError(w, errInvalidField("format", "format must be json, csv or markdown"))
+			return
+		}
+
+		game, err := loadGame(database, roomUUID)
+		if handleError(w, err) {
+			return
+		}
+		if _, err := requireRoomAccess(database, game.roomID, r.URL.Query().Get("userUUID")); handleError(w, err) {
+			return
+		}
+
+		// Anything that can fail with a proper status happens before the
+		// first byte is written
+		comments, err := fetchRoomComments(database, game.roomID)
+		if handleError(w, err) {
+			return
+		}
+
+		var writer exportWriter
+		switch format {
+		case "csv":
+			writer = &csvExportWriter{w: csv.NewWriter(w)}
+		case "markdown":
+			writer = &markdownExportWriter{w: w}
+		default:
+			writer = &jsonExportWriter{w: w}
+		}
+
+		w.Header().Set("Content-Type", spec.contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="room-%s.%s"`, roomUUID, spec.extension))
+
+		flusher, _ := w.(http.Flusher)
+		err = writer.begin(ExportRoom{RoomUUID: game.roomUUID, Name: game.name}, time.Now().UTC())
+		if err == nil {
+			err = streamRoomExport(database, game.roomID, comments, func(issue ExportIssue) error {
+				if err := writer.issue(issue); err != nil {
+					return err
+				}
+				if flusher != nil {
+					flusher.Flush()
+				}
+				return nil
+			})
+		}
+		if err == nil {
+			err = writer.end()
+		}
+		if err != nil {
+			// The status line is already sent, all we can do is cut the body short
+			log.Printf("Error exporting room %s: %v", roomUUID, err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMarkdownText(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"Login page", "Login page"},
+		{"[click](https://evil.example)", `\[click\](https://evil.example)`},
+		{"# Heading", `\# Heading`},
+		{"**bold** and _italic_", `\*\*bold\*\* and \_italic\_`},
+		{"`code`", "\\`code\\`"},
+		{"<img src=x>", `\<img src=x\>`},
+		{"![image](x.png)", `\!\[image\](x.png)`},
+		{`C:\path`, `C:\\path`},
+		{"a | b", `a \| b`},
+		{"two\nlines\r\nhere", "two lines  here"},
+		{"½ ☕", "½ ☕"},
+	}
+	for _, tt := range tests {
+		if got := markdownText(tt.value); got != tt.want {
+			t.Errorf("markdownText(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMarkdownCell(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"5", "5"},
+		{"a|b", `a\|b`},
+		{"||", `\|\|`},
+		{"two\nlines", "two lines"},
+		{"windows\r\nline", "windows line"},
+		{"old mac\rline", "old mac line"},
+	}
+	for _, tt := range tests {
+		if got := markdownCell(tt.value); got != tt.want {
+			t.Errorf("markdownCell(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMarkdownURL(t *testing.T) {
+	tests := []struct {
+		link string
+		want string
+	}{
+		{"https://example.com/browse/ABC-1", "https://example.com/browse/ABC-1"},
+		{" http://example.com ", "http://example.com"},
+		{"https://example.com/a (b)", "https://example.com/a%20%28b%29"},
+		{"https://example.com/<x>", "https://example.com/%3Cx%3E"},
+		{"javascript:alert(1)", ""},
+		{"ftp://example.com/file", ""},
+		{"/relative/path", ""},
+		{"https://", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := markdownURL(tt.link); got != tt.want {
+			t.Errorf("markdownURL(%q) = %q, want %q", tt.link, got, tt.want)
+		}
+	}
+}
+
+func TestCSVExportRows(t *testing.T) {
+	sequence := func(n int) *int { return &n }
+	text := func(s string) *string { return &s }
+	number := func(f float64) *float64 { return &f }
+	started := time.Date(2026, 3, 2, 14, 0, 0, 0, time.UTC)
+	revealed := started.Add(90 * time.Second)
+	reply := IssueComment{Name: "Bob", Text: "Agreed"}
+
+	tests := []struct {
+		name  string
+		issue ExportIssue
+		want  [][]string
+	}{
+		{
+			name:  "issue without rounds",
+			issue: ExportIssue{Sequence: sequence(0), Title: "Login", Link: "https://example.com/1"},
+			want:  [][]string{{"1", "Login", "https://example.com/1", "", "", "", "", "", "", "", "", "", "", "", "", ""}},
+		},
+		{
+			name: "one row per vote",
+			issue: ExportIssue{
+				Sequence: sequence(2), Title: "Logout", Estimate: text("5"), DecisionNote: text("Same as login"),
+				Comments: []IssueComment{{Name: "Ana", Text: "Needs SSO", Replies: []IssueComment{reply}}},
+				Rounds: []ExportRound{{
+					Number: 1, StartedAt: &started, RevealedAt: revealed,
+					Votes: []ExportVote{{Name: "Ana", Vote: "5"}, {Name: "Bob", Vote: "?"}},
+					Stats: RoundStats{Average: number(5), Median: number(5), Min: number(5), Max: number(5), Consensus: true},
+				}},
+			},
+			want: [][]string{
+				{"3", "Logout", "", "5", "1", "2026-03-02T14:00:00Z", "2026-03-02T14:01:30Z", "Ana", "5", "5", "5", "5", "5", "true", "Same as login", "Ana: Needs SSO\n  Bob: Agreed"},
+				{"3", "Logout", "", "5", "1", "2026-03-02T14:00:00Z", "2026-03-02T14:01:30Z", "Bob", "?", "5", "5", "5", "5", "true", "Same as login", "Ana: Needs SSO\n  Bob: Agreed"},
+			},
+		},
+		{
+			name: "rounds without an issue",
+			issue: ExportIssue{Rounds: []ExportRound{{
+				Number: 2, RevealedAt: revealed,
+				Votes: []ExportVote{{Name: "Ana", Vote: "☕"}},
+			}}},
+			want: [][]string{{"", "", "", "", "2", "", "2026-03-02T14:01:30Z", "Ana", "☕", "", "", "", "", "false", "", ""}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			writer := &csvExportWriter{w: csv.NewWriter(&buf)}
+			if err := writer.begin(ExportRoom{}, time.Now()); err != nil {
+				t.Fatal(err)
+			}
+			if err := writer.issue(tt.issue); err != nil {
+				t.Fatal(err)
+			}
+			if err := writer.end(); err != nil {
+				t.Fatal(err)
+			}
+
+			records, err := csv.NewReader(&buf).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			header := records[0]
+			for i, record := range records[1:] {
+				if len(record) != len(header) {
+					t.Errorf("row %d has %d columns, want %d", i+1, len(record), len(header))
+				}
+			}
+			if !reflect.DeepEqual(records[1:], tt.want) {
+				t.Errorf("got rows %q, want %q", records[1:], tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const issueSourceGitHub = "github"
+
+type githubClient struct {
+	http        providerHTTP
+	labelPrefix string
+}
+
+// newGitHubClientFromEnv returns nil unless GITHUB_TOKEN or GITHUB_API_URL
+// is set.
+func newGitHubClientFromEnv() *githubClient {
+	token, baseURL := os.Getenv("GITHUB_TOKEN"), os.Getenv("GITHUB_API_URL")
+	if token == "" && baseURL == "" {
+		return nil
+	}
+	if baseURL == "" {
+		baseURL = "https://api.github.com"
+	}
+
+	labelPrefix := os.Getenv("GITHUB_ESTIMATE_LABEL_PREFIX")
+	if labelPrefix == "" {
+		labelPrefix = "estimate: "
+	}
+
+	return &githubClient{
+		http: newProviderHTTP(issueSourceGitHub, baseURL, func(req *http.Request) {
+			req.Header.Set("Accept", "application/vnd.github+json")
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+		}),
+		labelPrefix: labelPrefix,
+	}
+}
+
+type githubIssue struct {
+	Number      int         `json:"number"`
+	Title       string      `json:"title"`
+	Body        *string     `json:"body"`
+	HTMLURL     string      `json:"html_url"`
+	PullRequest interface{} `json:"pull_request"`
+}
+
+type githubLabel struct {
+	Name string `json:"name"`
+}
+
+// FetchIssues lists the issues of req.Repo ("owner/name"), optionally
+// filtered by milestone number, labels and state. Pull requests are skipped.
+func (c *githubClient) FetchIssues(req ImportIssuesRequest) ([]ExternalIssue, error) {
+	if req.Repo == "" {
+		return nil, errMissingField("repo")
+	}
+	repoPath, err := githubRepoPath(req.Repo)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{"per_page": {"100"}}
+	query.Set("state", "open")
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	if req.Milestone != "" {
+		query.Set("milestone", req.Milestone)
+	}
+	if len(req.Labels) > 0 {
+		query.Set("labels", strings.Join(req.Labels, ","))
+	}
+
+	issues := []ExternalIssue{}
+	for page := 1; len(issues) < req.MaxResults; page++ {
+		query.Set("page", strconv.Itoa(page))
+
+		var items []githubIssue
+		if err := c.http.do(http.MethodGet, repoPath+"/issues?"+query.Encode(), nil, &items); err != nil {
+			return nil, err
+		}
+
+		for _, item := range items {
+			if len(issues) == req.MaxResults {
+				break
+			}
+			if item.PullRequest != nil {
+				continue
+			}
+			issue := ExternalIssue{
+				Key:   fmt.Sprintf("%s#%d", req.Repo, item.Number),
+				Title: item.Title,
+				URL:   item.HTMLURL,
+			}
+			if item.Body != nil {
+				issue.Description = *item.Body
+			}
+			issues = append(issues, issue)
+		}
+
+		if len(items) < 100 {
+			break
+		}
+	}
+	return issues, nil
+}
+
+// githubRepoPath returns the API path of an "owner/name" repository.
+func githubRepoPath(repo string) (string, error) {
+	segments, err := splitRepo(repo, false)
+	if err != nil {
+		return "", err
+	}
+	return "/repos/" + url.PathEscape(segments[0]) + "/" + url.PathEscape(segments[1]), nil
+}
+
+// WriteEstimate replaces the issue's estimate label, e.g. "estimate: 5".
+func (c *githubClient) WriteEstimate(key string, estimate string) error {
+	repo, number, err := splitIssueRef(key)
+	if err != nil {
+		return err
+	}
+	repoPath, err := githubRepoPath(repo)
+	if err != nil {
+		return err
+	}
+	path := repoPath + "/issues/" + number + "/labels"
+	label := c.labelPrefix + estimate
+
+	var labels []githubLabel
+	if err := c.http.do(http.MethodGet, path, nil, &labels); err != nil {
+		return err
+	}
+
+	present := false
+	for _, existing := range labels {
+		if existing.Name == label {
+			present = true
+		} else if strings.HasPrefix(existing.Name, c.labelPrefix) {
+			if err := c.http.do(http.MethodDelete, path+"/"+url.PathEscape(existing.Name), nil, nil); err != nil {
+				return err
+			}
+		}
+	}
+	if present {
+		return nil
+	}
+
+	body := map[string][]string{"labels": {label}}
+	return c.http.do(http.MethodPost, path, body, nil)
+}
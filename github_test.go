@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeGitHub serves the issues of org/repo, 100 per page like the real API,
+// and keeps the labels of issue 1.
+type fakeGitHub struct {
+	total int
+
+	mu       sync.Mutex
+	requests []string
+	labels   []string
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.EscapedPath())
+
+	switch path := r.URL.EscapedPath(); {
+	case r.Method == http.MethodGet && path == "/repos/org/repo/issues":
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		items := []map[string]interface{}{}
+		for i := (page - 1) * 100; i < f.total && i < page*100; i++ {
+			item := map[string]interface{}{"number": i + 1, "title": fmt.Sprintf("Issue %d", i+1), "html_url": fmt.Sprintf("https://github.test/org/repo/issues/%d", i+1)}
+			// Every tenth item is a pull request
+			if (i+1)%10 == 0 {
+				item["pull_request"] = map[string]interface{}{}
+			}
+			items = append(items, item)
+		}
+		json.NewEncoder(w).Encode(items)
+	case r.Method == http.MethodGet && path == "/repos/org/repo/issues/1/labels":
+		labels := []githubLabel{}
+		for _, label := range f.labels {
+			labels = append(labels, githubLabel{Name: label})
+		}
+		json.NewEncoder(w).Encode(labels)
+	case r.Method == http.MethodDelete && strings.HasPrefix(path, "/repos/org/repo/issues/1/labels/"):
+		name := strings.TrimPrefix(r.URL.Path, "/repos/org/repo/issues/1/labels/")
+		for i, label := range f.labels {
+			if label == name {
+				f.labels = append(f.labels[:i], f.labels[i+1:]...)
+				break
+			}
+		}
+	case r.Method == http.MethodPost && path == "/repos/org/repo/issues/1/labels":
+		var body map[string][]string
+		json.NewDecoder(r.Body).Decode(&body)
+		f.labels = append(f.labels, body["labels"]...)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newFakeGitHub(t *testing.T, total int) (*fakeGitHub, *githubClient) {
+	fake := &fakeGitHub{total: total}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	t.Setenv("GITHUB_TOKEN", "token")
+	t.Setenv("GITHUB_API_URL", server.URL)
+	t.Setenv("GITHUB_ESTIMATE_LABEL_PREFIX", "")
+	return fake, newGitHubClientFromEnv()
+}
+
+func TestGitHubFetchIssuesPaginatesAndSkipsPullRequests(t *testing.T) {
+	fake, client := newFakeGitHub(t, 150)
+
+	issues, err := client.FetchIssues(ImportIssuesRequest{Repo: "org/repo", MaxResults: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 150 items, 15 of them pull requests
+	if len(issues) != 135 {
+		t.Fatalf("got %d issues, want 135", len(issues))
+	}
+	if issues[0].Key != "org/repo#1" || issues[9].Key != "org/repo#11" {
+		t.Errorf("unexpected keys %s, %s", issues[0].Key, issues[9].Key)
+	}
+	if len(fake.requests) != 2 {
+		t.Errorf("fetched %d pages, want 2", len(fake.requests))
+	}
+}
+
+func TestGitHubRejectsInvalidRepos(t *testing.T) {
+	fake, client := newFakeGitHub(t, 1)
+
+	for _, repo := range []string{"org", "org/repo/extra", "../repo", "org/..", "org/re po", "org/repo?x=1", "org/repo#1", "org%2Frepo/x", "/repo"} {
+		_, err := client.FetchIssues(ImportIssuesRequest{Repo: repo, MaxResults: 10})
+		if err == nil || toAPIError(err).Status != http.StatusBadRequest {
+			t.Errorf("%q: got error %v, want a 400", repo, err)
+		}
+	}
+	if err := client.WriteEstimate("../../user#1", "5"); err == nil {
+		t.Error("write-back to an invalid repo was accepted")
+	}
+	if len(fake.requests) != 0 {
+		t.Errorf("invalid repos reached the API: %v", fake.requests)
+	}
+}
+
+func TestGitHubWriteEstimateReplacesLabel(t *testing.T) {
+	fake, client := newFakeGitHub(t, 1)
+	fake.labels = []string{"bug", "estimate: 3"}
+	client.labelPrefix = "estimate: "
+
+	if err := client.WriteEstimate("org/repo#1", "5"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(fake.labels, ",") != "bug,estimate: 5" {
+		t.Errorf("got labels %v", fake.labels)
+	}
+
+	// Writing the same estimate again changes nothing
+	requests := len(fake.requests)
+	if err := client.WriteEstimate("org/repo#1", "5"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.requests) != requests+1 {
+		t.Errorf("unchanged estimate made %d requests", len(fake.requests)-requests)
+	}
+}
+
+func TestTruncateTitle(t *testing.T) {
+	tests := []struct {
+		title string
+		want  int
+	}{
+		{"", 0},
+		{"Short title", 11},
+		{strings.Repeat("a", 255), 255},
+		{strings.Repeat("a", 256), 255},
+		{strings.Repeat("é", 300), 255},
+	}
+	for _, tt := range tests {
+		got := truncateTitle(tt.title)
+		if n := len([]rune(got)); n != tt.want {
+			t.Errorf("truncateTitle(%d runes) has %d runes, want %d", len([]rune(tt.title)), n, tt.want)
+		}
+		if len([]rune(tt.title)) > 255 && !strings.HasSuffix(got, "…") {
+			t.Errorf("truncated title %q has no ellipsis", got)
+		}
+	}
+}
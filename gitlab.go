@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const issueSourceGitLab = "gitlab"
+
+type gitlabClient struct {
+	http        providerHTTP
+	labelPrefix string
+	useWeight   bool
+}
+
+// newGitLabClientFromEnv returns nil unless GITLAB_TOKEN or GITLAB_BASE_URL
+// is set.
+func newGitLabClientFromEnv() *gitlabClient {
+	token, baseURL := os.Getenv("GITLAB_TOKEN"), os.Getenv("GITLAB_BASE_URL")
+	if token == "" && baseURL == "" {
+		return nil
+	}
+	if baseURL == "" {
+		baseURL = "https://gitlab.com"
+	}
+
+	labelPrefix := os.Getenv("GITLAB_ESTIMATE_LABEL_PREFIX")
+	if labelPrefix == "" {
+		labelPrefix = "estimate: "
+	}
+
+	return &gitlabClient{
+		http: newProviderHTTP(issueSourceGitLab, strings.TrimRight(baseURL, "/")+"/api/v4", func(req *http.Request) {
+			if token != "" {
+				req.Header.Set("PRIVATE-TOKEN", token)
+			}
+		}),
+		labelPrefix: labelPrefix,
+		useWeight:   os.Getenv("GITLAB_ESTIMATE_FIELD") == "weight",
+	}
+}
+
+type gitlabIssue struct {
+	IID         int      `json:"iid"`
+	Title       string   `json:"title"`
+	Description *string  `json:"description"`
+	WebURL      string   `json:"web_url"`
+	Labels      []string `json:"labels"`
+}
+
+// FetchIssues lists the issues of the project req.Repo ("group/project"),
+// optionally filtered by milestone title, labels and state.
+func (c *gitlabClient) FetchIssues(req ImportIssuesRequest) ([]ExternalIssue, error) {
+	if req.Repo == "" {
+		return nil, errMissingField("repo")
+	}
+	projectPath, err := c.projectPath(req.Repo)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{"per_page": {"100"}}
+	switch req.State {
+	case "", "open", "opened":
+		query.Set("state", "opened")
+	case "closed":
+		query.Set("state", "closed")
+	case "all":
+	default:
+		return nil, errInvalidField("state", "state must be open, closed or all")
+	}
+	if req.Milestone != "" {
+		query.Set("milestone", req.Milestone)
+	}
+	if len(req.Labels) > 0 {
+		query.Set("labels", strings.Join(req.Labels, ","))
+	}
+
+	issues := []ExternalIssue{}
+	for page := 1; len(issues) < req.MaxResults; page++ {
+		query.Set("page", strconv.Itoa(page))
+
+		var items []gitlabIssue
+		if err := c.http.do(http.MethodGet, projectPath+"/issues?"+query.Encode(), nil, &items); err != nil {
+			return nil, err
+		}
+
+		for _, item := range items {
+			if len(issues) == req.MaxResults {
+				break
+			}
+			issue := ExternalIssue{
+				Key:   fmt.Sprintf("%s#%d", req.Repo, item.IID),
+				Title: item.Title,
+				URL:   item.WebURL,
+			}
+			if item.Description != nil {
+				issue.Description = *item.Description
+			}
+			issues = append(issues, issue)
+		}
+
+		if len(items) < 100 {
+			break
+		}
+	}
+	return issues, nil
+}
+
+// projectPath returns the API path of a project, addressed by its full path
+// escaped as a single segment as GitLab expects.
+func (c *gitlabClient) projectPath(repo string) (string, error) {
+	if _, err := splitRepo(repo, true); err != nil {
+		return "", err
+	}
+	return "/projects/" + url.PathEscape(repo), nil
+}
+
+// WriteEstimate sets the issue weight when GITLAB_ESTIMATE_FIELD=weight,
+// otherwise it replaces the issue's estimate label.
+func (c *gitlabClient) WriteEstimate(key string, estimate string) error {
+	repo, iid, err := splitIssueRef(key)
+	if err != nil {
+		return err
+	}
+	projectPath, err := c.projectPath(repo)
+	if err != nil {
+		return err
+	}
+	path := projectPath + "/issues/" + iid
+
+	if c.useWeight {
+		points, ok := parseVote(estimate)
+		if !ok || points != math.Trunc(points) || points < 0 {
+			log.Printf("Not writing estimate %q of %s back to GitLab: weights must be whole numbers", estimate, key)
+			return nil
+		}
+		return c.http.do(http.MethodPut, path, map[string]int{"weight": int(points)}, nil)
+	}
+
+	var issue gitlabIssue
+	if err := c.http.do(http.MethodGet, path, nil, &issue); err != nil {
+		return err
+	}
+
+	label := c.labelPrefix + estimate
+	var stale []string
+	present := false
+	for _, existing := range issue.Labels {
+		if existing == label {
+			present = true
+		} else if strings.HasPrefix(existing, c.labelPrefix) {
+			stale = append(stale, existing)
+		}
+	}
+	if present && len(stale) == 0 {
+		return nil
+	}
+
+	body := map[string]string{"add_labels": label}
+	if len(stale) > 0 {
+		body["remove_labels"] = strings.Join(stale, ",")
+	}
+	return c.http.do(http.MethodPut, path, body, nil)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// fakeGitLab serves the issues of the project group/sub/project and records
+// the updates sent to them.
+type fakeGitLab struct {
+	total int
+
+	mu       sync.Mutex
+	requests []string
+	labels   []string
+	updates  []map[string]interface{}
+}
+
+const fakeGitLabProject = "/api/v4/projects/group%2Fsub%2Fproject"
+
+func (f *fakeGitLab) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("PRIVATE-TOKEN") != "token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.EscapedPath())
+
+	switch path := r.URL.EscapedPath(); {
+	case r.Method == http.MethodGet && path == fakeGitLabProject+"/issues":
+		if r.URL.Query().Get("state") != "opened" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		items := []gitlabIssue{}
+		for i := (page - 1) * 100; i < f.total && i < page*100; i++ {
+			items = append(items, gitlabIssue{IID: i + 1, Title: fmt.Sprintf("Issue %d", i+1), WebURL: fmt.Sprintf("https://gitlab.test/group/sub/project/-/issues/%d", i+1)})
+		}
+		json.NewEncoder(w).Encode(items)
+	case r.Method == http.MethodGet && path == fakeGitLabProject+"/issues/1":
+		json.NewEncoder(w).Encode(gitlabIssue{IID: 1, Labels: f.labels})
+	case r.Method == http.MethodPut && path == fakeGitLabProject+"/issues/1":
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.updates = append(f.updates, body)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newFakeGitLab(t *testing.T, total int, field string) (*fakeGitLab, *gitlabClient) {
+	fake := &fakeGitLab{total: total}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	t.Setenv("GITLAB_TOKEN", "token")
+	t.Setenv("GITLAB_BASE_URL", server.URL+"/")
+	t.Setenv("GITLAB_ESTIMATE_LABEL_PREFIX", "")
+	t.Setenv("GITLAB_ESTIMATE_FIELD", field)
+	return fake, newGitLabClientFromEnv()
+}
+
+func TestGitLabFetchIssuesPaginates(t *testing.T) {
+	fake, client := newFakeGitLab(t, 230, "")
+
+	issues, err := client.FetchIssues(ImportIssuesRequest{Repo: "group/sub/project", MaxResults: 150})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 150 {
+		t.Fatalf("got %d issues, want 150", len(issues))
+	}
+	if issues[149].Key != "group/sub/project#150" {
+		t.Errorf("last key is %s", issues[149].Key)
+	}
+	if len(fake.requests) != 2 {
+		t.Errorf("fetched %d pages, want 2", len(fake.requests))
+	}
+}
+
+func TestGitLabRejectsInvalidProjects(t *testing.T) {
+	fake, client := newFakeGitLab(t, 1, "")
+
+	for _, repo := range []string{"project", "group/../project", "group//project", "group/pro ject", "group/project?x"} {
+		_, err := client.FetchIssues(ImportIssuesRequest{Repo: repo, MaxResults: 10})
+		if err == nil || toAPIError(err).Status != http.StatusBadRequest {
+			t.Errorf("%q: got error %v, want a 400", repo, err)
+		}
+	}
+	if len(fake.requests) != 0 {
+		t.Errorf("invalid projects reached the API: %v", fake.requests)
+	}
+}
+
+func TestGitLabWriteEstimate(t *testing.T) {
+	t.Run("label", func(t *testing.T) {
+		fake, client := newFakeGitLab(t, 1, "")
+		fake.labels = []string{"bug", "estimate: 3"}
+		client.labelPrefix = "estimate: "
+
+		if err := client.WriteEstimate("group/sub/project#1", "5"); err != nil {
+			t.Fatal(err)
+		}
+		if len(fake.updates) != 1 || fake.updates[0]["add_labels"] != "estimate: 5" || fake.updates[0]["remove_labels"] != "estimate: 3" {
+			t.Errorf("got updates %v", fake.updates)
+		}
+	})
+
+	t.Run("weight", func(t *testing.T) {
+		fake, client := newFakeGitLab(t, 1, "weight")
+
+		for _, estimate := range []string{"8", "2.5", "?"} {
+			if err := client.WriteEstimate("group/sub/project#1", estimate); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(fake.updates) != 1 || fake.updates[0]["weight"] != 8.0 {
+			t.Errorf("got updates %v", fake.updates)
+		}
+	})
+}
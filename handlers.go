@@ -11,6 +11,7 @@ func handleLeaveRoom(game *Game, userID int) {
 	var newAdmin *Player
 	for i, player := range game.Players {
 		if player.ID == userID {
+			emitRoomEvent(game, eventPlayerLeft, PlayerEventData{UserUUID: player.UUID, Name: player.Name})
 			//check if Player has connections
 			if len(player.connections) > 1 {
 				//remove the connection from the player
@@ -46,6 +47,10 @@ func handleVote(msg map[string]interface{}, game *Game, userID int, db *sql.DB)
 		log.Println("Invalid vote format")
 		return
 	}
+	if player := findPlayer(game, userID); player != nil && player.Observer {
+		log.Printf("User %d is observing room %s and cannot vote", userID, game.roomUUID)
+		return
+	}
 
 	castVote(db, game.roomID, userID, vote)
 
@@ -63,7 +68,56 @@ func handleVote(msg map[string]interface{}, game *Game, userID int, db *sql.DB)
 	}
 }
 
-func handleIssueOrder(msg map[string]interface{}, game *Game, db *sql.DB) {
+// setPlayerVote stores the player's vote for the current round, or clears it
+// when vote is nil. Unlike handleVote it never toggles.
+func setPlayerVote(db *sql.DB, game *Game, userID int, vote *string) error {
+	var player *Player
+	for _, p := range game.Players {
+		if p.ID == userID {
+			player = p
+			break
+		}
+	}
+	if player == nil {
+		return errPlayerNotInRoom
+	}
+	if player.Observer {
+		return errObserverCannotVote
+	}
+
+	if vote != nil && len(game.deck) > 0 {
+		valid := false
+		for _, card := range game.deck {
+			if card.Value == *vote {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return errInvalidField("vote", "Vote is not a card of this room's deck")
+		}
+	}
+
+	if _, err := db.Exec("DELETE FROM votes WHERE room_id = $1 AND user_id = $2", game.roomID, userID); err != nil {
+		return err
+	}
+	if vote != nil {
+		if _, err := db.Exec("INSERT INTO votes (room_id, user_id, vote) VALUES ($1, $2, $3)", game.roomID, userID, *vote); err != nil {
+			return err
+		}
+	}
+
+	player.Voted = vote != nil
+	player.Vote = vote
+	return nil
+}
+
+func handleIssueOrder(msg map[string]interface{}, game *Game, userID int, db *sql.DB) {
+	if !isFacilitator(game, userID) {
+		log.Printf("User %d is not the admin of room %s and cannot reorder issues", userID, game.roomUUID)
+		return
+	}
+
 	issues, ok := msg["issues"].([]interface{})
 	if !ok {
 		log.Println("Invalid issue format")
@@ -71,42 +125,28 @@ func handleIssueOrder(msg map[string]interface{}, game *Game, db *sql.DB) {
 		return
 	}
 
-	for i, issue := range issues {
+	order := make([]string, 0, len(issues))
+	for _, issue := range issues {
 		issueUUID, ok := issue.(string)
 		if !ok {
 			log.Println("Invalid issue format")
 			log.Println("issue", issue)
 			return
 		}
-		// get issue whole object  by uuid
-		issue, err := getIssueByUUID(db, game.roomID, issueUUID)
-		if err != nil {
-			log.Println("Error getting issue by UUID")
-			return
-		}
-
-		err = updateIssueOrder(db, game.roomID, issue["id"].(int), i)
-		if err != nil {
-			log.Println("Error updating issue order")
-			return
-		}
-		game.issues[i] = Issue{
-			ID:          issue["id"].(int),
-			UUID:        issueUUID,
-			Title:       issue["title"].(string),
-			Description: issue["description"].(string),
-			Link:        issue["link"].(string),
-			Sequence:    i,
-		}
+		order = append(order, issueUUID)
+	}
 
-		if err != nil {
-			log.Println("Error updating issue order")
-			return
-		}
+	if err := reorderIssues(db, game, order); err != nil {
+		log.Printf("Error updating issue order: %v", err)
 	}
 }
 
-func handleNewIssue(msg map[string]interface{}, game *Game, db *sql.DB) {
+func handleNewIssue(msg map[string]interface{}, game *Game, userID int, db *sql.DB) {
+	if !isFacilitator(game, userID) {
+		log.Printf("User %d is not the admin of room %s and cannot add issues", userID, game.roomUUID)
+		return
+	}
+
 	issueData, ok := msg["issue"].(map[string]interface{})
 	if !ok {
 		log.Println("Invalid issue format")
@@ -117,32 +157,140 @@ func handleNewIssue(msg map[string]interface{}, game *Game, db *sql.DB) {
 	description, _ := issueData["description"].(string)
 	link, _ := issueData["link"].(string)
 
-	uuid := generateUuid()
-	id, err := createIssue(db, game.roomID, uuid, IssueRequest{
-		RoomID:      game.roomID,
+	_, err := addIssue(db, game, IssueRequest{
 		Title:       title,
 		Description: description,
 		Link:        link,
-		Sequence:    len(game.issues),
 	})
-
 	if err != nil {
 		log.Printf("Error creating issue: %v", err)
+	}
+}
+
+func handleUpdateIssue(msg map[string]interface{}, game *Game, userID int, db *sql.DB) {
+	if !isFacilitator(game, userID) {
+		log.Printf("User %d is not the admin of room %s and cannot edit issues", userID, game.roomUUID)
 		return
 	}
 
-	issue := Issue{
-		ID:          int(id),
-		UUID:        uuid,
-		Title:       title,
-		Description: description,
-		Link:        link,
+	issueUUID, ok := msg["issueUUID"].(string)
+	issueData, dataOk := msg["issue"].(map[string]interface{})
+	if !ok || !dataOk {
+		log.Println("Invalid issue format")
+		return
+	}
+
+	var req UpdateIssueRequest
+	if title, ok := issueData["title"].(string); ok {
+		req.Title = &title
+	}
+	if description, ok := issueData["description"].(string); ok {
+		req.Description = &description
+	}
+	if link, ok := issueData["link"].(string); ok {
+		req.Link = &link
+	}
+	if decisionNote, ok := issueData["decisionNote"].(string); ok {
+		req.DecisionNote = &decisionNote
 	}
 
-	game.issues = append(game.issues, issue)
+	if _, err := updateIssue(db, game, issueUUID, req); err != nil {
+		log.Printf("Error updating issue: %v", err)
+	}
 }
 
-func handleEmoji(msg map[string]interface{}, game *Game, userID int) {
+func handleDeleteIssue(msg map[string]interface{}, game *Game, userID int, db *sql.DB) {
+	if !isFacilitator(game, userID) {
+		log.Printf("User %d is not the admin of room %s and cannot delete issues", userID, game.roomUUID)
+		return
+	}
+
+	issueUUID, ok := msg["issueUUID"].(string)
+	if !ok {
+		log.Println("Invalid issue format")
+		return
+	}
+
+	if err := deleteIssue(db, game, issueUUID); err != nil {
+		log.Printf("Error deleting issue: %v", err)
+	}
+}
+
+func handleSetEstimate(msg map[string]interface{}, game *Game, userID int, db *sql.DB) {
+	if !isFacilitator(game, userID) {
+		log.Printf("User %d is not the admin of room %s and cannot set estimates", userID, game.roomUUID)
+		return
+	}
+
+	issueUUID, ok := msg["issueUUID"].(string)
+	if !ok {
+		log.Println("Invalid estimate format")
+		return
+	}
+	estimate, _ := msg["estimate"].(string)
+	var decisionNote *string
+	if note, ok := msg["decisionNote"].(string); ok {
+		decisionNote = &note
+	}
+
+	if _, err := setIssueEstimate(db, game, issueUUID, estimate, decisionNote); err != nil {
+		log.Printf("Error setting estimate: %v", err)
+	}
+}
+
+func handleComment(msg map[string]interface{}, game *Game, userID int, db *sql.DB) {
+	issueUUID, ok := msg["issueUUID"].(string)
+	if !ok {
+		log.Println("Invalid comment format")
+		return
+	}
+	text, _ := msg["text"].(string)
+	parentUUID, _ := msg["parentUUID"].(string)
+
+	if _, err := addIssueComment(db, game, userID, issueUUID, parentUUID, text); err != nil {
+		log.Printf("Error adding comment: %v", err)
+	}
+}
+
+func handleDeleteComment(msg map[string]interface{}, game *Game, userID int, db *sql.DB) {
+	issueUUID, _ := msg["issueUUID"].(string)
+	commentUUID, ok := msg["commentUUID"].(string)
+	if !ok {
+		log.Println("Invalid comment format")
+		return
+	}
+
+	if err := deleteIssueComment(db, game, userID, issueUUID, commentUUID); err != nil {
+		log.Printf("Error deleting comment: %v", err)
+	}
+}
+
+func handleSelectIssue(msg map[string]interface{}, game *Game, userID int, db *sql.DB) {
+	if !isFacilitator(game, userID) {
+		log.Printf("User %d is not the admin of room %s and cannot select the issue", userID, game.roomUUID)
+		return
+	}
+
+	issueUUID, _ := msg["issueUUID"].(string)
+
+	if err := selectIssue(db, game, issueUUID); err != nil {
+		log.Printf("Error selecting issue: %v", err)
+		sendGameState(game, nil)
+	}
+}
+
+func handleRevote(game *Game, userID int, db *sql.DB) {
+	if !policyAllows(game, game.settings.ResetPolicy, userID) {
+		log.Printf("User %d may not start a new round in room %s", userID, game.roomUUID)
+		return
+	}
+	if err := revote(db, game); err != nil {
+		log.Printf("Error starting re-vote: %v", err)
+		sendGameState(game, nil)
+	}
+}
+
+func handleEmoji(msg map[string]interface{}, game *Game, userID int, ws *websocket.Conn) {
 	emoji, ok := msg["emoji"].(string)
 	if !ok {
 		log.Printf("emoji is not a string: %v", msg["emoji"])
@@ -156,14 +304,30 @@ func handleEmoji(msg map[string]interface{}, game *Game, userID int) {
 	}
 	targetUserId := int(targetUserIdFloat)
 
-	emojiMessage := EmojiMessage{
-		Emoji:        emoji,
-		OriginUserID: userID,
-		TargetUserID: targetUserId,
+	if reason := throwEmoji(game, userID, targetUserId, emoji); reason != "" {
+		rejectEmoji(ws, emoji, reason)
 	}
+}
 
-	// Send the game state with the emoji message
-	sendGameState(game, []EmojiMessage{emojiMessage})
+func handleChat(msg map[string]interface{}, game *Game, userID int, ws *websocket.Conn, db *sql.DB) {
+	text, _ := msg["text"].(string)
+	issueUUID, _ := msg["issueUUID"].(string)
+
+	if _, err := postChatMessage(db, game, userID, text, issueUUID); err != nil {
+		rejectChat(ws, err)
+	}
+}
+
+func handleDeleteChat(msg map[string]interface{}, game *Game, userID int, ws *websocket.Conn, db *sql.DB) {
+	messageUUID, ok := msg["messageUUID"].(string)
+	if !ok {
+		log.Printf("messageUUID is not a string: %v", msg["messageUUID"])
+		return
+	}
+
+	if err := deleteChatMessage(db, game, userID, messageUUID); err != nil {
+		rejectChat(ws, err)
+	}
 }
 
 func handleNewPlayer(msg map[string]interface{}, game *Game, userID int, userUUID string, ws *websocket.Conn) {
@@ -195,6 +359,7 @@ func handleNewPlayer(msg map[string]interface{}, game *Game, userID int, userUUI
 		connections: []*websocket.Conn{ws},
 	}
 	game.Players = append(game.Players, player)
+	emitRoomEvent(game, eventPlayerJoined, PlayerEventData{UserUUID: userUUID, Name: name})
 }
 
 func handleNewAdmin(msg map[string]interface{}, game *Game, userID int, userUUID string, ws *websocket.Conn) {
@@ -236,4 +401,5 @@ func handleNewAdmin(msg map[string]interface{}, game *Game, userID int, userUUID
 	}
 
 	game.Players = append(game.Players, player)
+	emitRoomEvent(game, eventPlayerJoined, PlayerEventData{UserUUID: userUUID, Name: name})
 }
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// ExternalIssue is an issue read from an issue tracker, before it is added
+// to a room.
+type ExternalIssue struct {
+	Key         string
+	Title       string
+	Description string
+	URL         string
+}
+
+// IssueProvider is an issue tracker issues can be imported from and agreed
+// estimates written back to. Every implementation talks HTTP to a base URL
+// taken from the environment, so it can be pointed at a local fake.
+type IssueProvider interface {
+	FetchIssues(req ImportIssuesRequest) ([]ExternalIssue, error)
+	WriteEstimate(key string, estimate string) error
+}
+
+// issueProviders holds the configured providers by name ("jira", "github",
+// "gitlab"). Providers without configuration are left out.
+var issueProviders = map[string]IssueProvider{}
+
+func loadIssueProviders() map[string]IssueProvider {
+	providers := map[string]IssueProvider{}
+	if provider := newJiraClientFromEnv(); provider != nil {
+		providers[issueSourceJira] = provider
+	}
+	if provider := newGitHubClientFromEnv(); provider != nil {
+		providers[issueSourceGitHub] = provider
+	}
+	if provider := newGitLabClientFromEnv(); provider != nil {
+		providers[issueSourceGitLab] = provider
+	}
+	return providers
+}
+
+// ImportIssuesRequest selects the issues to import. Which fields apply
+// depends on the provider: jql and boardId for Jira, repo, milestone,
+// labels and state for GitHub and GitLab.
+type ImportIssuesRequest struct {
+	// Must be the room admin or a team admin, since the import runs with the
+	// server's credentials
+	UserUUID   string   `json:"userUUID"`
+	JQL        string   `json:"jql"`
+	BoardID    int      `json:"boardId"`
+	Repo       string   `json:"repo"`
+	Milestone  string   `json:"milestone"`
+	Labels     []string `json:"labels"`
+	State      string   `json:"state"`
+	MaxResults int      `json:"maxResults"`
+}
+
+type ImportIssuesResponse struct {
+	Imported []Issue `json:"imported"`
+	Skipped  int     `json:"skipped"`
+}
+
+// providerHTTP is the JSON-over-HTTP plumbing shared by the providers.
+type providerHTTP struct {
+	name      string
+	baseURL   string
+	client    *http.Client
+	authorize func(req *http.Request)
+}
+
+func newProviderHTTP(name string, baseURL string, authorize func(req *http.Request)) providerHTTP {
+	return providerHTTP{
+		name:      name,
+		baseURL:   strings.TrimRight(baseURL, "/"),
+		client:    &http.Client{Timeout: 15 * time.Second},
+		authorize: authorize,
+	}
+}
+
+func (p providerHTTP) do(method string, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequest(method, p.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if p.authorize != nil {
+		p.authorize(req)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s %s: %s: %s", p.name, method, path, resp.Status, strings.TrimSpace(string(message)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+var repoSegmentRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// splitRepo validates a repository reference and returns its path segments.
+// GitHub repositories are "owner/name"; GitLab projects may sit in nested
+// groups. Every segment is later escaped on its own, and "." or ".." are
+// refused so a reference cannot walk up the API path.
+func splitRepo(repo string, nested bool) ([]string, error) {
+	segments := strings.Split(repo, "/")
+	if len(segments) < 2 || (!nested && len(segments) > 2) {
+		return nil, errInvalidField("repo", "repo must look like owner/name")
+	}
+	for _, segment := range segments {
+		if !repoSegmentRegexp.MatchString(segment) || segment == "." || segment == ".." {
+			return nil, errInvalidField("repo", "repo may only contain letters, digits, '_', '.' and '-'")
+		}
+	}
+	return segments, nil
+}
+
+// truncateTitle cuts titles of external issues to the 255 characters a room
+// issue can hold.
+func truncateTitle(title string) string {
+	if utf8.RuneCountInString(title) <= 255 {
+		return title
+	}
+	return string([]rune(title)[:254]) + "…"
+}
+
+// splitIssueRef splits "owner/repo#123" into its repository and number.
+func splitIssueRef(key string) (string, string, error) {
+	index := strings.LastIndex(key, "#")
+	if index <= 0 || index == len(key)-1 {
+		return "", "", fmt.Errorf("invalid issue reference %q", key)
+	}
+	if _, err := strconv.Atoi(key[index+1:]); err != nil {
+		return "", "", fmt.Errorf("invalid issue reference %q", key)
+	}
+	return key[:index], key[index+1:], nil
+}
+
+func providerNames() []string {
+	names := make([]string, 0, len(issueProviders))
+	for name := range issueProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// importIssues appends the issues selected by req to the room's backlog,
+// skipping issues that were imported from the same provider before.
+func importIssues(database *sql.DB, game *Game, source string, req ImportIssuesRequest) (ImportIssuesResponse, error) {
+	switch source {
+	case issueSourceJira, issueSourceGitHub, issueSourceGitLab:
+	default:
+		return ImportIssuesResponse{}, newAPIError(http.StatusNotFound, "unknown_provider", "Unknown issue provider "+source)
+	}
+
+	provider, exists := issueProviders[source]
+	if !exists {
+		apiErr := newAPIError(http.StatusServiceUnavailable, "integration_not_configured", "Issue provider "+source+" is not configured")
+		apiErr.Details = map[string]interface{}{"configured": providerNames()}
+		return ImportIssuesResponse{}, apiErr
+	}
+	if req.MaxResults == 0 {
+		req.MaxResults = 50
+	}
+	if req.MaxResults < 0 || req.MaxResults > 200 {
+		return ImportIssuesResponse{}, errInvalidField("maxResults", "maxResults must be between 1 and 200")
+	}
+
+	found, err := provider.FetchIssues(req)
+	if err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			return ImportIssuesResponse{}, apiErr
+		}
+		log.Printf("Error fetching issues from %s: %v", source, err)
+		return ImportIssuesResponse{}, newAPIError(http.StatusBadGateway, "integration_error", "Could not fetch issues from "+source)
+	}
+
+	existing, err := importedKeys(database, game.roomID, source)
+	if err != nil {
+		return ImportIssuesResponse{}, err
+	}
+
+	response := ImportIssuesResponse{Imported: []Issue{}}
+	var issues []Issue
+	for _, item := range found {
+		if existing[item.Key] {
+			response.Skipped++
+			continue
+		}
+		existing[item.Key] = true
+		issues = append(issues, Issue{
+			Title:       truncateTitle(item.Title),
+			Description: item.Description,
+			Link:        item.URL,
+			Source:      source,
+			ExternalKey: item.Key,
+		})
+	}
+	if len(issues) == 0 {
+		return response, nil
+	}
+
+	response.Imported, err = addIssues(database, game, issues)
+	return response, err
+}
+
+// writeBackEstimate pushes an agreed estimate to the issue tracker the issue
+// was imported from.
+func writeBackEstimate(issue Issue) {
+	provider, exists := issueProviders[issue.Source]
+	if !exists || issue.Estimate == nil {
+		return
+	}
+
+	if err := provider.WriteEstimate(issue.ExternalKey, *issue.Estimate); err != nil {
+		log.Printf("Error writing estimate of %s back to %s: %v", issue.ExternalKey, issue.Source, err)
+	}
+}
+
+func apiImportIssues(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		var req ImportIssuesRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+
+		game, err := writableGame(database, vars["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+		if _, err := requireRoomAdmin(database, game.roomID, req.UserUUID); handleError(w, err) {
+			return
+		}
+
+		response, err := importIssues(database, game, vars["provider"], req)
+		if handleError(w, err) {
+			return
+		}
+		if len(response.Imported) > 0 {
+			touchRoom(game)
+			sendGameState(game)
+		}
+
+		sendResponseWithStatus(w, http.StatusCreated, response)
+	}
+}
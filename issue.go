@@ -2,9 +2,15 @@ package main
 
 import (
 	"database/sql"
+	"fmt"
+	"log"
+	"unicode/utf8"
 )
 
 type IssueRequest struct {
+	// Must be the room admin or a team admin. Websocket messages are
+	// checked against the connection instead.
+	UserUUID    string `json:"userUUID"`
 	RoomID      int    `json:"room_id"`
 	Title       string `json:"title"`
 	Description string `json:"description"`
@@ -24,32 +30,368 @@ func createIssue(database *sql.DB, roomId int, uuid string, issue IssueRequest)
 	return id, nil
 }
 
-func updateIssueOrder(database *sql.DB, roomId, issueId, sequence int) error {
-	_, err := database.Exec("UPDATE issues SET sequence = $1 WHERE room_id = $2 AND id = $3", sequence, roomId, issueId)
+// addIssue appends a new issue to the end of the room's backlog.
+func addIssue(database *sql.DB, game *Game, req IssueRequest) (Issue, error) {
+	req.RoomID = game.roomID
+	req.Sequence = len(game.issues)
+
+	uuid := generateUuid()
+	id, err := createIssue(database, game.roomID, uuid, req)
 	if err != nil {
-		return err
+		return Issue{}, err
 	}
 
-	return nil
+	issue := Issue{
+		ID:          int(id),
+		UUID:        uuid,
+		Title:       req.Title,
+		Description: req.Description,
+		Link:        req.Link,
+		Sequence:    req.Sequence,
+	}
+	game.issues = append(game.issues, issue)
+	emitRoomEvent(game, eventIssueAdded, IssueResponse{Issue: issue})
+	return issue, nil
 }
 
-func getIssueByUUID(db *sql.DB, roomID int, issueUUID string) (map[string]interface{}, error) {
-	var id int
-	var title, description, link string
+// addIssues appends several issues to the end of the backlog in a single
+// transaction. It is used by the importers, which must not leave half an
+// import behind.
+func addIssues(database *sql.DB, game *Game, issues []Issue) ([]Issue, error) {
+	tx, err := database.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Continue after the last persisted issue even if the backlog was not
+	// loaded into memory.
+	next := len(game.issues)
+	var persisted int
+	if err := tx.QueryRow("SELECT COALESCE(MAX(sequence) + 1, 0) FROM issues WHERE room_id = $1", game.roomID).Scan(&persisted); err != nil {
+		return nil, err
+	}
+	if persisted > next {
+		next = persisted
+	}
 
-	err := db.QueryRow("SELECT id, title, description, link FROM issues WHERE room_id = $1 AND uuid = $2", roomID, issueUUID).
-		Scan(&id, &title, &description, &link)
+	created, err := insertIssues(tx, game.roomID, next, issues)
 	if err != nil {
 		return nil, err
 	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
 
-	// Criar o mapa manualmente
-	issue := map[string]interface{}{
-		"id":          id,
-		"title":       title,
-		"description": description,
-		"link":        link,
+	game.issues = append(game.issues, created...)
+	for _, issue := range created {
+		emitRoomEvent(game, eventIssueAdded, IssueResponse{Issue: issue})
 	}
+	return created, nil
+}
 
+// insertIssues inserts issues into the room within tx, numbering them from
+// sequence next.
+func insertIssues(tx *sql.Tx, roomID int, next int, issues []Issue) ([]Issue, error) {
+	statement, err := tx.Prepare(`INSERT INTO issues (room_id, uuid, title, description, link, sequence, external_source, external_key)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, '')) RETURNING id`)
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	created := make([]Issue, 0, len(issues))
+	for i, issue := range issues {
+		issue.UUID = generateUuid()
+		issue.Sequence = next + i
+		err := statement.QueryRow(roomID, issue.UUID, issue.Title, issue.Description, issue.Link, issue.Sequence,
+			issue.Source, issue.ExternalKey).Scan(&issue.ID)
+		if err != nil {
+			return nil, err
+		}
+		created = append(created, issue)
+	}
+	return created, nil
+}
+
+// importedKeys returns the external keys of the room's issues that came from
+// source, so re-running an import does not duplicate them.
+func importedKeys(database *sql.DB, roomID int, source string) (map[string]bool, error) {
+	rows, err := database.Query("SELECT external_key FROM issues WHERE room_id = $1 AND external_source = $2 AND external_key IS NOT NULL", roomID, source)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make(map[string]bool)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys[key] = true
+	}
+	return keys, rows.Err()
+}
+
+// setIssueEstimate records the value the team agreed on for an issue and
+// writes it back to the tracker the issue was imported from. A nil
+// decisionNote keeps the note the issue already has. Callers check that the
+// estimate comes from a facilitator.
+func setIssueEstimate(database *sql.DB, game *Game, issueUUID string, estimate string, decisionNote *string) (Issue, error) {
+	if estimate == "" {
+		return Issue{}, errMissingField("estimate")
+	}
+	if utf8.RuneCountInString(estimate) > 16 {
+		return Issue{}, errInvalidField("estimate", "estimate must be at most 16 characters")
+	}
+	if err := validateDecisionNote(decisionNote); err != nil {
+		return Issue{}, err
+	}
+
+	index := -1
+	for i := range game.issues {
+		if game.issues[i].UUID == issueUUID {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return Issue{}, errIssueNotFound
+	}
+
+	var note sql.NullString
+	err := database.QueryRow(`UPDATE issues SET estimate = $1, estimated_at = CURRENT_TIMESTAMP, decision_note = COALESCE($2, decision_note)
+		WHERE room_id = $3 AND uuid = $4
+		RETURNING decision_note`,
+		estimate, decisionNote, game.roomID, issueUUID).Scan(&note)
+	if err != nil {
+		return Issue{}, err
+	}
+
+	game.issues[index].Estimate = &estimate
+	game.issues[index].DecisionNote = nil
+	if note.Valid {
+		game.issues[index].DecisionNote = &note.String
+	}
+	issue := game.issues[index]
+	if err := scoreIssue(database, game, issue); err != nil {
+		log.Printf("Error scoring votes of issue %s: %v", issue.UUID, err)
+	}
+	emitRoomEvent(game, eventEstimateAgreed, EstimateAgreedData{Issue: issue})
+	if issue.Source != "" {
+		go writeBackEstimate(issue)
+	}
 	return issue, nil
 }
+
+type UpdateIssueRequest struct {
+	// Must be the room admin or a team admin. Websocket messages are
+	// checked against the connection instead.
+	UserUUID     string  `json:"userUUID"`
+	Title        *string `json:"title"`
+	Description  *string `json:"description"`
+	Link         *string `json:"link"`
+	DecisionNote *string `json:"decisionNote"`
+}
+
+// validateDecisionNote bounds the note; an empty note is allowed and clears it.
+func validateDecisionNote(note *string) error {
+	if note != nil && utf8.RuneCountInString(*note) > maxCommentLength {
+		return errInvalidField("decisionNote", fmt.Sprintf("decisionNote must be at most %d characters", maxCommentLength))
+	}
+	return nil
+}
+
+// updateIssue changes the fields present in req. The database is the source
+// of truth, so this works whether or not the backlog is loaded in memory.
+func updateIssue(database *sql.DB, game *Game, issueUUID string, req UpdateIssueRequest) (Issue, error) {
+	if req.Title != nil {
+		if *req.Title == "" {
+			return Issue{}, errMissingField("title")
+		}
+		if utf8.RuneCountInString(*req.Title) > 255 {
+			return Issue{}, errInvalidField("title", "title must be at most 255 characters")
+		}
+	}
+	if err := validateDecisionNote(req.DecisionNote); err != nil {
+		return Issue{}, err
+	}
+	if !isValidUUID(issueUUID) {
+		return Issue{}, errIssueNotFound
+	}
+
+	var issue Issue
+	var title, description, link, estimate, decisionNote sql.NullString
+	err := database.QueryRow(`
+		UPDATE issues SET
+			title = COALESCE($1, title),
+			description = COALESCE($2, description),
+			link = COALESCE($3, link),
+			decision_note = COALESCE($4, decision_note),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE room_id = $5 AND uuid = $6
+		RETURNING id, uuid, title, description, link, sequence, estimate, decision_note,
+			COALESCE(external_source, ''), COALESCE(external_key, ''), (SELECT COUNT(*) FROM rounds WHERE issue_id = issues.id)`,
+		req.Title, req.Description, req.Link, req.DecisionNote, game.roomID, issueUUID).
+		Scan(&issue.ID, &issue.UUID, &title, &description, &link, &issue.Sequence, &estimate, &decisionNote,
+			&issue.Source, &issue.ExternalKey, &issue.Rounds)
+	if err == sql.ErrNoRows {
+		return Issue{}, errIssueNotFound
+	}
+	if err != nil {
+		return Issue{}, err
+	}
+	issue.Title = title.String
+	issue.Description = description.String
+	issue.Link = link.String
+	if estimate.Valid {
+		issue.Estimate = &estimate.String
+	}
+	if decisionNote.Valid {
+		issue.DecisionNote = &decisionNote.String
+	}
+
+	for i := range game.issues {
+		if game.issues[i].UUID == issue.UUID {
+			game.issues[i] = issue
+			break
+		}
+	}
+	return issue, nil
+}
+
+// deleteIssue removes an issue and closes the gap it leaves in the
+// sequence. Rounds voted on it are kept in the history without an issue.
+func deleteIssue(database *sql.DB, game *Game, issueUUID string) error {
+	if !isValidUUID(issueUUID) {
+		return errIssueNotFound
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var issueID, sequence int
+	err = tx.QueryRow("SELECT id, sequence FROM issues WHERE room_id = $1 AND uuid = $2 FOR UPDATE", game.roomID, issueUUID).Scan(&issueID, &sequence)
+	if err == sql.ErrNoRows {
+		return errIssueNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	steps := []string{
+		"UPDATE votes SET issue_id = NULL WHERE issue_id = $1",
+		"DELETE FROM issues WHERE id = $1",
+	}
+	for _, step := range steps {
+		if _, err := tx.Exec(step, issueID); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("UPDATE issues SET sequence = sequence - 1 WHERE room_id = $1 AND sequence > $2", game.roomID, sequence)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	remaining := game.issues[:0]
+	for _, issue := range game.issues {
+		if issue.UUID == issueUUID {
+			continue
+		}
+		if issue.Sequence > sequence {
+			issue.Sequence--
+		}
+		remaining = append(remaining, issue)
+	}
+	game.issues = remaining
+
+	if game.currentIssueID == issueID {
+		game.currentIssueID = 0
+		game.currentIssueUUID = ""
+	}
+	return nil
+}
+
+// reorderIssues applies a new order to the whole backlog. order must list
+// every issue of the room exactly once.
+func reorderIssues(database *sql.DB, game *Game, order []string) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Read the backlog inside the transaction and lock it, so an issue
+	// deleted meanwhile waits for the new order instead of racing it
+	rows, err := tx.Query("SELECT id, uuid FROM issues WHERE room_id = $1 FOR UPDATE", game.roomID)
+	if err != nil {
+		return err
+	}
+	ids := make(map[string]int)
+	for rows.Next() {
+		var id int
+		var uuid string
+		if err := rows.Scan(&id, &uuid); err != nil {
+			rows.Close()
+			return err
+		}
+		ids[uuid] = id
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := checkIssueOrder(ids, order); err != nil {
+		return err
+	}
+
+	statement, err := tx.Prepare("UPDATE issues SET sequence = $1 WHERE id = $2")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	for sequence, uuid := range order {
+		if _, err := statement.Exec(sequence, ids[uuid]); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	issues, err := fetchIssuesFromDB(database, game.roomID)
+	if err != nil {
+		return err
+	}
+	game.issues = issues
+	return nil
+}
+
+// checkIssueOrder accepts order only if it lists every issue of ids, by uuid,
+// exactly once.
+func checkIssueOrder(ids map[string]int, order []string) error {
+	if len(order) != len(ids) {
+		return errInvalidField("issues", fmt.Sprintf("Expected %d issues, got %d", len(ids), len(order)))
+	}
+	seen := make(map[string]bool, len(order))
+	for _, uuid := range order {
+		if _, exists := ids[uuid]; !exists {
+			return errInvalidField("issues", "Unknown issue "+uuid)
+		}
+		if seen[uuid] {
+			return errInvalidField("issues", "Issue "+uuid+" is listed more than once")
+		}
+		seen[uuid] = true
+	}
+	return nil
+}
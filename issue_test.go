@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestCheckIssueOrder(t *testing.T) {
+	ids := map[string]int{"a": 1, "b": 2, "c": 3}
+	tests := []struct {
+		name    string
+		ids     map[string]int
+		order   []string
+		wantErr bool
+	}{
+		{"same order", ids, []string{"a", "b", "c"}, false},
+		{"reversed", ids, []string{"c", "b", "a"}, false},
+		{"empty backlog", map[string]int{}, []string{}, false},
+		{"missing issue", ids, []string{"a", "b"}, true},
+		{"extra issue", ids, []string{"a", "b", "c", "d"}, true},
+		{"unknown issue", ids, []string{"a", "b", "d"}, true},
+		{"duplicate replacing an issue", ids, []string{"a", "a", "c"}, true},
+		{"empty order", ids, []string{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkIssueOrder(tt.ids, tt.order)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if err != nil && toAPIError(err).Code != "invalid_field" {
+				t.Errorf("got error code %s, want invalid_field", toAPIError(err).Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+const issueSourceJira = "jira"
+
+type jiraClient struct {
+	http             providerHTTP
+	storyPointsField string
+}
+
+// newJiraClientFromEnv returns nil when JIRA_BASE_URL is not set.
+func newJiraClientFromEnv() *jiraClient {
+	baseURL := os.Getenv("JIRA_BASE_URL")
+	if baseURL == "" {
+		return nil
+	}
+
+	storyPointsField := os.Getenv("JIRA_STORY_POINTS_FIELD")
+	if storyPointsField == "" {
+		storyPointsField = "customfield_10016"
+	}
+
+	email, token := os.Getenv("JIRA_EMAIL"), os.Getenv("JIRA_API_TOKEN")
+	return &jiraClient{
+		http: newProviderHTTP(issueSourceJira, baseURL, func(req *http.Request) {
+			if email != "" || token != "" {
+				req.SetBasicAuth(email, token)
+			}
+		}),
+		storyPointsField: storyPointsField,
+	}
+}
+
+type jiraSearchResponse struct {
+	StartAt    int `json:"startAt"`
+	MaxResults int `json:"maxResults"`
+	Total      int `json:"total"`
+	Issues     []struct {
+		Key    string `json:"key"`
+		Fields struct {
+			Summary     string  `json:"summary"`
+			Description *string `json:"description"`
+		} `json:"fields"`
+	} `json:"issues"`
+}
+
+// FetchIssues runs the JQL query, or lists the board when no query is given.
+func (c *jiraClient) FetchIssues(req ImportIssuesRequest) ([]ExternalIssue, error) {
+	if req.JQL != "" {
+		return c.paginate("/rest/api/2/search", url.Values{"jql": {req.JQL}}, req.MaxResults)
+	}
+	if req.BoardID != 0 {
+		return c.paginate(fmt.Sprintf("/rest/agile/1.0/board/%d/issue", req.BoardID), url.Values{}, req.MaxResults)
+	}
+	return nil, errMissingField("jql")
+}
+
+// paginate follows startAt/maxResults until limit issues were read or the
+// result set is exhausted.
+func (c *jiraClient) paginate(path string, query url.Values, limit int) ([]ExternalIssue, error) {
+	issues := []ExternalIssue{}
+	query.Set("fields", "summary,description")
+
+	for len(issues) < limit {
+		query.Set("startAt", strconv.Itoa(len(issues)))
+		query.Set("maxResults", strconv.Itoa(limit-len(issues)))
+
+		var page jiraSearchResponse
+		if err := c.http.do(http.MethodGet, path+"?"+query.Encode(), nil, &page); err != nil {
+			return nil, err
+		}
+
+		for _, item := range page.Issues {
+			issue := ExternalIssue{
+				Key:   item.Key,
+				Title: item.Fields.Summary,
+				URL:   c.http.baseURL + "/browse/" + item.Key,
+			}
+			if item.Fields.Description != nil {
+				issue.Description = *item.Fields.Description
+			}
+			issues = append(issues, issue)
+		}
+
+		if len(page.Issues) == 0 || page.StartAt+len(page.Issues) >= page.Total {
+			break
+		}
+	}
+	if len(issues) > limit {
+		issues = issues[:limit]
+	}
+	return issues, nil
+}
+
+// WriteEstimate stores the estimate in the story points field. Estimates
+// that are not numbers ("?", "☕") are not sent.
+func (c *jiraClient) WriteEstimate(key string, estimate string) error {
+	points, ok := parseVote(estimate)
+	if !ok {
+		log.Printf("Not writing estimate %q of %s back to Jira: not a number", estimate, key)
+		return nil
+	}
+
+	body := map[string]interface{}{
+		"fields": map[string]interface{}{c.storyPointsField: points},
+	}
+	return c.http.do(http.MethodPut, "/rest/api/2/issue/"+url.PathEscape(key), body, nil)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeJira serves total issues, at most pageSize per page, and records the
+// estimates written back.
+type fakeJira struct {
+	total    int
+	pageSize int
+
+	mu        sync.Mutex
+	searches  []string
+	estimates map[string]map[string]interface{}
+}
+
+func (f *fakeJira) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, password, ok := r.BasicAuth(); !ok || user != "bot@example.com" || password != "token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/rest/api/2/search":
+		f.searches = append(f.searches, r.URL.RawQuery)
+		startAt, _ := strconv.Atoi(r.URL.Query().Get("startAt"))
+		maxResults, _ := strconv.Atoi(r.URL.Query().Get("maxResults"))
+		maxResults = min(maxResults, f.pageSize)
+
+		issues := []map[string]interface{}{}
+		for i := startAt; i < f.total && i < startAt+maxResults; i++ {
+			issues = append(issues, map[string]interface{}{
+				"key":    fmt.Sprintf("ABC-%d", i+1),
+				"fields": map[string]interface{}{"summary": fmt.Sprintf("Issue %d", i+1), "description": nil},
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"startAt": startAt, "maxResults": maxResults, "total": f.total, "issues": issues})
+	case r.Method == http.MethodPut && len(r.URL.Path) > len("/rest/api/2/issue/"):
+		var body struct {
+			Fields map[string]interface{} `json:"fields"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.estimates[r.URL.Path[len("/rest/api/2/issue/"):]] = body.Fields
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newFakeJira(t *testing.T, total int, pageSize int) (*fakeJira, *jiraClient) {
+	fake := &fakeJira{total: total, pageSize: pageSize, estimates: map[string]map[string]interface{}{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	t.Setenv("JIRA_BASE_URL", server.URL+"/")
+	t.Setenv("JIRA_EMAIL", "bot@example.com")
+	t.Setenv("JIRA_API_TOKEN", "token")
+	t.Setenv("JIRA_STORY_POINTS_FIELD", "")
+	return fake, newJiraClientFromEnv()
+}
+
+func TestJiraFetchIssuesPaginates(t *testing.T) {
+	tests := []struct {
+		name       string
+		total      int
+		maxResults int
+		want       int
+		pages      int
+	}{
+		{"all pages", 5, 50, 5, 3},
+		{"stops at maxResults", 5, 3, 3, 2},
+		{"empty result", 0, 50, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newFakeJira(t, tt.total, 2)
+
+			issues, err := client.FetchIssues(ImportIssuesRequest{JQL: "project = ABC", MaxResults: tt.maxResults})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(issues) != tt.want {
+				t.Fatalf("got %d issues, want %d", len(issues), tt.want)
+			}
+			for i, issue := range issues {
+				key := fmt.Sprintf("ABC-%d", i+1)
+				if issue.Key != key || issue.Title != fmt.Sprintf("Issue %d", i+1) || issue.URL != client.http.baseURL+"/browse/"+key {
+					t.Errorf("issue %d: got %+v", i, issue)
+				}
+			}
+			if len(fake.searches) != tt.pages {
+				t.Errorf("fetched %d pages, want %d: %v", len(fake.searches), tt.pages, fake.searches)
+			}
+		})
+	}
+}
+
+func TestJiraWriteEstimate(t *testing.T) {
+	fake, client := newFakeJira(t, 0, 2)
+
+	if err := client.WriteEstimate("ABC-1", "5"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WriteEstimate("ABC-2", "?"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fake.estimates["ABC-1"]["customfield_10016"]; got != 5.0 {
+		t.Errorf("ABC-1 story points: got %v, want 5", got)
+	}
+	if _, written := fake.estimates["ABC-2"]; written {
+		t.Errorf("non-numeric estimate was written back: %v", fake.estimates["ABC-2"])
+	}
+}
+
+func TestWriteBackEstimateUsesIssueSource(t *testing.T) {
+	fake, client := newFakeJira(t, 0, 2)
+	previous := issueProviders
+	issueProviders = map[string]IssueProvider{issueSourceJira: client}
+	t.Cleanup(func() { issueProviders = previous })
+
+	estimate := "8"
+	writeBackEstimate(Issue{Source: issueSourceJira, ExternalKey: "ABC-7", Estimate: &estimate})
+	writeBackEstimate(Issue{Source: issueSourceGitHub, ExternalKey: "org/repo#1", Estimate: &estimate})
+
+	if len(fake.estimates) != 1 || fake.estimates["ABC-7"]["customfield_10016"] != 8.0 {
+		t.Errorf("got write-backs %v", fake.estimates)
+	}
+}
+
+func TestSetIssueEstimate(t *testing.T) {
+	fake, client := newFakeJira(t, 0, 2)
+	previous := issueProviders
+	issueProviders = map[string]IssueProvider{issueSourceJira: client}
+	t.Cleanup(func() { issueProviders = previous })
+
+	database := openTestDB(t, `CREATE TABLE issues (room_id INTEGER, uuid TEXT, estimate TEXT, estimated_at TIMESTAMP, decision_note TEXT)`)
+	game := &Game{roomID: 1, admin: 1, issues: []Issue{
+		{UUID: generateUuid(), Source: issueSourceJira, ExternalKey: "ABC-1"},
+		{UUID: generateUuid()},
+	}}
+	for _, issue := range game.issues {
+		if _, err := database.Exec("INSERT INTO issues (room_id, uuid) VALUES (1, $1)", issue.UUID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		issue    string
+		estimate string
+		wantErr  bool
+	}{
+		{"missing", game.issues[1].UUID, "", true},
+		{"16 multibyte characters", game.issues[1].UUID, strings.Repeat("½", 16), false},
+		{"17 characters", game.issues[1].UUID, strings.Repeat("1", 17), true},
+		{"unknown issue", generateUuid(), "3", true},
+		{"imported issue", game.issues[0].UUID, "5", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issue, err := setIssueEstimate(database, game, tt.issue, tt.estimate, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if err == nil && (issue.Estimate == nil || *issue.Estimate != tt.estimate) {
+				t.Errorf("got estimate %v, want %q", issue.Estimate, tt.estimate)
+			}
+		})
+	}
+
+	// Write-backs run in the background
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		fake.mu.Lock()
+		written := len(fake.estimates)
+		fake.mu.Unlock()
+		if written > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.estimates) != 1 || fake.estimates["ABC-1"]["customfield_10016"] != 5.0 {
+		t.Errorf("got write-backs %v, want ABC-1 = 5", fake.estimates)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"log"
 	"net/http"
 	"os"
@@ -28,6 +29,7 @@ func cleanupExpiredRooms() {
 		select {
 		case <-cleanupTicker.C:
 			now := time.Now()
+			gamesMu.Lock()
 			for roomUUID, game := range games {
 				if now.Sub(game.lastActive) > roomExpiry {
 					ammountOfPlayers := len(game.Players)
@@ -37,10 +39,33 @@ func cleanupExpiredRooms() {
 					}
 				}
 			}
+			gamesMu.Unlock()
 		}
 	}
 }
 
+func setupRouter(database *sql.DB) (*mux.Router, map[string]methodRouter) {
+	r := mux.NewRouter()
+
+	r.HandleFunc("/ws/{roomUUID}/{userUUID}", enableCors(handleConnections(database))).Methods(http.MethodGet, http.MethodOptions)
+	r.HandleFunc("/createRoom", enableCors(createRoom(database))).Methods(http.MethodPost, http.MethodOptions)
+	r.HandleFunc("/rooms", enableCors(listRooms(database))).Methods(http.MethodGet, http.MethodOptions)
+	r.HandleFunc("/joinRoom", enableCors(joinRoom(database))).Methods(http.MethodPost, http.MethodOptions)
+	r.HandleFunc("/leaveRoom", enableCors(leaveRoom(database))).Methods(http.MethodPost, http.MethodOptions)
+	r.HandleFunc("/showCards", enableCors(showCards(database))).Methods(http.MethodPost, http.MethodOptions)
+	r.HandleFunc("/autoShowCards", enableCors(autoShowCards(database))).Methods(http.MethodPost, http.MethodOptions)
+	r.HandleFunc("/resetVotes", enableCors(resetVotes(database))).Methods(http.MethodPost, http.MethodOptions)
+	r.HandleFunc("/changeName", enableCors(changeName(database))).Methods(http.MethodPost, http.MethodOptions)
+	r.HandleFunc("/changeRoomName", enableCors(changeRoomName(database))).Methods(http.MethodPost, http.MethodOptions)
+	r.HandleFunc("/kickPlayer", enableCors(kickPlayer(database))).Methods(http.MethodPost, http.MethodOptions)
+	r.HandleFunc("/deleteRoom", enableCors(deleteRoom(database))).Methods(http.MethodPost, http.MethodOptions)
+	r.HandleFunc("/openapi.json", enableCors(serveOpenAPI())).Methods(http.MethodGet, http.MethodOptions)
+
+	apiRoutes := registerAPIRoutes(r, database)
+
+	return r, apiRoutes
+}
+
 func main() {
 	// Setup database connection
 	database := setupDatabase()
@@ -56,20 +81,30 @@ func main() {
 	}()
 	
 	log.Println("Setting up routes...")
-	r := mux.NewRouter()
+	r, _ := setupRouter(database)
+
+	// Deliver room events to the registered webhooks
+	allowPrivateWebhooks = os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true"
+	webhooks = newWebhookDispatcher(database)
+	webhooks.start(envInt("WEBHOOK_WORKERS", 4))
 
-	r.HandleFunc("/ws/{roomUUID}/{userUUID}", enableCors(handleConnections(database)))
-	r.HandleFunc("/createRoom", enableCors(createRoom(database)))
-	r.HandleFunc("/joinRoom", enableCors(joinRoom(database)))
-	r.HandleFunc("/leaveRoom", enableCors(leaveRoom(database)))
-	r.HandleFunc("/showCards", enableCors(showCards(database)))
-	r.HandleFunc("/autoShowCards", enableCors(autoShowCards(database)))
-	r.HandleFunc("/resetVotes", enableCors(resetVotes(database)))
-	r.HandleFunc("/changeName", enableCors(changeName(database)))
-	r.HandleFunc("/changeRoomName", enableCors(changeRoomName(database)))
-	r.HandleFunc("/kickPlayer", enableCors(kickPlayer(database)))
+	// Keep the history of revealed rounds
+	roundHistory = &roundRecorder{db: database}
+
+	// Optional issue tracker integrations
+	issueProviders = loadIssueProviders()
+
+	// Allowed emojis and how many a player can send
+	emojiPolicy = loadEmojiPolicy()
+
+	// Chat message size and pace
+	chatPolicy = loadChatPolicy()
+
+	// Idle time after which a persistent room starts a new session
+	sessionGap = time.Duration(envInt("SESSION_GAP_HOURS", 12)) * time.Hour
 
 	// Start cleanup routine in a goroutine
+
 	cleanupDone := make(chan bool)
 	go func() {
 		defer func() {
@@ -78,6 +113,26 @@ func main() {
 		cleanupExpiredRooms()
 	}()
 
+	// Start the writer that persists room activity in batches
+	activityStop := make(chan struct{})
+	activityDone := make(chan bool)
+	go func() {
+		defer func() {
+			activityDone <- true
+		}()
+		runActivityFlusher(database, time.Duration(envInt("ACTIVITY_FLUSH_SECONDS", 30))*time.Second, activityStop)
+	}()
+
+	// Start the retention job that archives stale rooms and purges orphan guests
+	retentionStop := make(chan struct{})
+	retentionDone := make(chan bool)
+	go func() {
+		defer func() {
+			retentionDone <- true
+		}()
+		runRetentionJob(database, loadRetentionPolicy(), retentionStop)
+	}()
+
 	// Create a server with timeout configuration
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -107,8 +162,10 @@ func main() {
 	// Block until a signal is received
 	<-stop
 	
-	// Stop the cleanup ticker
+	// Stop the cleanup ticker and the retention job
 	cleanupTicker.Stop()
+	close(retentionStop)
+	close(activityStop)
 	
 	// Create a context with timeout for shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -128,5 +185,19 @@ func main() {
 		log.Println("Cleanup routine timeout, forcing shutdown")
 	}
 	
+	select {
+	case <-retentionDone:
+		log.Println("Retention job finished")
+	case <-time.After(5 * time.Second):
+		log.Println("Retention job timeout, forcing shutdown")
+	}
+
+	select {
+	case <-activityDone:
+		log.Println("Room activity flushed")
+	case <-time.After(5 * time.Second):
+		log.Println("Room activity flush timeout, forcing shutdown")
+	}
+
 	log.Println("Server gracefully stopped")
 }
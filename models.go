@@ -1,6 +1,7 @@
 package main
 
 import (
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -14,6 +15,7 @@ type Player struct {
 	Voted       bool    `json:"voted"`
 	Vote        *string `json:"vote"`
 	Admin       bool    `json:"admin"`
+	Observer    bool    `json:"observer"`
 	connections []*websocket.Conn
 }
 
@@ -23,30 +25,102 @@ type CardOption struct {
 }
 
 type Issue struct {
-	ID          int    `json:"id"`
-	UUID        string `json:"uuid"`
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	Link        string `json:"link"`
-	Sequence    int    `json:"sequence"`
+	ID           int     `json:"id"`
+	UUID         string  `json:"uuid"`
+	Title        string  `json:"title"`
+	Description  string  `json:"description"`
+	Link         string  `json:"link"`
+	Sequence     int     `json:"sequence"`
+	Estimate     *string `json:"estimate"`
+	DecisionNote *string `json:"decisionNote"`
+	Source       string  `json:"source,omitempty"`
+	ExternalKey  string  `json:"externalKey,omitempty"`
+	Rounds       int     `json:"rounds"`
 }
 
 type Game struct {
-	Players       []*Player
-	name          string
-	admin         int
-	showCards     bool
-	autoShowCards bool
-	roomID        int
-	roomUUID      string
-	lastActive    time.Time
-	Emojis        []EmojiMessage
-	deck          []CardOption
-	issues        []Issue
+	Players    []*Player
+	name       string
+	admin      int
+	showCards  bool
+	roomID     int
+	roomUUID   string
+	lastActive time.Time
+	archived   bool
+	Emojis     []EmojiMessage
+	deck       []CardOption
+	issues     []Issue
+
+	// The round being voted: the issue it is about, when it started and its
+	// row in rounds once it has been revealed
+	currentIssueID   int
+	currentIssueUUID string
+	roundStartedAt   time.Time
+	roundID          int
+
+	// Countdown of the current round, nil when none was started
+	timer *roundTimer
+
+	// Results of the earlier rounds of a re-vote, oldest first
+	previousRounds []RoundResult
+
+	// Auto reveal, anonymous voting, policies and limits of the room
+	settings RoomSettings
+
+	// Persistent rooms are never archived and group their rounds in
+	// sessions; session is the open one, nil when there is none
+	persistent bool
+	session    *RoomSession
+
+	// The team the room belongs to, whose admins facilitate it like the owner
+	teamID     int
+	teamUUID   string
+	teamAdmins map[int]bool
+
+	// When each player last sent emojis, for rate limiting
+	emojiSent map[int][]time.Time
+
+	// When each player last sent chat messages, for rate limiting
+	chatSent map[int][]time.Time
+
+	// Held while a websocket message or the timer expiry is handled, so only
+	// one of them changes the room and writes to its connections at a time
+	mu sync.Mutex
+}
+
+// RoomState is the public view of a Game, shared by the websocket
+// gameState message and the REST API.
+type RoomState struct {
+	Players             []*Player       `json:"players"`
+	ShowCards           bool            `json:"showCards"`
+	AutoShowCards       bool            `json:"autoShowCards"`
+	RoomUUID            string          `json:"roomUUID"`
+	Name                string          `json:"name"`
+	Admin               int             `json:"admin"`
+	Deck                []CardOption    `json:"deck"`
+	Issues              []Issue         `json:"issues"`
+	CurrentIssue        *string         `json:"currentIssue"`
+	Timer               *TimerState     `json:"timer"`
+	PreviousRounds      []RoundResult   `json:"previousRounds"`
+	Anonymous           bool            `json:"anonymous"`
+	KeepVoteAttribution bool            `json:"keepVoteAttribution"`
+	AnonymousVotes      *AnonymousVotes `json:"anonymousVotes"`
+	RecentEmojis        []EmojiMessage  `json:"recentEmojis"`
+	Settings            RoomSettings    `json:"settings"`
+	Persistent          bool            `json:"persistent"`
+	Session             *RoomSession    `json:"session"`
+	TeamUUID            *string         `json:"teamUUID"`
+}
+
+type GameStateMessage struct {
+	Type string `json:"type"`
+	RoomState
+	Emojis []EmojiMessage `json:"emojis"`
 }
 
 type EmojiMessage struct {
 	Emoji        string
 	OriginUserID int
 	TargetUserID int
+	SentAt       time.Time
 }
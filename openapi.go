@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiOperation documents one method of one REST route. Request and Response
+// hold a zero value of the JSON body types; nil means there is no body.
+type apiOperation struct {
+	ID       string
+	Method   string
+	Path     string
+	Summary  string
+	Query    []string
+	Request  interface{}
+	Response interface{}
+	Status   int
+}
+
+// apiOperations is the source of the OpenAPI document served at
+// /openapi.json. The tests fail when a registered route or method is missing
+// here.
+var apiOperations = []apiOperation{
+	{ID: "connectWebsocket", Method: http.MethodGet, Path: "/ws/{roomUUID}/{userUUID}", Summary: "Upgrade to the room websocket", Status: http.StatusSwitchingProtocols},
+	{ID: "getOpenAPIDocument", Method: http.MethodGet, Path: "/openapi.json", Summary: "This OpenAPI document"},
+
+	// Legacy routes, kept for the current frontend
+	{ID: "legacyCreateRoom", Method: http.MethodPost, Path: "/createRoom", Summary: "Create a room", Request: RoomRequest{}, Response: CreateRoomResponse{}},
+	{ID: "legacyListRooms", Method: http.MethodGet, Path: "/rooms", Summary: "List the user's recently active rooms", Query: []string{"userUUID", "activeWithinDays"}, Response: RoomListResponse{}},
+	{ID: "legacyJoinRoom", Method: http.MethodPost, Path: "/joinRoom", Summary: "Join a room", Request: JoinRoomRequest{}, Response: JoinRoomResponse{}},
+	{ID: "legacyLeaveRoom", Method: http.MethodPost, Path: "/leaveRoom", Summary: "Leave a room", Request: LeaveRoomRequest{}},
+	{ID: "legacyShowCards", Method: http.MethodPost, Path: "/showCards", Summary: "Toggle the cards visibility", Request: ShowCardsRequest{}},
+	{ID: "legacyAutoShowCards", Method: http.MethodPost, Path: "/autoShowCards", Summary: "Enable or disable automatic reveal (admin only)", Request: AutoShowCardsRequest{}},
+	{ID: "legacyResetVotes", Method: http.MethodPost, Path: "/resetVotes", Summary: "Clear the votes and hide the cards", Request: ResetVotesRequest{}},
+	{ID: "legacyChangeName", Method: http.MethodPost, Path: "/changeName", Summary: "Rename a player", Request: ChangeNameRequest{}},
+	{ID: "legacyChangeRoomName", Method: http.MethodPost, Path: "/changeRoomName", Summary: "Rename a room (owner and team admins)", Request: ChangeRoomNameRequest{}},
+	{ID: "legacyKickPlayer", Method: http.MethodPost, Path: "/kickPlayer", Summary: "Remove a player from a room (owner and team admins)", Request: KickPlayerRequest{}},
+	{ID: "legacyDeleteRoom", Method: http.MethodPost, Path: "/deleteRoom", Summary: "Hard-delete a room (owner only)", Request: DeleteRoomRequest{}, Response: DeleteRoomResponse{}},
+
+	// /api/v1
+	{ID: "listRooms", Method: http.MethodGet, Path: "/api/v1/rooms", Summary: "List the user's recently active rooms", Query: []string{"userUUID", "activeWithinDays"}, Response: RoomListResponse{}},
+	{ID: "createRoom", Method: http.MethodPost, Path: "/api/v1/rooms", Summary: "Create a room", Request: RoomRequest{}, Response: CreateRoomResponse{}, Status: http.StatusCreated},
+	{ID: "getRoom", Method: http.MethodGet, Path: "/api/v1/rooms/{roomUUID}", Summary: "Get the room state", Response: RoomState{}},
+	{ID: "updateRoom", Method: http.MethodPatch, Path: "/api/v1/rooms/{roomUUID}", Summary: "Update the room name, or its auto reveal, anonymity, persistence or team (admin only)", Request: UpdateRoomRequest{}, Response: RoomState{}},
+	{ID: "deleteRoom", Method: http.MethodDelete, Path: "/api/v1/rooms/{roomUUID}", Summary: "Hard-delete a room (owner only)", Query: []string{"userUUID"}, Status: http.StatusNoContent},
+	{ID: "getRoomSettings", Method: http.MethodGet, Path: "/api/v1/rooms/{roomUUID}/settings", Summary: "Get the room settings", Response: RoomSettingsResponse{}},
+	{ID: "updateRoomSettings", Method: http.MethodPatch, Path: "/api/v1/rooms/{roomUUID}/settings", Summary: "Change some of the room settings (owner only)", Query: []string{"userUUID"}, Request: RoomSettingsPatch{}, Response: RoomSettingsResponse{}},
+	{ID: "listSessions", Method: http.MethodGet, Path: "/api/v1/rooms/{roomUUID}/sessions", Summary: "List the sessions of a persistent room, newest first (members and admins)", Query: []string{"userUUID"}, Response: SessionsResponse{}},
+	{ID: "startSession", Method: http.MethodPost, Path: "/api/v1/rooms/{roomUUID}/sessions", Summary: "End the current session of a persistent room and start a new one (owner only)", Request: StartSessionRequest{}, Response: SessionResponse{}, Status: http.StatusCreated},
+	{ID: "getLeaderboard", Method: http.MethodGet, Path: "/api/v1/rooms/{roomUUID}/leaderboard", Summary: "Rank the room's players by estimation accuracy", Response: LeaderboardResponse{}},
+	{ID: "listUserScores", Method: http.MethodGet, Path: "/api/v1/users/{userUUID}/scores", Summary: "List a user's scored votes across rooms, newest first", Query: []string{"limit", "offset"}, Response: ScoreHistoryResponse{}},
+	{ID: "getRoomAnalytics", Method: http.MethodGet, Path: "/api/v1/rooms/{roomUUID}/analytics", Summary: "Aggregate the room's rounds and estimates over a period", Query: []string{"from", "to"}, Response: AnalyticsReport{}},
+	{ID: "getOwnerAnalytics", Method: http.MethodGet, Path: "/api/v1/users/{userUUID}/analytics", Summary: "Aggregate the rounds and estimates of every room the user owns", Query: []string{"from", "to"}, Response: AnalyticsReport{}},
+	{ID: "listUserTeams", Method: http.MethodGet, Path: "/api/v1/users/{userUUID}/teams", Summary: "List the teams the user belongs to", Response: TeamsResponse{}},
+	{ID: "createTeam", Method: http.MethodPost, Path: "/api/v1/teams", Summary: "Create a team with the user as its admin", Request: CreateTeamRequest{}, Response: TeamResponse{}, Status: http.StatusCreated},
+	{ID: "getTeam", Method: http.MethodGet, Path: "/api/v1/teams/{teamUUID}", Summary: "Get a team and its members (members only)", Query: []string{"userUUID"}, Response: TeamResponse{}},
+	{ID: "deleteTeam", Method: http.MethodDelete, Path: "/api/v1/teams/{teamUUID}", Summary: "Delete a team, keeping its rooms (team admins only)", Query: []string{"userUUID"}, Status: http.StatusNoContent},
+	{ID: "setTeamMember", Method: http.MethodPut, Path: "/api/v1/teams/{teamUUID}/members/{memberUUID}", Summary: "Add a member to the team or change their role (team admins only)", Request: TeamMemberRequest{}, Response: TeamResponse{}},
+	{ID: "removeTeamMember", Method: http.MethodDelete, Path: "/api/v1/teams/{teamUUID}/members/{memberUUID}", Summary: "Remove a member from the team (team admins, or the member)", Query: []string{"userUUID"}, Status: http.StatusNoContent},
+	{ID: "listTeamRooms", Method: http.MethodGet, Path: "/api/v1/teams/{teamUUID}/rooms", Summary: "List the team's rooms, most recently active first (members only)", Query: []string{"userUUID"}, Response: RoomListResponse{}},
+	{ID: "getTeamAnalytics", Method: http.MethodGet, Path: "/api/v1/teams/{teamUUID}/analytics", Summary: "Aggregate the rounds and estimates of the team's rooms over a period (members only)", Query: []string{"userUUID", "from", "to"}, Response: AnalyticsReport{}},
+	{ID: "listTemplates", Method: http.MethodGet, Path: "/api/v1/users/{userUUID}/templates", Summary: "List the user's room templates", Response: TemplatesResponse{}},
+	{ID: "createTemplate", Method: http.MethodPost, Path: "/api/v1/users/{userUUID}/templates", Summary: "Save a room template, optionally copied from a room the user owns", Request: TemplateRequest{}, Response: TemplateResponse{}, Status: http.StatusCreated},
+	{ID: "getTemplate", Method: http.MethodGet, Path: "/api/v1/users/{userUUID}/templates/{templateUUID}", Summary: "Get a room template", Response: TemplateResponse{}},
+	{ID: "replaceTemplate", Method: http.MethodPut, Path: "/api/v1/users/{userUUID}/templates/{templateUUID}", Summary: "Replace a room template", Request: TemplateRequest{}, Response: TemplateResponse{}},
+	{ID: "deleteTemplate", Method: http.MethodDelete, Path: "/api/v1/users/{userUUID}/templates/{templateUUID}", Summary: "Delete a room template", Status: http.StatusNoContent},
+	{ID: "exportRoom", Method: http.MethodGet, Path: "/api/v1/rooms/{roomUUID}/export", Summary: "Export issues, estimates, rounds and votes as json, csv or markdown (members and admins)", Query: []string{"userUUID", "format"}, Response: ExportDocument{}},
+	{ID: "listPlayers", Method: http.MethodGet, Path: "/api/v1/rooms/{roomUUID}/players", Summary: "List the players of a room", Response: PlayersResponse{}},
+	{ID: "joinRoom", Method: http.MethodPost, Path: "/api/v1/rooms/{roomUUID}/players", Summary: "Join a room", Request: JoinRoomBody{}, Response: JoinRoomResponse{}, Status: http.StatusCreated},
+	{ID: "updatePlayer", Method: http.MethodPatch, Path: "/api/v1/rooms/{roomUUID}/players/{userUUID}", Summary: "Rename a player", Request: UpdatePlayerRequest{}, Status: http.StatusNoContent},
+	{ID: "removePlayer", Method: http.MethodDelete, Path: "/api/v1/rooms/{roomUUID}/players/{userUUID}", Summary: "Leave a room, or remove a player as the owner or a team admin", Query: []string{"userUUID"}, Status: http.StatusNoContent},
+	{ID: "listIssues", Method: http.MethodGet, Path: "/api/v1/rooms/{roomUUID}/issues", Summary: "List the room's issues in backlog order, a page at a time", Query: []string{"limit", "offset", "estimated"}, Response: IssuePageResponse{}},
+	{ID: "createIssue", Method: http.MethodPost, Path: "/api/v1/rooms/{roomUUID}/issues", Summary: "Add an issue to the backlog (admin only)", Request: IssueRequest{}, Response: IssueResponse{}, Status: http.StatusCreated},
+	{ID: "reorderIssues", Method: http.MethodPut, Path: "/api/v1/rooms/{roomUUID}/issues/order", Summary: "Reorder the whole backlog (admin only)", Request: ReorderIssuesRequest{}, Response: IssuesResponse{}},
+	{ID: "updateIssue", Method: http.MethodPatch, Path: "/api/v1/rooms/{roomUUID}/issues/{issueUUID}", Summary: "Edit an issue's title, description, link or decision note (admin only)", Request: UpdateIssueRequest{}, Response: IssueResponse{}},
+	{ID: "deleteIssue", Method: http.MethodDelete, Path: "/api/v1/rooms/{roomUUID}/issues/{issueUUID}", Summary: "Delete an issue (admin only)", Query: []string{"userUUID"}, Status: http.StatusNoContent},
+	{ID: "setIssueEstimate", Method: http.MethodPut, Path: "/api/v1/rooms/{roomUUID}/issues/{issueUUID}/estimate", Summary: "Record the agreed estimate of an issue and its decision note (admin only)", Request: EstimateRequest{}, Response: IssueResponse{}},
+	{ID: "listComments", Method: http.MethodGet, Path: "/api/v1/rooms/{roomUUID}/issues/{issueUUID}/comments", Summary: "List the comment threads of an issue (members and admins)", Query: []string{"userUUID"}, Response: CommentsResponse{}},
+	{ID: "createComment", Method: http.MethodPost, Path: "/api/v1/rooms/{roomUUID}/issues/{issueUUID}/comments", Summary: "Comment on an issue or reply to a comment", Request: CommentRequest{}, Response: CommentResponse{}, Status: http.StatusCreated},
+	{ID: "deleteComment", Method: http.MethodDelete, Path: "/api/v1/rooms/{roomUUID}/issues/{issueUUID}/comments/{commentUUID}", Summary: "Delete a comment and its replies (author or owner)", Query: []string{"userUUID"}, Status: http.StatusNoContent},
+	{ID: "bulkImportIssues", Method: http.MethodPost, Path: "/api/v1/rooms/{roomUUID}/issues/bulk", Summary: "Add issues from CSV or a Markdown/text list (admin only)", Request: BulkImportRequest{}, Response: BulkImportResponse{}, Status: http.StatusCreated},
+	{ID: "importIssues", Method: http.MethodPost, Path: "/api/v1/rooms/{roomUUID}/issues/import/{provider}", Summary: "Import issues from Jira, GitHub or GitLab (admin only)", Request: ImportIssuesRequest{}, Response: ImportIssuesResponse{}, Status: http.StatusCreated},
+	{ID: "startRound", Method: http.MethodPost, Path: "/api/v1/rooms/{roomUUID}/rounds", Summary: "Start a new round, optionally on another issue", Request: StartRoundRequest{}, Response: RoundState{}, Status: http.StatusCreated},
+	{ID: "getRound", Method: http.MethodGet, Path: "/api/v1/rooms/{roomUUID}/rounds/current", Summary: "Get the current round", Response: RoundState{}},
+	{ID: "updateRound", Method: http.MethodPatch, Path: "/api/v1/rooms/{roomUUID}/rounds/current", Summary: "Reveal or hide the cards", Request: UpdateRoundRequest{}, Response: RoundState{}},
+	{ID: "revote", Method: http.MethodPost, Path: "/api/v1/rooms/{roomUUID}/rounds/current/revote", Summary: "Vote again on the same issue, keeping the revealed results for comparison", Request: RevoteRequest{}, Response: RoundState{}, Status: http.StatusCreated},
+	{ID: "controlTimer", Method: http.MethodPost, Path: "/api/v1/rooms/{roomUUID}/rounds/current/timer", Summary: "Start, pause, resume, extend or cancel the round countdown (owner only)", Request: TimerRequest{}, Response: RoundState{}},
+	{ID: "castVote", Method: http.MethodPut, Path: "/api/v1/rooms/{roomUUID}/rounds/current/votes/{userUUID}", Summary: "Cast or change a vote", Request: VoteRequest{}, Status: http.StatusNoContent},
+	{ID: "withdrawVote", Method: http.MethodDelete, Path: "/api/v1/rooms/{roomUUID}/rounds/current/votes/{userUUID}", Summary: "Withdraw a vote", Status: http.StatusNoContent},
+	{ID: "listChat", Method: http.MethodGet, Path: "/api/v1/rooms/{roomUUID}/chat", Summary: "List chat messages, oldest first, optionally about one issue (members only)", Query: []string{"userUUID", "issueUUID", "before", "limit"}, Response: ChatHistoryResponse{}},
+	{ID: "postChat", Method: http.MethodPost, Path: "/api/v1/rooms/{roomUUID}/chat", Summary: "Post a chat message to the room", Request: ChatRequest{}, Response: ChatMessageResponse{}, Status: http.StatusCreated},
+	{ID: "deleteChat", Method: http.MethodDelete, Path: "/api/v1/rooms/{roomUUID}/chat/{messageUUID}", Summary: "Remove a chat message (owner only)", Query: []string{"userUUID"}, Status: http.StatusNoContent},
+	{ID: "listWebhooks", Method: http.MethodGet, Path: "/api/v1/rooms/{roomUUID}/webhooks", Summary: "List the room's webhooks (owner only)", Query: []string{"userUUID"}, Response: WebhooksResponse{}},
+	{ID: "createWebhook", Method: http.MethodPost, Path: "/api/v1/rooms/{roomUUID}/webhooks", Summary: "Register a webhook (owner only)", Request: CreateWebhookRequest{}, Response: WebhookResponse{}, Status: http.StatusCreated},
+	{ID: "deleteWebhook", Method: http.MethodDelete, Path: "/api/v1/rooms/{roomUUID}/webhooks/{webhookUUID}", Summary: "Deactivate a webhook (owner only)", Query: []string{"userUUID"}, Status: http.StatusNoContent},
+	{ID: "listWebhookDeliveries", Method: http.MethodGet, Path: "/api/v1/rooms/{roomUUID}/webhooks/{webhookUUID}/deliveries", Summary: "List recent delivery attempts (owner only)", Query: []string{"userUUID", "limit"}, Response: WebhookDeliveriesResponse{}},
+	{ID: "testWebhook", Method: http.MethodPost, Path: "/api/v1/rooms/{roomUUID}/webhooks/{webhookUUID}/test", Summary: "Send a ping event and report the outcome (owner only)", Request: TestWebhookRequest{}, Response: WebhookDeliveryResponse{}},
+}
+
+var (
+	openAPIOnce     sync.Once
+	openAPIDocument []byte
+	openAPIErr      error
+	pathParamRegexp = regexp.MustCompile(`\{([^}]+)\}`)
+)
+
+func serveOpenAPI() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		openAPIOnce.Do(func() {
+			openAPIDocument, openAPIErr = json.Marshal(buildOpenAPIDocument())
+		})
+		if handleError(w, openAPIErr) {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPIDocument)
+	}
+}
+
+func buildOpenAPIDocument() map[string]interface{} {
+	schemas := &schemaBuilder{components: make(map[string]interface{})}
+	errorSchema := schemas.schemaFor(reflect.TypeOf(ErrorResponse{}))
+
+	paths := make(map[string]map[string]interface{})
+	for _, op := range apiOperations {
+		var parameters []interface{}
+		for _, match := range pathParamRegexp.FindAllStringSubmatch(op.Path, -1) {
+			parameters = append(parameters, map[string]interface{}{
+				"name":     match[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, name := range op.Query {
+			parameters = append(parameters, map[string]interface{}{
+				"name":   name,
+				"in":     "query",
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]interface{}{"description": http.StatusText(status)}
+		if op.Response != nil {
+			success["content"] = jsonContent(schemas.schemaFor(reflect.TypeOf(op.Response)))
+		}
+
+		operation := map[string]interface{}{
+			"operationId": op.ID,
+			"summary":     op.Summary,
+			"tags":        []string{operationTag(op.Path)},
+			"responses": map[string]interface{}{
+				fmt.Sprint(status): success,
+				"default": map[string]interface{}{
+					"description": "Error",
+					"content":     jsonContent(errorSchema),
+				},
+			},
+		}
+		if parameters != nil {
+			operation["parameters"] = parameters
+		}
+		if op.Request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(schemas.schemaFor(reflect.TypeOf(op.Request))),
+			}
+		}
+
+		if paths[op.Path] == nil {
+			paths[op.Path] = make(map[string]interface{})
+		}
+		paths[op.Path][strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Planning Poker API",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas.components,
+		},
+	}
+}
+
+func operationTag(path string) string {
+	if strings.HasPrefix(path, "/api/v1/") {
+		return "v1"
+	}
+	return "legacy"
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+// schemaBuilder derives JSON schemas from Go types using their json tags.
+// Named structs are emitted once under components and referenced.
+type schemaBuilder struct {
+	components map[string]interface{}
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	durationType   = reflect.TypeOf(time.Duration(0))
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+func (b *schemaBuilder) schemaFor(t reflect.Type) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case durationType:
+		return map[string]interface{}{"type": "integer", "format": "int64", "description": "Duration in nanoseconds"}
+	case rawMessageType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := b.schemaFor(t.Elem())
+		if _, isRef := schema["$ref"]; isRef {
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		if _, exists := b.components[t.Name()]; !exists {
+			b.components[t.Name()] = map[string]interface{}{} // placeholder for recursive types
+			b.components[t.Name()] = b.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schemaFor(t.Elem())}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func (b *schemaBuilder) structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	b.collectProperties(t, properties)
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+func (b *schemaBuilder) collectProperties(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		// Embedded structs without a json name are flattened, like encoding/json does
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			b.collectProperties(field.Type, properties)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = b.schemaFor(field.Type)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// TestOpenAPICoverage compares the router with apiOperations, in both
+// directions and per method.
+func TestOpenAPICoverage(t *testing.T) {
+	r, apiRoutes := setupRouter(nil)
+
+	documented := make(map[string]map[string]bool)
+	for _, op := range apiOperations {
+		if documented[op.Path] == nil {
+			documented[op.Path] = make(map[string]bool)
+		}
+		if documented[op.Path][op.Method] {
+			t.Errorf("%s %s is documented twice", op.Method, op.Path)
+		}
+		documented[op.Path][op.Method] = true
+	}
+
+	registered := make(map[string]map[string]bool)
+	err := r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || route.GetHandler() == nil {
+			return nil
+		}
+		methods := make(map[string]bool)
+		if apiRouter, isAPI := apiRoutes[path]; isAPI {
+			for method := range apiRouter {
+				methods[method] = true
+			}
+		} else {
+			routeMethods, err := route.GetMethods()
+			if err != nil {
+				t.Errorf("route %s accepts any method", path)
+			}
+			for _, method := range routeMethods {
+				if method != http.MethodOptions {
+					methods[method] = true
+				}
+			}
+		}
+		registered[path] = methods
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, methods := range registered {
+		for method := range methods {
+			if !documented[path][method] {
+				t.Errorf("%s %s is registered but not documented", method, path)
+			}
+		}
+	}
+	for path, methods := range documented {
+		for method := range methods {
+			if !registered[path][method] {
+				t.Errorf("%s %s is documented but not registered", method, path)
+			}
+		}
+	}
+}
+
+func TestLegacyRoutesAnswerPreflight(t *testing.T) {
+	r, apiRoutes := setupRouter(nil)
+
+	var paths []string
+	for _, op := range apiOperations {
+		if _, isAPI := apiRoutes[op.Path]; !isAPI && op.Path != "/ws/{roomUUID}/{userUUID}" {
+			paths = append(paths, op.Path)
+		}
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodOptions, path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("OPTIONS %s: got status %d, want %d", path, w.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestServeOpenAPI(t *testing.T) {
+	r, _ := setupRouter(nil)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var document struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &document); err != nil {
+		t.Fatalf("document is not valid JSON: %v", err)
+	}
+	for _, op := range apiOperations {
+		if _, exists := document.Paths[op.Path][strings.ToLower(op.Method)]; !exists {
+			t.Errorf("document has no %s %s", op.Method, op.Path)
+		}
+	}
+}
@@ -2,52 +2,78 @@ package main
 
 import (
 	"database/sql"
-	"encoding/json"
 	"log"
 	"net/http"
 )
 
-func changeName(database *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var req struct {
-			UserUUID string `json:"userUUID"`
-			RoomUUID string `json:"roomUUID"`
-			Name     string `json:"name"`
-		}
-		json.NewDecoder(r.Body).Decode(&req)
+type ChangeNameRequest struct {
+	UserUUID string `json:"userUUID"`
+	RoomUUID string `json:"roomUUID"`
+	Name     string `json:"name"`
+}
 
-		UserID, err := getUserIDFromUUID(database, req.UserUUID)
-		if err != nil {
-			log.Printf("Error getting user ID: %v", err)
-			return
-		}
+func renamePlayer(database *sql.DB, roomUUID string, userUUID string, name string) error {
+	if name == "" {
+		return errMissingField("name")
+	}
 
-		statement, err := database.Prepare("UPDATE users SET name = $1 WHERE id = $2")
-		if err != nil {
-			log.Printf("Error preparing statement: %v", err)
-			return
-		}
+	UserID, err := findUserID(database, userUUID)
+	if err != nil {
+		return err
+	}
 
-		_, err = statement.Exec(req.Name, UserID)
-		if err != nil {
-			log.Printf("Error executing statement: %v", err)
-			return
-		}
-		log.Printf("User %d changed name to %s", UserID, req.Name)
-		game, exists := games[req.RoomUUID]
-		log.Printf("%v", games)
-		log.Printf("players of game %v", game.Players)
-
-		if exists {
-			for i := range game.Players {
-				if game.Players[i].ID == int(UserID) {
-					log.Printf("Changing name of player %d to %s", game.Players[i].ID, req.Name)
-					game.Players[i].Name = req.Name
-					break
-				}
+	statement, err := database.Prepare("UPDATE users SET name = $1 WHERE id = $2")
+	if err != nil {
+		return err
+	}
+
+	_, err = statement.Exec(name, UserID)
+	if err != nil {
+		return err
+	}
+	log.Printf("User %d changed name to %s", UserID, name)
+	game, exists := games[roomUUID]
+
+	if exists {
+		for i := range game.Players {
+			if game.Players[i].ID == int(UserID) {
+				log.Printf("Changing name of player %d to %s", game.Players[i].ID, name)
+				game.Players[i].Name = name
+				break
 			}
-			log.Printf("Sending game state to room %s", req.RoomUUID)
 		}
+		log.Printf("Sending game state to room %s", roomUUID)
 		sendGameState(game)
 	}
+	return nil
+}
+
+// authorOf returns the name and uuid to sign userID's chat messages and
+// comments with: the name other players see in the room, or the stored user
+// name when they are not playing.
+func authorOf(database *sql.DB, game *Game, userID int) (string, string, error) {
+	var name, userUUID string
+	err := database.QueryRow("SELECT COALESCE(name, ''), uuid FROM users WHERE id = $1", userID).Scan(&name, &userUUID)
+	if err == sql.ErrNoRows {
+		return "", "", errUserNotFound
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if player := findPlayer(game, userID); player != nil && player.Name != "" {
+		name = player.Name
+	}
+	return name, userUUID, nil
+}
+
+func changeName(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ChangeNameRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+
+		err := renamePlayer(database, req.RoomUUID, req.UserUUID, req.Name)
+		handleError(w, err)
+	}
 }
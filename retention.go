@@ -0,0 +1,379 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var errRoomArchived = errors.New("room is archived and read-only")
+
+// RetentionPolicy controls how long rooms and guest users are kept in the
+// database. A zero duration disables the corresponding step.
+type RetentionPolicy struct {
+	ArchiveAfter     time.Duration
+	GuestGracePeriod time.Duration
+	Interval         time.Duration
+	BatchSize        int
+}
+
+type RetentionReport struct {
+	ArchivedRooms int64         `json:"archivedRooms"`
+	PurgedGuests  int64         `json:"purgedGuests"`
+	Duration      time.Duration `json:"duration"`
+}
+
+type RoomDeletionReport struct {
+	Votes    int64 `json:"votes"`
+	Issues   int64 `json:"issues"`
+	Members  int64 `json:"members"`
+	Webhooks int64 `json:"webhooks"`
+	Rounds   int64 `json:"rounds"`
+}
+
+type DeleteRoomRequest struct {
+	RoomUUID string `json:"roomUUID"`
+	UserUUID string `json:"userUUID"`
+}
+
+type DeleteRoomResponse struct {
+	RoomUUID string             `json:"roomUUID"`
+	Deleted  RoomDeletionReport `json:"deleted"`
+}
+
+func loadRetentionPolicy() RetentionPolicy {
+	return RetentionPolicy{
+		ArchiveAfter:     time.Duration(envInt("ROOM_ARCHIVE_DAYS", 30)) * 24 * time.Hour,
+		GuestGracePeriod: time.Duration(envInt("GUEST_PURGE_DAYS", 7)) * 24 * time.Hour,
+		Interval:         time.Duration(envInt("RETENTION_INTERVAL_MINUTES", 60)) * time.Minute,
+		BatchSize:        envInt("RETENTION_BATCH_SIZE", 1000),
+	}
+}
+
+func runRetentionJob(database *sql.DB, policy RetentionPolicy, done <-chan struct{}) {
+	if policy.Interval <= 0 {
+		log.Println("Retention job disabled")
+		return
+	}
+
+	ticker := time.NewTicker(policy.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			log.Println("Retention job stopping...")
+			return
+		case <-ticker.C:
+			report, err := applyRetentionPolicy(database, policy)
+			if err != nil {
+				log.Printf("Error applying retention policy: %v", err)
+			}
+			log.Printf("Retention job archived %d rooms and purged %d guests in %s",
+				report.ArchivedRooms, report.PurgedGuests, report.Duration)
+		}
+	}
+}
+
+func applyRetentionPolicy(database *sql.DB, policy RetentionPolicy) (report RetentionReport, err error) {
+	start := time.Now()
+	defer func() {
+		report.Duration = time.Since(start)
+	}()
+
+	if policy.ArchiveAfter > 0 {
+		// Make sure buffered activity is persisted before judging rooms inactive
+		if err := roomActivity.flush(database); err != nil {
+			return report, err
+		}
+
+		archived, err := archiveInactiveRooms(database, time.Now().Add(-policy.ArchiveAfter), policy.BatchSize)
+		report.ArchivedRooms = archived
+		if err != nil {
+			return report, err
+		}
+	}
+
+	if policy.GuestGracePeriod > 0 {
+		purged, err := purgeOrphanGuests(database, time.Now().Add(-policy.GuestGracePeriod), policy.BatchSize)
+		report.PurgedGuests = purged
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+func archiveInactiveRooms(database *sql.DB, cutoff time.Time, batchSize int) (int64, error) {
+	query := `
+		UPDATE rooms SET archived_at = CURRENT_TIMESTAMP
+		WHERE id IN (
+			SELECT id FROM rooms
+			WHERE archived_at IS NULL AND lastActive < $1 AND NOT persistent
+			LIMIT $2
+		)
+		RETURNING uuid
+	`
+
+	var total int64
+	for {
+		rows, err := database.Query(query, cutoff, batchSize)
+		if err != nil {
+			return total, err
+		}
+
+		var archived []string
+		for rows.Next() {
+			var roomUUID string
+			if err := rows.Scan(&roomUUID); err != nil {
+				rows.Close()
+				return total, err
+			}
+			archived = append(archived, roomUUID)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return total, err
+		}
+
+		gamesMu.Lock()
+		for _, roomUUID := range archived {
+			if game, exists := games[roomUUID]; exists {
+				game.archived = true
+				stopRoundTimer(game)
+			}
+		}
+		gamesMu.Unlock()
+
+		total += int64(len(archived))
+		if len(archived) < batchSize {
+			return total, nil
+		}
+	}
+}
+
+func purgeOrphanGuests(database *sql.DB, cutoff time.Time, batchSize int) (int64, error) {
+	query := `
+		DELETE FROM users
+		WHERE id IN (
+			SELECT u.id FROM users u
+			WHERE u.guest = TRUE AND u.created_at < $1
+				AND NOT EXISTS (SELECT 1 FROM room_users ru WHERE ru.user_id = u.id)
+				AND NOT EXISTS (SELECT 1 FROM rooms r WHERE r.admin = u.id)
+				AND NOT EXISTS (SELECT 1 FROM team_members tm WHERE tm.user_id = u.id)
+				AND NOT EXISTS (SELECT 1 FROM votes v WHERE v.user_id = u.id)
+				AND NOT EXISTS (SELECT 1 FROM player_scores ps WHERE ps.user_id = u.id)
+				AND NOT EXISTS (SELECT 1 FROM room_templates rt WHERE rt.owner_id = u.id)
+			LIMIT $2
+		)
+	`
+
+	var total int64
+	for {
+		result, err := database.Exec(query, cutoff, batchSize)
+		if err != nil {
+			return total, err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += affected
+		if affected < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
+func isRoomArchived(database *sql.DB, roomID int) (bool, error) {
+	var archived bool
+	err := database.QueryRow("SELECT archived_at IS NOT NULL FROM rooms WHERE id = $1", roomID).Scan(&archived)
+	return archived, err
+}
+
+// ensureRoomWritable returns errRoomArchived for rooms moved to read-only history.
+func ensureRoomWritable(database *sql.DB, roomID int) error {
+	archived, err := isRoomArchived(database, roomID)
+	if err != nil {
+		return err
+	}
+	if archived {
+		return errRoomArchived
+	}
+	return nil
+}
+
+func deleteRoomFromDB(database *sql.DB, roomID int) (RoomDeletionReport, error) {
+	var report RoomDeletionReport
+
+	tx, err := database.Begin()
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+
+	steps := []struct {
+		query string
+		count *int64
+	}{
+		{"DELETE FROM votes WHERE room_id = $1", &report.Votes},
+		{"DELETE FROM player_scores WHERE room_id = $1", new(int64)},
+		{"DELETE FROM chat_messages WHERE room_id = $1", new(int64)},
+		{"DELETE FROM round_votes WHERE round_id IN (SELECT id FROM rounds WHERE room_id = $1)", new(int64)},
+		{"DELETE FROM rounds WHERE room_id = $1", &report.Rounds},
+		{"DELETE FROM room_sessions WHERE room_id = $1", new(int64)},
+		{"UPDATE rooms SET current_issue_id = NULL WHERE id = $1", new(int64)},
+		{"DELETE FROM issues WHERE room_id = $1", &report.Issues},
+		{"DELETE FROM room_users WHERE room_id = $1", &report.Members},
+		{"DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE room_id = $1)", new(int64)},
+		{"DELETE FROM webhooks WHERE room_id = $1", &report.Webhooks},
+	}
+	for _, step := range steps {
+		result, err := tx.Exec(step.query, roomID)
+		if err != nil {
+			return report, err
+		}
+		if *step.count, err = result.RowsAffected(); err != nil {
+			return report, err
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM rooms WHERE id = $1", roomID); err != nil {
+		return report, err
+	}
+
+	return report, tx.Commit()
+}
+
+func closeRoom(roomUUID string) {
+	gamesMu.Lock()
+	game, exists := games[roomUUID]
+	delete(games, roomUUID)
+	gamesMu.Unlock()
+
+	if !exists {
+		return
+	}
+	stopRoundTimer(game)
+	for _, player := range game.Players {
+		for _, conn := range player.connections {
+			if conn != nil {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "room deleted"))
+			}
+		}
+	}
+}
+
+// requireRoomAdmin returns the user ID of userUUID if it owns the room or
+// is an admin of the team the room belongs to.
+func requireRoomAdmin(database *sql.DB, roomID int, userUUID string) (int, error) {
+	userID, err := findUserID(database, userUUID)
+	if err != nil {
+		return 0, err
+	}
+
+	var allowed bool
+	err = database.QueryRow(`SELECT r.admin = $2 OR EXISTS (
+			SELECT 1 FROM team_members tm WHERE tm.team_id = r.team_id AND tm.user_id = $2 AND tm.role = 'admin')
+		FROM rooms r WHERE r.id = $1`, roomID, userID).Scan(&allowed)
+	if err != nil {
+		return 0, err
+	}
+	if !allowed {
+		return 0, errForbidden
+	}
+	return userID, nil
+}
+
+// requireRoomOwner is requireRoomAdmin without the team admins, for the
+// actions only the owner may take.
+func requireRoomOwner(database *sql.DB, roomID int, userUUID string) (int, error) {
+	userID, err := findUserID(database, userUUID)
+	if err != nil {
+		return 0, err
+	}
+
+	var adminID int
+	err = database.QueryRow("SELECT admin FROM rooms WHERE id = $1", roomID).Scan(&adminID)
+	if err != nil {
+		return 0, err
+	}
+	if adminID != userID {
+		return 0, errForbidden
+	}
+	return userID, nil
+}
+
+// requireRoomMember returns the id of userUUID if they joined the room.
+func requireRoomMember(database *sql.DB, roomID int, userUUID string) (int, error) {
+	userID, err := findUserID(database, userUUID)
+	if err != nil {
+		return 0, err
+	}
+
+	var member bool
+	err = database.QueryRow("SELECT EXISTS (SELECT 1 FROM room_users WHERE room_id = $1 AND user_id = $2)", roomID, userID).Scan(&member)
+	if err != nil {
+		return 0, err
+	}
+	if !member {
+		return 0, errPlayerNotInRoom
+	}
+	return userID, nil
+}
+
+// requireRoomAccess returns the id of userUUID if they joined the room or
+// administer it, since team admins may manage a room they never joined.
+func requireRoomAccess(database *sql.DB, roomID int, userUUID string) (int, error) {
+	userID, err := requireRoomMember(database, roomID, userUUID)
+	if errors.Is(err, errPlayerNotInRoom) {
+		return requireRoomAdmin(database, roomID, userUUID)
+	}
+	return userID, err
+}
+
+// deleteRoomAs hard-deletes the room if userUUID is its owner.
+func deleteRoomAs(database *sql.DB, roomUUID string, userUUID string) (RoomDeletionReport, error) {
+	roomID, err := findRoomID(database, roomUUID)
+	if err != nil {
+		return RoomDeletionReport{}, err
+	}
+
+	userID, err := requireRoomOwner(database, roomID, userUUID)
+	if err != nil {
+		return RoomDeletionReport{}, err
+	}
+
+	report, err := deleteRoomFromDB(database, roomID)
+	if err != nil {
+		return report, err
+	}
+	closeRoom(roomUUID)
+	log.Printf("Room %s deleted by owner %d: %d votes, %d issues, %d members removed",
+		roomUUID, userID, report.Votes, report.Issues, report.Members)
+	return report, nil
+}
+
+func deleteRoom(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req DeleteRoomRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+
+		report, err := deleteRoomAs(database, req.RoomUUID, req.UserUUID)
+		if handleError(w, err) {
+			return
+		}
+
+		sendResponse(w, DeleteRoomResponse{
+			RoomUUID: req.RoomUUID,
+			Deleted:  report,
+		})
+	}
+}
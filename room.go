@@ -3,9 +3,10 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -15,238 +16,523 @@ type RoomRequest struct {
 	RoomName      string       `json:"roomName"`
 	AutoShowCards bool         `json:"autoShowCards"`
 	Deck          []CardOption `json:"deck"`
+	Anonymous     bool         `json:"anonymous"`
+	// Only used by anonymous rooms; defaults to true
+	KeepVoteAttribution *bool `json:"keepVoteAttribution"`
+	// Applied over the fields above
+	Settings *RoomSettingsPatch `json:"settings"`
+	// A template of the user to start from
+	TemplateUUID string `json:"templateUUID"`
+	// Persistent rooms are never archived, for teams that reuse one URL
+	Persistent bool `json:"persistent"`
+	// A team of the user whose admins facilitate the room too
+	TeamUUID string `json:"teamUUID"`
 }
 
 type JoinRoomRequest struct {
 	UserUUID string `json:"UserUUID"`
 	RoomUUID string `json:"RoomUUID"`
+	Observer *bool  `json:"observer"`
 }
 
-func createRoom(database *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var req RoomRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); handleError(w, err) {
-			return
-		}
+type CreateRoomResponse struct {
+	RoomUUID      string       `json:"roomUUID"`
+	UserUUID      string       `json:"userUUID"`
+	RoomName      string       `json:"roomName"`
+	AutoShowCards bool         `json:"autoShowCards"`
+	Deck          []CardOption `json:"deck"`
+	Settings      RoomSettings `json:"settings"`
+	Persistent    bool         `json:"persistent"`
+	TeamUUID      string       `json:"teamUUID,omitempty"`
+}
 
-		roomUUID, userUUID, roomName, autoShowCards, deck, err := createRoomInDB(database, req.UserUUID, req.RoomName, req.AutoShowCards, req.Deck)
-		if handleError(w, err) {
-			return
-		}
+type JoinRoomResponse struct {
+	RoomUUID string       `json:"roomUUID"`
+	UserUUID string       `json:"userUUID"`
+	Deck     []CardOption `json:"deck"`
+	Issues   []Issue      `json:"issues"`
+}
+
+type LeaveRoomRequest struct {
+	RoomUUID string `json:"roomUUID"`
+	UserUUID string `json:"userUUID"`
+}
 
-		userID, _ := getUserIDFromUUID(database, userUUID)
-		roomID, _ := getRoomIDFromUUID(database, roomUUID)
+// KickPlayerRequest removes UserUUID from the room. AdminUUID is the room
+// admin doing it.
+type KickPlayerRequest struct {
+	RoomUUID  string `json:"roomUUID"`
+	UserUUID  string `json:"userUUID"`
+	AdminUUID string `json:"adminUUID"`
+}
+
+type ResetVotesRequest struct {
+	RoomUUID string `json:"roomUUID"`
+	// Only required when the room restricts who may reset
+	UserUUID string `json:"userUUID"`
+}
+
+type ShowCardsRequest struct {
+	RoomUUID string `json:"roomUUID"`
+	// Only required when the room restricts who may reveal
+	UserUUID string `json:"userUUID"`
+}
+
+type AutoShowCardsRequest struct {
+	RoomUUID string `json:"roomUUID"`
+	// Must be the room admin or a team admin
+	UserUUID      string `json:"userUUID"`
+	AutoShowCards bool   `json:"autoShowCards"`
+}
+
+type ChangeRoomNameRequest struct {
+	RoomUUID string `json:"roomUUID"`
+	RoomName string `json:"roomName"`
+	UserUUID string `json:"userUUID"`
+}
+
+type RoomListResponse struct {
+	Rooms []RoomSummary `json:"rooms"`
+}
+
+type RoomSummary struct {
+	RoomUUID   string    `json:"roomUUID"`
+	Name       string    `json:"name"`
+	LastActive time.Time `json:"lastActive"`
+	Archived   bool      `json:"archived"`
+	Persistent bool      `json:"persistent"`
+	Members    int       `json:"members"`
+}
+
+// The functions below implement the room operations shared by the legacy
+// routes and the /api/v1 resources. They validate their input and return
+// *APIError values for anything the client got wrong.
 
-		deckText := ""
-		for _, card := range deck {
-			deckText += fmt.Sprintf("%s, ", card.Value)
+func openRoom(database *sql.DB, req RoomRequest) (*CreateRoomResponse, error) {
+	settings := defaultRoomSettings()
+	settings.AutoReveal = req.AutoShowCards
+	settings.Anonymous = req.Anonymous
+	if req.KeepVoteAttribution != nil {
+		settings.KeepVoteAttribution = *req.KeepVoteAttribution
+	}
+	var templateIssues []TemplateIssue
+	var issues []Issue
+	if req.TemplateUUID != "" {
+		var err error
+		req, settings, templateIssues, err = applyTemplate(database, req)
+		if err != nil {
+			return nil, err
 		}
-		games[roomUUID] = &Game{
-			Players:       []*Player{},
-			admin:         int(userID),
-			roomID:        roomID,
-			name:          roomName,
-			autoShowCards: autoShowCards,
-			deck:          deck,
+		for _, issue := range templateIssues {
+			issues = append(issues, Issue{Title: issue.Title, Description: issue.Description, Link: issue.Link})
 		}
-		sendGameState(games[roomUUID])
+	}
+	if req.Settings != nil {
+		settings = req.Settings.apply(settings)
+	}
+	if err := settings.validate(); err != nil {
+		return nil, err
+	}
 
-		sendResponse(w, map[string]interface{}{
-			"roomUUID":      roomUUID,
-			"userUUID":      userUUID,
-			"roomName":      roomName,
-			"autoShowCards": autoShowCards,
-			"deck":          deck,
-		})
+	var team Team
+	if req.TeamUUID != "" {
+		var err error
+		if team, err = findMemberTeam(database, req.TeamUUID, req.UserUUID); err != nil {
+			return nil, err
+		}
+	}
+
+	roomUUID, userUUID, roomName, deck, issues, err := createRoomInDB(database, req.UserUUID, req.RoomName, settings, req.Deck, req.Persistent, team.ID, issues)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, _ := getUserIDFromUUID(database, userUUID)
+	roomID, _ := getRoomIDFromUUID(database, roomUUID)
+
+	game := &Game{
+		Players:    []*Player{},
+		admin:      int(userID),
+		roomID:     roomID,
+		roomUUID:   roomUUID,
+		lastActive: time.Now(),
+		name:       roomName,
+		deck:       deck,
+		settings:   settings,
+		persistent: req.Persistent,
+		teamID:     team.ID,
+		teamUUID:   team.UUID,
+		issues:     issues,
+	}
+	if err := refreshTeamAdmins(database, game); err != nil {
+		return nil, err
+	}
+	gamesMu.Lock()
+	games[roomUUID] = game
+	gamesMu.Unlock()
+
+	for _, issue := range issues {
+		emitRoomEvent(game, eventIssueAdded, IssueResponse{Issue: issue})
 	}
-}
 
-func sendPlayerLeftMessage(game *Game, userID int) {
-	handleLeaveRoom(game, userID)
 	sendGameState(game)
+
+	return &CreateRoomResponse{
+		RoomUUID:      roomUUID,
+		UserUUID:      userUUID,
+		RoomName:      roomName,
+		AutoShowCards: settings.AutoReveal,
+		Deck:          deck,
+		Settings:      settings,
+		Persistent:    req.Persistent,
+		TeamUUID:      req.TeamUUID,
+	}, nil
 }
 
-func leaveRoom(database *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var params map[string]interface{}
-		err := json.NewDecoder(r.Body).Decode(&params)
-		if err != nil {
-			http.Error(w, "Error decoding request body", http.StatusBadRequest)
-			return
-		}
+// addPlayerToRoom joins the room as a player, or as an observer who only
+// watches. A nil observer keeps the role of a returning member.
+func addPlayerToRoom(database *sql.DB, roomUUID string, userUUID string, observer *bool) (*JoinRoomResponse, error) {
+	roomUUID, userUUID, err := addUserToRoom(database, roomUUID, userUUID, observer)
+	if err != nil {
+		return nil, err
+	}
 
-		log.Printf("Params: %v", params)
-		roomUUID, roomExists := params["roomUUID"].(string)
-		userUUID, userExists := params["userUUID"].(string)
+	game, err := loadGame(database, roomUUID)
+	if err != nil {
+		return nil, err
+	}
+	if err := refreshObservers(database, game); err != nil {
+		return nil, err
+	}
+	if err := refreshTeamAdmins(database, game); err != nil {
+		return nil, err
+	}
+	touchRoom(game)
+	sendGameState(game)
+
+	issues := game.issues
+	if issues == nil {
+		issues = []Issue{}
+	}
+	return &JoinRoomResponse{
+		RoomUUID: roomUUID,
+		UserUUID: userUUID,
+		Deck:     game.deck,
+		Issues:   issues,
+	}, nil
+}
 
-		roomID, _ := getRoomIDFromUUID(database, roomUUID)
-		userID, _ := getUserIDFromUUID(database, userUUID)
+// removePlayerFromRoom deletes the membership. When the player leaves on
+// their own and was the admin, another member becomes the admin.
+func removePlayerFromRoom(database *sql.DB, roomUUID string, userUUID string, reassignAdmin bool) error {
+	roomID, err := findRoomID(database, roomUUID)
+	if err != nil {
+		return err
+	}
 
-		if !roomExists || !userExists {
-			http.Error(w, "Room ID or User ID not provided", http.StatusBadRequest)
-			return
-		}
+	userID, err := findUserID(database, userUUID)
+	if err != nil {
+		return err
+	}
 
+	if reassignAdmin {
 		// Check if the user is the admin
 		var adminID int
 		err = database.QueryRow("SELECT admin FROM rooms WHERE id = $1", int(roomID)).Scan(&adminID)
 		if err != nil {
-			http.Error(w, "Failed to retrieve admin from database", http.StatusInternalServerError)
-			return
+			return err
 		}
 
 		if adminID == int(userID) {
 			// If the user is the admin, set another player as the admin
 			_, err = database.Exec("UPDATE rooms SET admin = (SELECT user_id FROM room_users WHERE room_id = $1 LIMIT 1) WHERE id = $2", int(roomID), int(roomID))
 			if err != nil {
-				http.Error(w, "Failed to update admin in database", http.StatusInternalServerError)
-				return
+				return err
 			}
 		}
+	} else if err := ensureRoomWritable(database, roomID); err != nil {
+		return err
+	}
+
+	// Delete the record from the database
+	result, err := database.Exec("DELETE FROM room_users WHERE room_id = $1 AND user_id = $2", int(roomID), int(userID))
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return errPlayerNotInRoom
+	}
+
+	game, gameExists := games[roomUUID]
+	if gameExists {
+		sendPlayerLeftMessage(game, int(userID))
+	}
+	return nil
+}
 
-		// Delete the record from the database
-		_, err = database.Exec("DELETE FROM room_users WHERE room_id = $1 AND user_id = $2", int(roomID), int(userID))
+// kickFromRoom removes userUUID from the room if adminUUID administers it.
+func kickFromRoom(database *sql.DB, roomUUID string, adminUUID string, userUUID string) error {
+	roomID, err := findRoomID(database, roomUUID)
+	if err != nil {
+		return err
+	}
+	if _, err := requireRoomAdmin(database, roomID, adminUUID); err != nil {
+		return err
+	}
+	return removePlayerFromRoom(database, roomUUID, userUUID, false)
+}
+
+func resetRoomVotes(database *sql.DB, roomUUID string) error {
+	return startNewRound(database, roomUUID, nil)
+}
+
+// startNewRound clears the votes. previous are the results shown next to the
+// new round, for a re-vote; any other new round starts without them.
+func startNewRound(database *sql.DB, roomUUID string, previous []RoundResult) error {
+	roomID, err := findRoomID(database, roomUUID)
+	if err != nil {
+		return err
+	}
+	if err := ensureRoomWritable(database, roomID); err != nil {
+		return err
+	}
+
+	_, err = database.Exec("DELETE FROM votes WHERE room_id = $1", roomID)
+	if err != nil {
+		return err
+	}
+
+	startedAt := time.Now()
+	_, err = database.Exec("UPDATE rooms SET showCards = false, round_started_at = $1 WHERE id = $2", startedAt, roomID)
+	if err != nil {
+		return err
+	}
+
+	game, exists := games[roomUUID]
+	if exists {
+		game.showCards = false
+		game.roundStartedAt = startedAt
+		game.roundID = 0
+		game.previousRounds = previous
+		stopRoundTimer(game)
+		for _, player := range game.Players {
+			player.Voted = false
+			player.Vote = nil // Set player.Vote to nil instead of 0
+		}
+		sendGameState(game)
+	}
+	return nil
+}
+
+func setRoomAutoShowCards(database *sql.DB, roomUUID string, enabled bool) error {
+	_, err := updateRoomSettings(database, roomUUID, RoomSettingsPatch{AutoReveal: &enabled})
+	return err
+}
+
+// setRoomShowCards reveals or hides the cards. A nil show toggles the
+// current state, which is what the legacy /showCards route does.
+func setRoomShowCards(database *sql.DB, roomUUID string, show *bool) (bool, error) {
+	roomID, err := findRoomID(database, roomUUID)
+	if err != nil {
+		return false, err
+	}
+	if err := ensureRoomWritable(database, roomID); err != nil {
+		return false, err
+	}
+
+	var newShowState bool
+	if show != nil {
+		newShowState = *show
+	} else {
+		var currentShowState bool
+		err = database.QueryRow("SELECT showCards FROM rooms WHERE id = $1", roomID).Scan(&currentShowState)
 		if err != nil {
-			http.Error(w, "Failed to delete record from database", http.StatusInternalServerError)
-			return
+			return false, err
 		}
+		newShowState = !currentShowState
+	}
 
-		game, gameExists := games[roomUUID]
-		if gameExists {
-			sendPlayerLeftMessage(game, int(userID))
+	_, err = database.Exec("UPDATE rooms SET showCards = $1 WHERE id = $2", newShowState, roomID)
+	if err != nil {
+		return false, err
+	}
+
+	game, exists := games[roomUUID]
+	if exists {
+		revealed := newShowState && !game.showCards
+		game.showCards = newShowState
+		if revealed {
+			revealRound(game)
 		}
+		sendGameState(game)
 	}
+	return newShowState, nil
 }
 
-func joinRoom(database *sql.DB) http.HandlerFunc {
+func renameRoom(database *sql.DB, roomUUID string, name string) error {
+	if name == "" {
+		return errMissingField("roomName")
+	}
+
+	roomID, err := findRoomID(database, roomUUID)
+	if err != nil {
+		return err
+	}
+	if err := ensureRoomWritable(database, roomID); err != nil {
+		return err
+	}
+
+	_, err = database.Exec("UPDATE rooms SET name = $1 WHERE id = $2", name, roomID)
+	if err != nil {
+		return err
+	}
+
+	game, exists := games[roomUUID]
+	if exists {
+		game.name = name
+		sendGameState(game)
+	}
+	return nil
+}
+
+func createRoom(database *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var req JoinRoomRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); handleError(w, err) {
+		var req RoomRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+
+		response, err := openRoom(database, req)
+		if handleError(w, err) {
 			return
 		}
 
-		roomUUID, userUUID, err := addUserToRoom(database, req.RoomUUID, req.UserUUID)
+		sendResponse(w, response)
+	}
+}
+
+func listRooms(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		days := 7
+		if value := r.URL.Query().Get("activeWithinDays"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				handleError(w, errInvalidField("activeWithinDays", "activeWithinDays must be a positive integer"))
+				return
+			}
+			days = parsed
+		}
 
+		userID, err := findUserID(database, r.URL.Query().Get("userUUID"))
 		if handleError(w, err) {
 			return
 		}
-		sendGameState(games[roomUUID])
 
-		sendResponse(w, map[string]interface{}{
-			"roomUUID": roomUUID,
-			"userUUID": userUUID,
-			"deck":     games[roomUUID].deck,
+		rooms, err := fetchRoomsForUser(database, userID, time.Now().AddDate(0, 0, -days))
+		if handleError(w, err) {
+			return
+		}
+
+		sendResponse(w, RoomListResponse{
+			Rooms: rooms,
 		})
 	}
 }
 
-func resetVotes(database *sql.DB) http.HandlerFunc {
+func sendPlayerLeftMessage(game *Game, userID int) {
+	handleLeaveRoom(game, userID)
+	sendGameState(game)
+}
+
+func leaveRoom(database *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var req struct {
-			RoomUUID string `json:"roomUUID"`
+		var req LeaveRoomRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
 		}
 
-		if err := json.NewDecoder(r.Body).Decode(&req); handleError(w, err) {
+		err := removePlayerFromRoom(database, req.RoomUUID, req.UserUUID, true)
+		handleError(w, err)
+	}
+}
+
+func joinRoom(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req JoinRoomRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
 			return
 		}
 
-		roomID, err := getRoomIDFromUUID(database, req.RoomUUID)
+		response, err := addPlayerToRoom(database, req.RoomUUID, req.UserUUID, req.Observer)
 		if handleError(w, err) {
 			return
 		}
 
-		_, err = database.Exec("DELETE FROM votes WHERE room_id = $1", roomID)
-		if handleError(w, err) {
+		sendResponse(w, response)
+	}
+}
+
+func resetVotes(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ResetVotesRequest
+
+		if err := decodeJSON(r, &req); handleError(w, err) {
 			return
 		}
 
-		_, err = database.Exec("UPDATE rooms SET showCards = false WHERE id = $1", roomID)
+		game, err := loadGame(database, req.RoomUUID)
 		if handleError(w, err) {
 			return
 		}
-
-		game, exists := games[req.RoomUUID]
-		if exists {
-			game.showCards = false
-			for _, player := range game.Players {
-				player.Voted = false
-				player.Vote = nil // Set player.Vote to nil instead of 0
-			}
-			sendGameState(game)
+		if err := checkRoomPolicy(database, game, game.settings.ResetPolicy, req.UserUUID); handleError(w, err) {
+			return
 		}
+
+		err = resetRoomVotes(database, req.RoomUUID)
+		handleError(w, err)
 	}
 }
 
 func autoShowCards(database *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		var req struct {
-			RoomUUID      string `json:"roomUUID"`
-			AutoShowCards bool   `json:"autoShowCards"`
-		}
+		var req AutoShowCardsRequest
 
-		if err := json.NewDecoder(r.Body).Decode(&req); handleError(w, err) {
-			log.Println("Error decoding request body")
+		if err := decodeJSON(r, &req); handleError(w, err) {
 			return
 		}
 
-		RoomID, _ := getRoomIDFromUUID(database, req.RoomUUID)
-
-		_, err := database.Exec("UPDATE rooms SET autoShowCards = $1 WHERE id = $2", req.AutoShowCards, RoomID)
+		roomID, err := findRoomID(database, req.RoomUUID)
 		if handleError(w, err) {
 			return
 		}
-
-		game, exists := games[req.RoomUUID]
-		if exists {
-			game.autoShowCards = req.AutoShowCards
-			if !req.AutoShowCards {
-				game.showCards = false
-				_, err = database.Exec("UPDATE rooms SET showCards = $1 WHERE id = $2", false, RoomID)
-				if handleError(w, err) {
-					return
-				}
-			}
-			sendGameState(game)
+		if _, err := requireRoomAdmin(database, roomID, req.UserUUID); handleError(w, err) {
+			return
 		}
+
+		err = setRoomAutoShowCards(database, req.RoomUUID, req.AutoShowCards)
+		handleError(w, err)
 	}
 }
 
 func showCards(database *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var req struct {
-			RoomUUID string `json:"roomUUID"`
-		}
+		var req ShowCardsRequest
 
-		if err := json.NewDecoder(r.Body).Decode(&req); handleError(w, err) {
+		if err := decodeJSON(r, &req); handleError(w, err) {
 			return
 		}
 
-		RoomID, err := getRoomIDFromUUID(database, req.RoomUUID)
-		log.Printf("Room ID: %d", RoomID)
-		log.Printf("Room UUID: %s", req.RoomUUID)
-		if err != nil {
-			http.Error(w, "Failed to get room ID from UUID", http.StatusInternalServerError)
-			return
-		}
-
-		var currentShowState bool
-		err = database.QueryRow("SELECT showCards FROM rooms WHERE id = $1", RoomID).Scan(&currentShowState)
+		game, err := loadGame(database, req.RoomUUID)
 		if handleError(w, err) {
 			return
 		}
-
-		newShowState := !currentShowState
-		_, err = database.Exec("UPDATE rooms SET showCards = $1 WHERE id = $2", newShowState, RoomID)
-		if handleError(w, err) {
+		if err := checkRoomPolicy(database, game, game.settings.RevealPolicy, req.UserUUID); handleError(w, err) {
 			return
 		}
 
-		game, exists := games[req.RoomUUID]
-		if exists {
-			game.showCards = newShowState
-			sendGameState(game)
-		}
+		_, err = setRoomShowCards(database, req.RoomUUID, nil)
+		handleError(w, err)
 	}
 }
 
@@ -258,7 +544,7 @@ func vote(database *sql.DB) http.HandlerFunc {
 			Vote   string `json:"vote"`
 		}
 
-		if err := json.NewDecoder(r.Body).Decode(&req); handleError(w, err) {
+		if err := decodeJSON(r, &req); handleError(w, err) {
 			return
 		}
 
@@ -274,78 +560,51 @@ func generateUuid() string {
 
 func changeRoomName(database *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var req struct {
-			RoomUUID string `json:"roomUUID"`
-			RoomName string `json:"roomName"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&req); handleError(w, err) {
+		var req ChangeRoomNameRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
 			return
 		}
 
-		roomID, err := getRoomIDFromUUID(database, req.RoomUUID)
+		roomID, err := findRoomID(database, req.RoomUUID)
 		if handleError(w, err) {
 			return
 		}
-
-		_, err = database.Exec("UPDATE rooms SET name = $1 WHERE id = $2", req.RoomName, roomID)
-		if handleError(w, err) {
+		if _, err := requireRoomAdmin(database, roomID, req.UserUUID); handleError(w, err) {
 			return
 		}
 
-		game, exists := games[req.RoomUUID]
-		if exists {
-			game.name = req.RoomName
-			sendGameState(game)
-		}
+		err = renameRoom(database, req.RoomUUID, req.RoomName)
+		handleError(w, err)
 	}
 }
 
 func kickPlayer(database *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var req struct {
-			RoomUUID string `json:"roomUUID"`
-			UserUUID string `json:"userUUID"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&req); handleError(w, err) {
-			return
-		}
-
-		roomID, err := getRoomIDFromUUID(database, req.RoomUUID)
-		if handleError(w, err) {
-			return
-		}
-
-		userID, err := getUserIDFromUUID(database, req.UserUUID)
-		if handleError(w, err) {
+		var req KickPlayerRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
 			return
 		}
 
-		_, err = database.Exec("DELETE FROM room_users WHERE room_id = $1 AND user_id = $2", roomID, userID)
-		if handleError(w, err) {
-			return
-		}
-
-		game, exists := games[req.RoomUUID]
-		if exists {
-			sendPlayerLeftMessage(game, int(userID))
-		}
+		err := kickFromRoom(database, req.RoomUUID, req.AdminUUID, req.UserUUID)
+		handleError(w, err)
 	}
 }
 
-func createRoomInDB(database *sql.DB, userUUID string, roomName string, autoShowCards bool, deck []CardOption) (string, string, string, bool, []CardOption, error) {
+func createRoomInDB(database *sql.DB, userUUID string, roomName string, settings RoomSettings, deck []CardOption, persistent bool, teamID int, issues []Issue) (string, string, string, []CardOption, []Issue, error) {
 	tx, err := database.Begin()
 	if err != nil {
 		log.Printf("Error starting transaction: %v", err)
-		return "", "", "", false, nil, err
+		return "", "", "", nil, nil, err
 	}
+	defer tx.Rollback()
 
 	roomUUID, err := generateRoomUUID()
 	if err != nil {
 		log.Printf("Error generating room UUID: %v", err)
-		return "", "", "", false, nil, err
+		return "", "", "", nil, nil, err
 	}
 
-	if userUUID == "" {
+	if userUUID == "" || !isValidUUID(userUUID) {
 		userUUID = generateUuid()
 	}
 	userID, err := getUserIDFromUUID(database, userUUID)
@@ -358,46 +617,72 @@ func createRoomInDB(database *sql.DB, userUUID string, roomName string, autoShow
 			err = row.Scan(&userID)
 			if err != nil {
 				log.Printf("Error inserting user: %v", err)
-				return "", "", "", false, nil, err
+				return "", "", "", nil, nil, err
 			}
 		} else {
 			log.Printf("Error getting user ID from UUID: %v", err)
-			return "", "", "", false, nil, err
+			return "", "", "", nil, nil, err
 		}
 	}
 
 	var roomID int64
-	statement := `INSERT INTO rooms (uuid, admin, name, autoshowcards, deck) VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	statement := `INSERT INTO rooms (uuid, admin, name, settings, deck, persistent, team_id) VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, 0)) RETURNING id`
 	deckJSON, err := json.Marshal(deck)
 	if err != nil {
 		log.Printf("Error marshalling deck: %v", err)
-		return "", "", "", false, nil, err
+		return "", "", "", nil, nil, err
+	}
+	settingsJSON, err := json.Marshal(settings)
+	if err != nil {
+		log.Printf("Error marshalling settings: %v", err)
+		return "", "", "", nil, nil, err
 	}
-	err = tx.QueryRow(statement, roomUUID, userID, roomName, autoShowCards, deckJSON).Scan(&roomID)
+	err = tx.QueryRow(statement, roomUUID, userID, roomName, settingsJSON, deckJSON, persistent, teamID).Scan(&roomID)
 	if err != nil {
 		log.Printf("Error inserting room: %v", err)
-		return "", "", "", false, nil, err
+		return "", "", "", nil, nil, err
 	}
 
 	statement = "INSERT INTO room_users (room_id, user_id) VALUES ($1, $2)"
 	_, err = tx.Exec(statement, roomID, userID)
 	if err != nil {
 		log.Printf("Error inserting room_user: %v", err)
-		return "", "", "", false, nil, err
+		return "", "", "", nil, nil, err
+	}
+
+	// Issues of the template are part of the room: creating it fails as a
+	// whole rather than leaving a room with half a backlog
+	created, err := insertIssues(tx, int(roomID), 0, issues)
+	if err != nil {
+		log.Printf("Error inserting issues: %v", err)
+		return "", "", "", nil, nil, err
 	}
 
 	err = tx.Commit()
 	if err != nil {
 		log.Printf("Error committing transaction: %v", err)
-		return "", "", "", false, nil, err
+		return "", "", "", nil, nil, err
 	}
-	return roomUUID, userUUID, roomName, autoShowCards, deck, nil
+	return roomUUID, userUUID, roomName, deck, created, nil
 }
 
-func addUserToRoom(database *sql.DB, roomUUID string, userUUID string) (string, string, error) {
+func addUserToRoom(database *sql.DB, roomUUID string, userUUID string, observer *bool) (string, string, error) {
 	var userID int
 	var err error
 
+	roomID, err := findRoomID(database, roomUUID)
+	if err != nil {
+		return "", "", err
+	}
+	if err := ensureRoomWritable(database, roomID); err != nil {
+		return "", "", err
+	}
+
+	// Malformed UUIDs are treated like unknown users
+	if !isValidUUID(userUUID) {
+		userUUID = ""
+	}
+
 	// Try to get the user ID from the provided UUID.
 	if userUUID != "" {
 		userID, err = getUserIDFromUUID(database, userUUID)
@@ -417,27 +702,36 @@ func addUserToRoom(database *sql.DB, roomUUID string, userUUID string) (string,
 		}
 	}
 
-	roomID, err := getRoomIDFromUUID(database, roomUUID)
-	if err != nil {
+	// Check if user is already in the room
+	var wasObserver bool
+	err = database.QueryRow("SELECT observer FROM room_users WHERE room_id = $1 AND user_id = $2 LIMIT 1", roomID, userID).Scan(&wasObserver)
+	if err != nil && err != sql.ErrNoRows {
 		return "", "", err
 	}
+	member := err == nil
+	if member && (observer == nil || *observer == wasObserver) {
+		log.Printf("User %d is already in room %d", userID, roomID)
+		return roomUUID, userUUID, nil
+	}
 
-	// Check if user is already in the room
-	var count int
-	err = database.QueryRow("SELECT COUNT(*) FROM room_users WHERE room_id = $1 AND user_id = $2", roomID, userID).Scan(&count)
-	if err != nil {
+	asObserver := observer != nil && *observer
+	if err := checkRoomCapacity(database, roomID, asObserver, member && !wasObserver); err != nil {
 		return "", "", err
 	}
-	if count > 0 {
-		log.Printf("User %d is already in room %d", userID, roomID)
+
+	if member {
+		_, err = database.Exec("UPDATE room_users SET observer = $1 WHERE room_id = $2 AND user_id = $3", asObserver, roomID, userID)
+		if err != nil {
+			return "", "", err
+		}
 		return roomUUID, userUUID, nil
 	}
 
-	statement, err := database.Prepare("INSERT INTO room_users (room_id, user_id) VALUES ($1, $2)")
+	statement, err := database.Prepare("INSERT INTO room_users (room_id, user_id, observer) VALUES ($1, $2, $3)")
 	if err != nil {
 		return "", "", err
 	}
-	_, err = statement.Exec(roomID, userID)
+	_, err = statement.Exec(roomID, userID, asObserver)
 	if err != nil {
 		return "", "", err
 	}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// roundRecorder keeps the history of revealed rounds in rounds and
+// round_votes. The votes table only ever holds the current round.
+type roundRecorder struct {
+	db *sql.DB
+}
+
+var roundHistory *roundRecorder
+
+// RoundResult is a revealed round kept visible while the same issue is voted
+// again. Number counts the revealed rounds of the issue.
+type RoundResult struct {
+	Number     int               `json:"number"`
+	RevealedAt time.Time         `json:"revealedAt"`
+	Votes      []RoundResultVote `json:"votes"`
+	Stats      RoundStats        `json:"stats"`
+}
+
+// RoundResultVote has no userUUID or name when the round was anonymous.
+type RoundResultVote struct {
+	UserUUID string `json:"userUUID,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Vote     string `json:"vote"`
+}
+
+// revealRound runs whenever the cards of a round go from hidden to shown,
+// whether an admin revealed them, everybody voted with auto reveal on or the
+// countdown ran out. Revealing ends the countdown.
+func revealRound(game *Game) {
+	stopRoundTimer(game)
+	if roundHistory != nil {
+		if err := roundHistory.record(game); err != nil {
+			log.Printf("Error recording round of room %s: %v", game.roomUUID, err)
+		}
+	}
+	emitRoundRevealed(game)
+}
+
+// record stores the votes of the current round. Revealing the same round
+// again (after hiding the cards and changing votes) overwrites its votes.
+func (r *roundRecorder) record(game *Game) error {
+	voted := false
+	for _, player := range game.Players {
+		if player.Voted && player.Vote != nil {
+			voted = true
+			break
+		}
+	}
+	if !voted || game.roomID == 0 {
+		return nil
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	roundID := game.roundID
+	var session RoomSession
+	openedSession := false
+	if roundID == 0 {
+		var startedAt sql.NullTime
+		if !game.roundStartedAt.IsZero() {
+			startedAt = sql.NullTime{Time: game.roundStartedAt, Valid: true}
+		}
+		var sessionID sql.NullInt64
+		if game.persistent {
+			session, openedSession, err = sessionForRound(tx, game)
+			if err != nil {
+				return err
+			}
+			sessionID = sql.NullInt64{Int64: int64(session.ID), Valid: true}
+		}
+		err = tx.QueryRow("INSERT INTO rounds (uuid, room_id, issue_id, started_at, session_id) VALUES ($1, $2, NULLIF($3, 0), $4, $5) RETURNING id",
+			generateUuid(), game.roomID, game.currentIssueID, startedAt, sessionID).Scan(&roundID)
+		if err != nil {
+			return err
+		}
+	} else {
+		if _, err := tx.Exec("UPDATE rounds SET revealed_at = CURRENT_TIMESTAMP WHERE id = $1", roundID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM round_votes WHERE round_id = $1", roundID); err != nil {
+			return err
+		}
+	}
+
+	players := game.Players
+	if discardsAttribution(game) {
+		// Insertion order would give the voters away too
+		players = append([]*Player{}, players...)
+		rand.Shuffle(len(players), func(i, j int) { players[i], players[j] = players[j], players[i] })
+	}
+	for _, player := range players {
+		if !player.Voted || player.Vote == nil {
+			continue
+		}
+		userID := sql.NullInt64{Int64: int64(player.ID), Valid: true}
+		name := sql.NullString{String: player.Name, Valid: true}
+		if discardsAttribution(game) {
+			userID, name = sql.NullInt64{}, sql.NullString{}
+		}
+		_, err := tx.Exec("INSERT INTO round_votes (round_id, user_id, name, vote) VALUES ($1, $2, $3, $4)",
+			roundID, userID, name, *player.Vote)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if openedSession {
+		announceSession(game, session)
+	}
+	if game.roundID == 0 {
+		for i := range game.issues {
+			if game.issues[i].ID == game.currentIssueID {
+				game.issues[i].Rounds++
+			}
+		}
+	}
+	game.roundID = roundID
+	return nil
+}
+
+// revote starts another round on the same issue after the cards were
+// revealed, keeping the revealed results for comparison.
+func revote(database *sql.DB, game *Game) error {
+	if game.archived {
+		return errRoomArchived
+	}
+	if !game.showCards {
+		return newAPIError(http.StatusConflict, "round_not_revealed", "Cards must be revealed before voting again")
+	}
+
+	result := RoundResult{
+		Number:     len(game.previousRounds) + 1,
+		RevealedAt: time.Now(),
+		Votes:      []RoundResultVote{},
+	}
+	for _, issue := range game.issues {
+		if issue.ID == game.currentIssueID && issue.Rounds > 0 {
+			result.Number = issue.Rounds
+		}
+	}
+
+	var votes []string
+	for _, player := range game.Players {
+		if !player.Voted || player.Vote == nil {
+			continue
+		}
+		vote := RoundResultVote{Vote: *player.Vote}
+		if !game.settings.Anonymous {
+			vote.UserUUID = player.UUID
+			vote.Name = player.Name
+		}
+		result.Votes = append(result.Votes, vote)
+		votes = append(votes, *player.Vote)
+	}
+	if game.settings.Anonymous {
+		rand.Shuffle(len(result.Votes), func(i, j int) {
+			result.Votes[i], result.Votes[j] = result.Votes[j], result.Votes[i]
+		})
+	}
+	result.Stats = computeRoundStats(votes)
+
+	previous := append([]RoundResult{}, game.previousRounds...)
+	if len(result.Votes) > 0 {
+		previous = append(previous, result)
+	}
+	return startNewRound(database, game.roomUUID, previous)
+}
+
+func previousRoundResults(game *Game) []RoundResult {
+	if game.previousRounds == nil {
+		return []RoundResult{}
+	}
+	return game.previousRounds
+}
+
+// selectIssue makes issueUUID the issue being voted on, or clears it when
+// issueUUID is empty. Switching to another issue starts a new round so votes
+// are never attributed to the wrong issue.
+func selectIssue(database *sql.DB, game *Game, issueUUID string) error {
+	changed, err := setCurrentIssue(database, game, issueUUID)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		sendGameState(game)
+		return nil
+	}
+	return resetRoomVotes(database, game.roomUUID)
+}
+
+func setCurrentIssue(database *sql.DB, game *Game, issueUUID string) (bool, error) {
+	if game.archived {
+		return false, errRoomArchived
+	}
+
+	issueID := 0
+	if issueUUID != "" {
+		if !isValidUUID(issueUUID) {
+			return false, errIssueNotFound
+		}
+		err := database.QueryRow("SELECT id FROM issues WHERE room_id = $1 AND uuid = $2", game.roomID, issueUUID).Scan(&issueID)
+		if err == sql.ErrNoRows {
+			return false, errIssueNotFound
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if issueID == game.currentIssueID {
+		return false, nil
+	}
+
+	_, err := database.Exec("UPDATE rooms SET current_issue_id = NULLIF($1, 0) WHERE id = $2", issueID, game.roomID)
+	if err != nil {
+		return false, err
+	}
+	game.currentIssueID = issueID
+	game.currentIssueUUID = issueUUID
+	return true, nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// exactEstimatePoints is what a vote equal to the agreed estimate scores.
+// Every card of distance on the deck's scale costs one point.
+const exactEstimatePoints = 3
+
+// defaultScale is used for rooms whose deck has no numeric cards, such as
+// rooms created with the client's built-in deck.
+var defaultScale = []float64{0, 0.5, 1, 2, 3, 5, 8, 13, 20, 40, 100}
+
+// LeaderboardEntry sums a player's scored votes in one room.
+type LeaderboardEntry struct {
+	UserUUID        string  `json:"userUUID"`
+	Name            string  `json:"name"`
+	Points          int     `json:"points"`
+	ScoredVotes     int     `json:"scoredVotes"`
+	ExactVotes      int     `json:"exactVotes"`
+	AverageDistance float64 `json:"averageDistance"`
+}
+
+type LeaderboardResponse struct {
+	Players []LeaderboardEntry `json:"players"`
+}
+
+// ScoreEntry is one scored vote in a user's history.
+type ScoreEntry struct {
+	RoomUUID   string    `json:"roomUUID"`
+	RoomName   string    `json:"roomName"`
+	IssueUUID  string    `json:"issueUUID"`
+	IssueTitle string    `json:"issueTitle"`
+	Vote       string    `json:"vote"`
+	Estimate   string    `json:"estimate"`
+	Distance   int       `json:"distance"`
+	Points     int       `json:"points"`
+	ScoredAt   time.Time `json:"scoredAt"`
+}
+
+type ScoreHistoryResponse struct {
+	UserUUID    string       `json:"userUUID"`
+	Points      int          `json:"points"`
+	ScoredVotes int          `json:"scoredVotes"`
+	Scores      []ScoreEntry `json:"scores"`
+	NextOffset  *int         `json:"nextOffset"`
+}
+
+// deckScale returns the numeric values of the deck in ascending order.
+func deckScale(deck []CardOption) []float64 {
+	var scale []float64
+	seen := map[float64]bool{}
+	for _, card := range deck {
+		value, ok := parseVote(card.Value)
+		if ok && !seen[value] {
+			seen[value] = true
+			scale = append(scale, value)
+		}
+	}
+	if len(scale) == 0 {
+		return defaultScale
+	}
+	sort.Float64s(scale)
+	return scale
+}
+
+// scaleRank is twice the position of value on the scale, so values between
+// two cards rank half a card apart from either.
+func scaleRank(scale []float64, value float64) int {
+	index := sort.SearchFloat64s(scale, value)
+	if index < len(scale) && scale[index] == value {
+		return 2 * index
+	}
+	return 2*index - 1
+}
+
+// scoreVote measures how many cards vote is away from estimate. ok is false
+// when either is not a number ("?", "☕").
+func scoreVote(scale []float64, vote string, estimate string) (distance int, points int, ok bool) {
+	voteValue, ok := parseVote(vote)
+	if !ok {
+		return 0, 0, false
+	}
+	estimateValue, ok := parseVote(estimate)
+	if !ok {
+		return 0, 0, false
+	}
+
+	distance = scaleRank(scale, voteValue) - scaleRank(scale, estimateValue)
+	if distance < 0 {
+		distance = -distance
+	}
+	distance = (distance + 1) / 2
+	points = exactEstimatePoints - distance
+	if points < 0 {
+		points = 0
+	}
+	return distance, points, true
+}
+
+// scoreIssue (re)scores every attributed vote of every revealed round of the
+// issue against its agreed estimate, then refreshes the players' scores.
+func scoreIssue(database *sql.DB, game *Game, issue Issue) error {
+	if issue.Estimate == nil {
+		return nil
+	}
+	scale := deckScale(game.deck)
+
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM player_scores WHERE issue_id = $1", issue.ID); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT rv.round_id, rv.user_id, rv.vote FROM round_votes rv
+		JOIN rounds ro ON ro.id = rv.round_id
+		WHERE ro.issue_id = $1 AND rv.user_id IS NOT NULL`, issue.ID)
+	if err != nil {
+		return err
+	}
+
+	type roundVote struct {
+		roundID, userID int
+		vote            string
+	}
+	var votes []roundVote
+	for rows.Next() {
+		var vote roundVote
+		if err := rows.Scan(&vote.roundID, &vote.userID, &vote.vote); err != nil {
+			rows.Close()
+			return err
+		}
+		votes = append(votes, vote)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, vote := range votes {
+		distance, points, ok := scoreVote(scale, vote.vote, *issue.Estimate)
+		if !ok {
+			continue
+		}
+		_, err := tx.Exec(`INSERT INTO player_scores (room_id, issue_id, round_id, user_id, vote, estimate, distance, points)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			game.roomID, issue.ID, vote.roundID, vote.userID, vote.vote, *issue.Estimate, distance, points)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return refreshScores(database, game)
+}
+
+// refreshScores loads each player's total points in the room into
+// Player.Score.
+func refreshScores(database *sql.DB, game *Game) error {
+	rows, err := database.Query("SELECT user_id, SUM(points) FROM player_scores WHERE room_id = $1 GROUP BY user_id", game.roomID)
+	if err != nil {
+		return fmt.Errorf("error fetching scores: %v", err)
+	}
+	defer rows.Close()
+
+	scores := map[int]int{}
+	for rows.Next() {
+		var userID, points int
+		if err := rows.Scan(&userID, &points); err != nil {
+			return fmt.Errorf("error scanning score: %v", err)
+		}
+		scores[userID] = points
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, player := range game.Players {
+		player.Score = scores[player.ID]
+	}
+	return nil
+}
+
+func apiLeaderboard(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomID, err := findRoomID(database, mux.Vars(r)["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+
+		rows, err := database.Query(`SELECT u.uuid, u.name, SUM(ps.points), COUNT(*),
+				COUNT(*) FILTER (WHERE ps.distance = 0), AVG(ps.distance)
+			FROM player_scores ps
+			JOIN users u ON u.id = ps.user_id
+			WHERE ps.room_id = $1
+			GROUP BY u.id, u.uuid, u.name
+			ORDER BY SUM(ps.points) DESC, AVG(ps.distance), u.name`, roomID)
+		if handleError(w, err) {
+			return
+		}
+		defer rows.Close()
+
+		response := LeaderboardResponse{Players: []LeaderboardEntry{}}
+		for rows.Next() {
+			var entry LeaderboardEntry
+			var name sql.NullString
+			err := rows.Scan(&entry.UserUUID, &name, &entry.Points, &entry.ScoredVotes, &entry.ExactVotes, &entry.AverageDistance)
+			if handleError(w, err) {
+				return
+			}
+			entry.Name = name.String
+			response.Players = append(response.Players, entry)
+		}
+		if handleError(w, rows.Err()) {
+			return
+		}
+
+		sendResponse(w, response)
+	}
+}
+
+func apiUserScores(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userUUID := mux.Vars(r)["userUUID"]
+		userID, err := findUserID(database, userUUID)
+		if handleError(w, err) {
+			return
+		}
+
+		limit, offset := 50, 0
+		if value := r.URL.Query().Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 || parsed > 200 {
+				handleError(w, errInvalidField("limit", "limit must be between 1 and 200"))
+				return
+			}
+			limit = parsed
+		}
+		if value := r.URL.Query().Get("offset"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 {
+				handleError(w, errInvalidField("offset", "offset must be a non-negative integer"))
+				return
+			}
+			offset = parsed
+		}
+
+		response := ScoreHistoryResponse{UserUUID: userUUID, Scores: []ScoreEntry{}}
+		err = database.QueryRow("SELECT COALESCE(SUM(points), 0), COUNT(*) FROM player_scores WHERE user_id = $1", userID).
+			Scan(&response.Points, &response.ScoredVotes)
+		if handleError(w, err) {
+			return
+		}
+
+		rows, err := database.Query(`SELECT r.uuid, r.name, i.uuid, i.title, ps.vote, ps.estimate, ps.distance, ps.points, ps.created_at
+			FROM player_scores ps
+			JOIN rooms r ON r.id = ps.room_id
+			JOIN issues i ON i.id = ps.issue_id
+			WHERE ps.user_id = $1
+			ORDER BY ps.created_at DESC, ps.id DESC
+			LIMIT $2 OFFSET $3`, userID, limit, offset)
+		if handleError(w, err) {
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var entry ScoreEntry
+			var roomName, issueTitle sql.NullString
+			err := rows.Scan(&entry.RoomUUID, &roomName, &entry.IssueUUID, &issueTitle, &entry.Vote, &entry.Estimate,
+				&entry.Distance, &entry.Points, &entry.ScoredAt)
+			if handleError(w, err) {
+				return
+			}
+			entry.RoomName = roomName.String
+			entry.IssueTitle = issueTitle.String
+			response.Scores = append(response.Scores, entry)
+		}
+		if handleError(w, rows.Err()) {
+			return
+		}
+
+		if next := offset + len(response.Scores); next < response.ScoredVotes {
+			response.NextOffset = &next
+		}
+		sendResponse(w, response)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import "testing"
+
+func TestScaleRank(t *testing.T) {
+	scale := []float64{1, 2, 3, 5, 8}
+	tests := []struct {
+		value float64
+		want  int
+	}{
+		{1, 0},
+		{2, 2},
+		{8, 8},
+		{4, 5},
+		{2.5, 3},
+		{0, -1},
+		{13, 9},
+	}
+	for _, tt := range tests {
+		if got := scaleRank(scale, tt.value); got != tt.want {
+			t.Errorf("scaleRank(%v) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestScoreVote(t *testing.T) {
+	tests := []struct {
+		name         string
+		scale        []float64
+		vote         string
+		estimate     string
+		wantDistance int
+		wantPoints   int
+		wantOK       bool
+	}{
+		{"exact", defaultScale, "5", "5", 0, exactEstimatePoints, true},
+		{"one card above", defaultScale, "8", "5", 1, 2, true},
+		{"one card below", defaultScale, "3", "5", 1, 2, true},
+		{"two cards away", defaultScale, "13", "5", 2, 1, true},
+		{"far away scores nothing", defaultScale, "100", "1", 8, 0, true},
+		{"half card", defaultScale, "½", "1", 1, 2, true},
+		{"between cards", defaultScale, "4", "5", 1, 2, true},
+		{"between cards both sides", defaultScale, "4", "3", 1, 2, true},
+		{"off the deck", []float64{1, 2, 3}, "5", "3", 1, 2, true},
+		{"question mark vote", defaultScale, "?", "5", 0, 0, false},
+		{"coffee estimate", defaultScale, "3", "☕", 0, 0, false},
+		{"empty vote", defaultScale, "", "3", 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			distance, points, ok := scoreVote(tt.scale, tt.vote, tt.estimate)
+			if distance != tt.wantDistance || points != tt.wantPoints || ok != tt.wantOK {
+				t.Errorf("scoreVote(%q, %q) = (%d, %d, %v), want (%d, %d, %v)",
+					tt.vote, tt.estimate, distance, points, ok, tt.wantDistance, tt.wantPoints, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestDeckScale(t *testing.T) {
+	deck := []CardOption{{Value: "8"}, {Value: "?"}, {Value: "1"}, {Value: "3"}, {Value: "1"}, {Value: "☕"}}
+	scale := deckScale(deck)
+	want := []float64{1, 3, 8}
+	if len(scale) != len(want) {
+		t.Fatalf("deckScale = %v, want %v", scale, want)
+	}
+	for i := range want {
+		if scale[i] != want[i] {
+			t.Fatalf("deckScale = %v, want %v", scale, want)
+		}
+	}
+
+	if got := deckScale([]CardOption{{Value: "?"}}); len(got) != len(defaultScale) {
+		t.Errorf("deck without numbers: got %v, want the default scale", got)
+	}
+}
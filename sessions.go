@@ -0,0 +1,255 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// sessionGap is how long a persistent room stays idle before its next round
+// opens a new session, SESSION_GAP_HOURS when set.
+var sessionGap = 12 * time.Hour
+
+var errRoomNotPersistent = newAPIError(http.StatusConflict, "room_not_persistent", "Only persistent rooms have sessions")
+
+// RoomSession is one sitting of a persistent room, like the refinement of a
+// sprint. Rounds revealed while it is open belong to it.
+type RoomSession struct {
+	ID        int        `json:"-"`
+	UUID      string     `json:"uuid"`
+	Name      string     `json:"name"`
+	StartedAt time.Time  `json:"startedAt"`
+	EndedAt   *time.Time `json:"endedAt"`
+	Rounds    int        `json:"rounds"`
+	Issues    int        `json:"issues"`
+}
+
+type StartSessionRequest struct {
+	UserUUID string `json:"userUUID"`
+	// Defaults to the date the session starts
+	Name string `json:"name"`
+}
+
+type SessionResponse struct {
+	Session RoomSession `json:"session"`
+}
+
+type SessionsResponse struct {
+	Sessions []RoomSession `json:"sessions"`
+}
+
+// SessionStartedEvent is broadcast to the room when a new session opens.
+type SessionStartedEvent struct {
+	Type    string      `json:"type"`
+	Session RoomSession `json:"session"`
+}
+
+// openSession ends the open session of the room, at endedAt or now when it
+// is nil, and starts a new one.
+func openSession(tx *sql.Tx, roomID int, name string, endedAt *time.Time) (RoomSession, error) {
+	_, err := tx.Exec("UPDATE room_sessions SET ended_at = COALESCE($1, CURRENT_TIMESTAMP) WHERE room_id = $2 AND ended_at IS NULL", endedAt, roomID)
+	if err != nil {
+		return RoomSession{}, err
+	}
+
+	session := RoomSession{UUID: generateUuid(), Name: name}
+	if session.Name == "" {
+		session.Name = time.Now().Format("2006-01-02")
+	}
+	err = tx.QueryRow("INSERT INTO room_sessions (uuid, room_id, name) VALUES ($1, $2, $3) RETURNING id, started_at",
+		session.UUID, roomID, session.Name).Scan(&session.ID, &session.StartedAt)
+	return session, err
+}
+
+// sessionForRound returns the session a new round of a persistent room
+// belongs to, opening one when there is none or the open one went idle. The
+// returned bool reports whether the session was just opened.
+func sessionForRound(tx *sql.Tx, game *Game) (RoomSession, bool, error) {
+	if game.session != nil {
+		var lastActivity time.Time
+		err := tx.QueryRow("SELECT COALESCE((SELECT MAX(revealed_at) FROM rounds WHERE session_id = $1), $2)",
+			game.session.ID, game.session.StartedAt).Scan(&lastActivity)
+		if err != nil {
+			return RoomSession{}, false, err
+		}
+		if time.Since(lastActivity) < sessionGap {
+			return *game.session, false, nil
+		}
+		session, err := openSession(tx, game.roomID, "", &lastActivity)
+		return session, true, err
+	}
+
+	session, err := openSession(tx, game.roomID, "", nil)
+	return session, true, err
+}
+
+// startSession opens a new session on request, ending the current one.
+func startSession(database *sql.DB, game *Game, name string) (RoomSession, error) {
+	if !game.persistent {
+		return RoomSession{}, errRoomNotPersistent
+	}
+	if game.archived {
+		return RoomSession{}, errRoomArchived
+	}
+	name = strings.TrimSpace(name)
+	if utf8.RuneCountInString(name) > 255 {
+		return RoomSession{}, errInvalidField("name", "name must be at most 255 characters")
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		return RoomSession{}, err
+	}
+	defer tx.Rollback()
+
+	session, err := openSession(tx, game.roomID, name, nil)
+	if err != nil {
+		return RoomSession{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return RoomSession{}, err
+	}
+
+	announceSession(game, session)
+	return session, nil
+}
+
+func announceSession(game *Game, session RoomSession) {
+	game.session = &session
+	broadcast(game, SessionStartedEvent{Type: "sessionStarted", Session: session})
+}
+
+// fetchOpenSession returns the session of the room that has not ended, or
+// nil when there is none.
+func fetchOpenSession(database *sql.DB, roomID int) (*RoomSession, error) {
+	var session RoomSession
+	err := database.QueryRow("SELECT id, uuid, name, started_at FROM room_sessions WHERE room_id = $1 AND ended_at IS NULL ORDER BY id DESC LIMIT 1",
+		roomID).Scan(&session.ID, &session.UUID, &session.Name, &session.StartedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching open session: %v", err)
+	}
+	return &session, nil
+}
+
+// fetchSessions lists the sessions of the room, newest first, with the
+// rounds revealed and issues voted in each.
+func fetchSessions(database *sql.DB, roomID int) ([]RoomSession, error) {
+	rows, err := database.Query(`SELECT s.id, s.uuid, s.name, s.started_at, s.ended_at,
+			(SELECT COUNT(*) FROM rounds ro WHERE ro.session_id = s.id),
+			(SELECT COUNT(DISTINCT ro.issue_id) FROM rounds ro WHERE ro.session_id = s.id)
+		FROM room_sessions s
+		WHERE s.room_id = $1
+		ORDER BY s.id DESC`, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching sessions: %v", err)
+	}
+	defer rows.Close()
+
+	sessions := []RoomSession{}
+	for rows.Next() {
+		var session RoomSession
+		var name sql.NullString
+		var endedAt sql.NullTime
+		if err := rows.Scan(&session.ID, &session.UUID, &name, &session.StartedAt, &endedAt, &session.Rounds, &session.Issues); err != nil {
+			return nil, fmt.Errorf("error scanning session: %v", err)
+		}
+		session.Name = name.String
+		if endedAt.Valid {
+			session.EndedAt = &endedAt.Time
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// setRoomPersistent changes the kind of the room. Making an archived room
+// persistent brings it back, since persistent rooms are never archived, and
+// making it temporary ends its open session.
+func setRoomPersistent(database *sql.DB, game *Game, persistent bool) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE rooms SET persistent = $1, archived_at = CASE WHEN $1 THEN NULL ELSE archived_at END WHERE id = $2",
+		persistent, game.roomID)
+	if err != nil {
+		return err
+	}
+	if !persistent {
+		_, err = tx.Exec("UPDATE room_sessions SET ended_at = CURRENT_TIMESTAMP WHERE room_id = $1 AND ended_at IS NULL", game.roomID)
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	game.persistent = persistent
+	if persistent {
+		game.archived = false
+		session, err := fetchOpenSession(database, game.roomID)
+		if err != nil {
+			return err
+		}
+		game.session = session
+	} else {
+		game.session = nil
+	}
+	sendGameState(game)
+	return nil
+}
+
+func apiListSessions(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomID, err := findRoomID(database, mux.Vars(r)["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+		if _, err := requireRoomAccess(database, roomID, r.URL.Query().Get("userUUID")); handleError(w, err) {
+			return
+		}
+
+		sessions, err := fetchSessions(database, roomID)
+		if handleError(w, err) {
+			return
+		}
+		sendResponse(w, SessionsResponse{Sessions: sessions})
+	}
+}
+
+func apiStartSession(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req StartSessionRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+
+		game, err := loadGame(database, mux.Vars(r)["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+		if _, err := requireRoomAdmin(database, game.roomID, req.UserUUID); handleError(w, err) {
+			return
+		}
+
+		session, err := startSession(database, game, req.Name)
+		if handleError(w, err) {
+			return
+		}
+		touchRoom(game)
+		sendGameState(game)
+
+		sendResponseWithStatus(w, http.StatusCreated, SessionResponse{Session: session})
+	}
+}
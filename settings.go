@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Who may reveal the cards or start a new round
+const (
+	policyAnyone = "anyone"
+	policyAdmin  = "admin"
+)
+
+const maxRoomPlayers = 100
+
+var (
+	errRoomFull            = newAPIError(http.StatusConflict, "room_full", "The room has reached its maximum number of players")
+	errObserversNotAllowed = newAPIError(http.StatusForbidden, "observers_not_allowed", "This room does not allow observers")
+	errObserverCannotVote  = newAPIError(http.StatusForbidden, "observer_cannot_vote", "Observers cannot vote")
+)
+
+// RoomSettings is the configuration of a room. It is stored as a single JSON
+// document, so adding a setting needs neither a column nor a route.
+type RoomSettings struct {
+	AutoReveal          bool   `json:"autoReveal"`
+	Anonymous           bool   `json:"anonymous"`
+	KeepVoteAttribution bool   `json:"keepVoteAttribution"`
+	AllowObservers      bool   `json:"allowObservers"`
+	RevealPolicy        string `json:"revealPolicy"`
+	ResetPolicy         string `json:"resetPolicy"`
+	EmojisEnabled       bool   `json:"emojisEnabled"`
+	TimerDefaultSeconds int    `json:"timerDefaultSeconds"`
+	TimerAutoReveal     bool   `json:"timerAutoReveal"`
+	MaxPlayers          int    `json:"maxPlayers"`
+}
+
+// RoomSettingsPatch changes the settings present in it; nil leaves a
+// setting unchanged.
+type RoomSettingsPatch struct {
+	AutoReveal          *bool   `json:"autoReveal"`
+	Anonymous           *bool   `json:"anonymous"`
+	KeepVoteAttribution *bool   `json:"keepVoteAttribution"`
+	AllowObservers      *bool   `json:"allowObservers"`
+	RevealPolicy        *string `json:"revealPolicy"`
+	ResetPolicy         *string `json:"resetPolicy"`
+	EmojisEnabled       *bool   `json:"emojisEnabled"`
+	TimerDefaultSeconds *int    `json:"timerDefaultSeconds"`
+	TimerAutoReveal     *bool   `json:"timerAutoReveal"`
+	MaxPlayers          *int    `json:"maxPlayers"`
+}
+
+type RoomSettingsResponse struct {
+	Settings RoomSettings `json:"settings"`
+}
+
+// SettingsChangedEvent is broadcast to the room whenever its settings change.
+type SettingsChangedEvent struct {
+	Type     string       `json:"type"`
+	Settings RoomSettings `json:"settings"`
+}
+
+func defaultRoomSettings() RoomSettings {
+	return RoomSettings{
+		KeepVoteAttribution: true,
+		AllowObservers:      true,
+		RevealPolicy:        policyAnyone,
+		ResetPolicy:         policyAnyone,
+		EmojisEnabled:       true,
+	}
+}
+
+// parseRoomSettings reads a stored document. Settings missing from it, like
+// the ones added after the room was created, keep their defaults.
+func parseRoomSettings(raw []byte) RoomSettings {
+	settings := defaultRoomSettings()
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &settings); err != nil {
+			log.Printf("Error decoding room settings: %v", err)
+			return defaultRoomSettings()
+		}
+	}
+	return settings
+}
+
+func (s RoomSettings) validate() error {
+	policies := []struct{ field, value string }{{"revealPolicy", s.RevealPolicy}, {"resetPolicy", s.ResetPolicy}}
+	for _, policy := range policies {
+		if policy.value != policyAnyone && policy.value != policyAdmin {
+			return errInvalidField(policy.field, policy.field+` must be "anyone" or "admin"`)
+		}
+	}
+	if s.TimerDefaultSeconds < 0 || s.TimerDefaultSeconds > maxTimerSeconds {
+		return errInvalidField("timerDefaultSeconds", fmt.Sprintf("timerDefaultSeconds must be between 0 and %d", maxTimerSeconds))
+	}
+	if s.MaxPlayers < 0 || s.MaxPlayers > maxRoomPlayers {
+		return errInvalidField("maxPlayers", fmt.Sprintf("maxPlayers must be between 0 and %d", maxRoomPlayers))
+	}
+	return nil
+}
+
+func (p RoomSettingsPatch) apply(s RoomSettings) RoomSettings {
+	setBool := func(target *bool, value *bool) {
+		if value != nil {
+			*target = *value
+		}
+	}
+	setBool(&s.AutoReveal, p.AutoReveal)
+	setBool(&s.Anonymous, p.Anonymous)
+	setBool(&s.KeepVoteAttribution, p.KeepVoteAttribution)
+	setBool(&s.AllowObservers, p.AllowObservers)
+	setBool(&s.EmojisEnabled, p.EmojisEnabled)
+	setBool(&s.TimerAutoReveal, p.TimerAutoReveal)
+	if p.RevealPolicy != nil {
+		s.RevealPolicy = *p.RevealPolicy
+	}
+	if p.ResetPolicy != nil {
+		s.ResetPolicy = *p.ResetPolicy
+	}
+	if p.TimerDefaultSeconds != nil {
+		s.TimerDefaultSeconds = *p.TimerDefaultSeconds
+	}
+	if p.MaxPlayers != nil {
+		s.MaxPlayers = *p.MaxPlayers
+	}
+	return s
+}
+
+// decodeSettingsPatch rejects unknown settings instead of ignoring them, so
+// typos do not silently leave a room misconfigured.
+func decodeSettingsPatch(body io.Reader) (RoomSettingsPatch, error) {
+	var patch RoomSettingsPatch
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patch); err != nil {
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			field = strings.Trim(field, `"`)
+			return patch, errInvalidField(field, "Unknown setting "+field)
+		}
+		return patch, err
+	}
+	return patch, nil
+}
+
+func fetchRoomSettings(database *sql.DB, roomID int) (RoomSettings, error) {
+	var raw []byte
+	if err := database.QueryRow("SELECT settings FROM rooms WHERE id = $1", roomID).Scan(&raw); err != nil {
+		return RoomSettings{}, err
+	}
+	return parseRoomSettings(raw), nil
+}
+
+// updateRoomSettings applies patch to the stored settings and broadcasts
+// the result to the room.
+func updateRoomSettings(database *sql.DB, roomUUID string, patch RoomSettingsPatch) (RoomSettings, error) {
+	roomID, err := findRoomID(database, roomUUID)
+	if err != nil {
+		return RoomSettings{}, err
+	}
+	if err := ensureRoomWritable(database, roomID); err != nil {
+		return RoomSettings{}, err
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		return RoomSettings{}, err
+	}
+	defer tx.Rollback()
+
+	var raw []byte
+	if err := tx.QueryRow("SELECT settings FROM rooms WHERE id = $1 FOR UPDATE", roomID).Scan(&raw); err != nil {
+		return RoomSettings{}, err
+	}
+	settings := patch.apply(parseRoomSettings(raw))
+	if err := settings.validate(); err != nil {
+		return RoomSettings{}, err
+	}
+
+	encoded, err := json.Marshal(settings)
+	if err != nil {
+		return RoomSettings{}, err
+	}
+	if _, err := tx.Exec("UPDATE rooms SET settings = $1 WHERE id = $2", encoded, roomID); err != nil {
+		return RoomSettings{}, err
+	}
+	// Turning automatic reveal off hides the cards again
+	hide := patch.AutoReveal != nil && !*patch.AutoReveal
+	if hide {
+		if _, err := tx.Exec("UPDATE rooms SET showCards = $1 WHERE id = $2", false, roomID); err != nil {
+			return RoomSettings{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return RoomSettings{}, err
+	}
+
+	game, exists := games[roomUUID]
+	if exists {
+		game.settings = settings
+		if hide {
+			game.showCards = false
+		}
+		broadcast(game, SettingsChangedEvent{Type: "settingsChanged", Settings: settings})
+		sendGameState(game)
+	}
+	return settings, nil
+}
+
+// policyAllows reports whether userID may act under a reveal or reset policy.
+func policyAllows(game *Game, policy string, userID int) bool {
+	return policy != policyAdmin || isFacilitator(game, userID)
+}
+
+// checkRoomPolicy is policyAllows for requests that identify the user by
+// uuid. The uuid is only required when the policy restricts the action.
+func checkRoomPolicy(database *sql.DB, game *Game, policy string, userUUID string) error {
+	if policy != policyAdmin {
+		return nil
+	}
+	userID, err := findUserID(database, userUUID)
+	if err != nil {
+		return err
+	}
+	if !policyAllows(game, policy, userID) {
+		return errForbidden
+	}
+	return nil
+}
+
+// checkRoomCapacity enforces the observer and max players settings for a
+// user joining the room, or switching between playing and observing.
+func checkRoomCapacity(database *sql.DB, roomID int, observer bool, wasPlayer bool) error {
+	settings, err := fetchRoomSettings(database, roomID)
+	if err != nil {
+		return err
+	}
+	if observer {
+		if !settings.AllowObservers {
+			return errObserversNotAllowed
+		}
+		return nil
+	}
+	if settings.MaxPlayers == 0 || wasPlayer {
+		return nil
+	}
+
+	var players int
+	err = database.QueryRow("SELECT COUNT(*) FROM room_users WHERE room_id = $1 AND NOT observer", roomID).Scan(&players)
+	if err != nil {
+		return err
+	}
+	if players >= settings.MaxPlayers {
+		return errRoomFull
+	}
+	return nil
+}
+
+// refreshObservers loads which players only watch the room.
+func refreshObservers(database *sql.DB, game *Game) error {
+	rows, err := database.Query("SELECT user_id FROM room_users WHERE room_id = $1 AND observer", game.roomID)
+	if err != nil {
+		return fmt.Errorf("error fetching observers: %v", err)
+	}
+	defer rows.Close()
+
+	observers := map[int]bool{}
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return fmt.Errorf("error scanning observer: %v", err)
+		}
+		observers[userID] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, player := range game.Players {
+		player.Observer = observers[player.ID]
+	}
+	return nil
+}
+
+func handleUpdateSettings(msg map[string]interface{}, game *Game, userID int, db *sql.DB) {
+	if !isFacilitator(game, userID) {
+		log.Printf("User %d is not the admin of room %s and cannot change its settings", userID, game.roomUUID)
+		return
+	}
+
+	// Re-encode the settings so they go through the same strict decoding as the API
+	encoded, err := json.Marshal(msg["settings"])
+	if err != nil {
+		log.Printf("Invalid settings format: %v", err)
+		return
+	}
+	patch, err := decodeSettingsPatch(bytes.NewReader(encoded))
+	if err != nil {
+		log.Printf("Invalid settings: %v", err)
+		return
+	}
+
+	if _, err := updateRoomSettings(db, game.roomUUID, patch); err != nil {
+		log.Printf("Error updating room settings: %v", err)
+	}
+}
+
+func apiGetRoomSettings(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		game, err := loadGame(database, mux.Vars(r)["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+
+		sendResponse(w, RoomSettingsResponse{Settings: game.settings})
+	}
+}
+
+func apiUpdateRoomSettings(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomUUID := mux.Vars(r)["roomUUID"]
+
+		patch, err := decodeSettingsPatch(r.Body)
+		if handleError(w, err) {
+			return
+		}
+
+		roomID, err := findRoomID(database, roomUUID)
+		if handleError(w, err) {
+			return
+		}
+		if _, err := requireRoomAdmin(database, roomID, r.URL.Query().Get("userUUID")); handleError(w, err) {
+			return
+		}
+
+		settings, err := updateRoomSettings(database, roomUUID, patch)
+		if handleError(w, err) {
+			return
+		}
+
+		sendResponse(w, RoomSettingsResponse{Settings: settings})
+	}
+}
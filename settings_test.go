@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeSettingsPatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		want      RoomSettings
+		wantCode  string
+		wantField string
+	}{
+		{"empty object", `{}`, defaultRoomSettings(), "", ""},
+		{"some settings", `{"revealPolicy": "admin", "maxPlayers": 8, "emojisEnabled": false}`,
+			RoomSettings{KeepVoteAttribution: true, AllowObservers: true, RevealPolicy: policyAdmin, ResetPolicy: policyAnyone, MaxPlayers: 8}, "", ""},
+		{"explicit false", `{"keepVoteAttribution": false}`,
+			RoomSettings{AllowObservers: true, RevealPolicy: policyAnyone, ResetPolicy: policyAnyone, EmojisEnabled: true}, "", ""},
+		{"unknown setting", `{"autoShowCards": true}`, RoomSettings{}, "invalid_field", "autoShowCards"},
+		{"wrong type", `{"maxPlayers": "8"}`, RoomSettings{}, "invalid_field", "maxPlayers"},
+		{"not JSON", `revealPolicy=admin`, RoomSettings{}, "invalid_body", ""},
+		{"empty body", ``, RoomSettings{}, "invalid_body", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := decodeSettingsPatch(strings.NewReader(tt.body))
+			if tt.wantCode != "" {
+				if err == nil {
+					t.Fatalf("got patch %+v, want error %s", patch, tt.wantCode)
+				}
+				apiErr := toAPIError(err)
+				if apiErr.Code != tt.wantCode {
+					t.Fatalf("got error %s (%v), want %s", apiErr.Code, err, tt.wantCode)
+				}
+				if details, _ := apiErr.Details.(map[string]string); tt.wantField != "" && details["field"] != tt.wantField {
+					t.Errorf("got details %v, want field %s", apiErr.Details, tt.wantField)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := patch.apply(defaultRoomSettings()); got != tt.want {
+				t.Errorf("got settings %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoomSettingsValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		change    func(s *RoomSettings)
+		wantField string
+	}{
+		{"defaults", func(s *RoomSettings) {}, ""},
+		{"admin policies", func(s *RoomSettings) { s.RevealPolicy, s.ResetPolicy = policyAdmin, policyAdmin }, ""},
+		{"unknown reveal policy", func(s *RoomSettings) { s.RevealPolicy = "owner" }, "revealPolicy"},
+		{"empty reset policy", func(s *RoomSettings) { s.ResetPolicy = "" }, "resetPolicy"},
+		{"longest timer", func(s *RoomSettings) { s.TimerDefaultSeconds = maxTimerSeconds }, ""},
+		{"timer too long", func(s *RoomSettings) { s.TimerDefaultSeconds = maxTimerSeconds + 1 }, "timerDefaultSeconds"},
+		{"negative timer", func(s *RoomSettings) { s.TimerDefaultSeconds = -1 }, "timerDefaultSeconds"},
+		{"largest room", func(s *RoomSettings) { s.MaxPlayers = maxRoomPlayers }, ""},
+		{"room too large", func(s *RoomSettings) { s.MaxPlayers = maxRoomPlayers + 1 }, "maxPlayers"},
+		{"negative max players", func(s *RoomSettings) { s.MaxPlayers = -1 }, "maxPlayers"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := defaultRoomSettings()
+			tt.change(&settings)
+			err := settings.validate()
+			if tt.wantField == "" {
+				if err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("got no error, want one on %s", tt.wantField)
+			}
+			if details, _ := toAPIError(err).Details.(map[string]string); details["field"] != tt.wantField {
+				t.Errorf("got error %v on %v, want one on %s", err, toAPIError(err).Details, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestParseRoomSettings(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want RoomSettings
+	}{
+		{"no document", ``, defaultRoomSettings()},
+		{"settings added later keep their defaults", `{"autoReveal": true}`,
+			RoomSettings{AutoReveal: true, KeepVoteAttribution: true, AllowObservers: true, RevealPolicy: policyAnyone, ResetPolicy: policyAnyone, EmojisEnabled: true}},
+		{"unreadable document", `{"autoReveal":`, defaultRoomSettings()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRoomSettings([]byte(tt.raw)); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+// RoundStats summarises the votes of a revealed round. Numeric statistics
+// only consider votes that parse as numbers ("?", "☕" and friends are
+// counted in Distribution but ignored otherwise).
+type RoundStats struct {
+	Votes        int            `json:"votes"`
+	NumericVotes int            `json:"numericVotes"`
+	Average      *float64       `json:"average"`
+	Median       *float64       `json:"median"`
+	Min          *float64       `json:"min"`
+	Max          *float64       `json:"max"`
+	StdDev       *float64       `json:"stdDev"`
+	Distribution map[string]int `json:"distribution"`
+	Consensus    bool           `json:"consensus"`
+}
+
+func computeRoundStats(votes []string) RoundStats {
+	stats := RoundStats{
+		Votes:        len(votes),
+		Distribution: make(map[string]int),
+	}
+
+	var numbers []float64
+	for _, vote := range votes {
+		stats.Distribution[vote]++
+		if value, ok := parseVote(vote); ok {
+			numbers = append(numbers, value)
+		}
+	}
+	stats.Consensus = len(votes) > 0 && len(stats.Distribution) == 1
+	stats.NumericVotes = len(numbers)
+
+	if len(numbers) == 0 {
+		return stats
+	}
+
+	sort.Float64s(numbers)
+	sum := 0.0
+	for _, value := range numbers {
+		sum += value
+	}
+	average := sum / float64(len(numbers))
+
+	median := numbers[len(numbers)/2]
+	if len(numbers)%2 == 0 {
+		median = (numbers[len(numbers)/2-1] + numbers[len(numbers)/2]) / 2
+	}
+
+	variance := 0.0
+	for _, value := range numbers {
+		variance += (value - average) * (value - average)
+	}
+	stdDev := math.Sqrt(variance / float64(len(numbers)))
+
+	stats.Average = &average
+	stats.Median = &median
+	stats.Min = &numbers[0]
+	stats.Max = &numbers[len(numbers)-1]
+	stats.StdDev = &stdDev
+	return stats
+}
+
+// parseVote returns the numeric value of a card, accepting "½" and "1/2".
+func parseVote(vote string) (float64, bool) {
+	switch vote {
+	case "½", "1/2":
+		return 0.5, true
+	}
+	value, err := strconv.ParseFloat(vote, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, false
+	}
+	return value, true
+}
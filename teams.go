@@ -0,0 +1,585 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// Roles of the team members. Team admins facilitate every room of the team
+// like its owner does.
+const (
+	teamRoleAdmin  = "admin"
+	teamRoleMember = "member"
+)
+
+var (
+	errTeamNotFound  = newAPIError(http.StatusNotFound, "team_not_found", "Team not found")
+	errNotTeamMember = newAPIError(http.StatusForbidden, "not_team_member", "Only members of the team can perform this action")
+	errLastTeamAdmin = newAPIError(http.StatusConflict, "last_team_admin", "A team needs at least one admin")
+)
+
+type Team struct {
+	ID        int          `json:"-"`
+	UUID      string       `json:"uuid"`
+	Name      string       `json:"name"`
+	CreatedAt time.Time    `json:"createdAt"`
+	Members   []TeamMember `json:"members"`
+}
+
+type TeamMember struct {
+	UserUUID string    `json:"userUUID"`
+	Name     string    `json:"name"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joinedAt"`
+}
+
+type CreateTeamRequest struct {
+	UserUUID string `json:"userUUID"`
+	Name     string `json:"name"`
+}
+
+// TeamMemberRequest adds a member or changes their role. UserUUID is the
+// team admin making the change.
+type TeamMemberRequest struct {
+	UserUUID string `json:"userUUID"`
+	Role     string `json:"role"`
+}
+
+type TeamResponse struct {
+	Team Team `json:"team"`
+}
+
+type TeamsResponse struct {
+	Teams []Team `json:"teams"`
+}
+
+// isFacilitator reports whether userID may run the room: its owner or an
+// admin of its team.
+func isFacilitator(game *Game, userID int) bool {
+	return userID == game.admin || game.teamAdmins[userID]
+}
+
+func findTeam(database *sql.DB, teamUUID string) (Team, error) {
+	if !isValidUUID(teamUUID) {
+		return Team{}, errTeamNotFound
+	}
+	var team Team
+	var name sql.NullString
+	err := database.QueryRow("SELECT id, uuid, name, created_at FROM teams WHERE uuid = $1", teamUUID).Scan(&team.ID, &team.UUID, &name, &team.CreatedAt)
+	if err == sql.ErrNoRows {
+		return Team{}, errTeamNotFound
+	}
+	if err != nil {
+		return Team{}, err
+	}
+	team.Name = name.String
+	return team, nil
+}
+
+// teamRole returns the role of userID in the team, or "" when they are not
+// a member.
+func teamRole(database *sql.DB, teamID int, userID int) (string, error) {
+	var role string
+	err := database.QueryRow("SELECT role FROM team_members WHERE team_id = $1 AND user_id = $2", teamID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// requireTeamRole returns the id of userUUID if they are a member of the
+// team, and an admin when admin is set.
+func requireTeamRole(database *sql.DB, team Team, userUUID string, admin bool) (int, error) {
+	userID, err := findUserID(database, userUUID)
+	if err != nil {
+		return 0, err
+	}
+	role, err := teamRole(database, team.ID, userID)
+	if err != nil {
+		return 0, err
+	}
+	if role == "" {
+		return 0, errNotTeamMember
+	}
+	if admin && role != teamRoleAdmin {
+		return 0, errForbidden
+	}
+	return userID, nil
+}
+
+// findMemberTeam resolves the team a room is created in or moved to. Only
+// its members may put rooms in it.
+func findMemberTeam(database *sql.DB, teamUUID string, userUUID string) (Team, error) {
+	team, err := findTeam(database, teamUUID)
+	if err != nil {
+		return Team{}, err
+	}
+	if _, err := requireTeamRole(database, team, userUUID, false); err != nil {
+		return Team{}, err
+	}
+	return team, nil
+}
+
+func fetchTeamMembers(database *sql.DB, teamID int) ([]TeamMember, error) {
+	rows, err := database.Query(`SELECT u.uuid, u.name, tm.role, tm.created_at
+		FROM team_members tm
+		JOIN users u ON u.id = tm.user_id
+		WHERE tm.team_id = $1
+		ORDER BY tm.role, u.name, u.id`, teamID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching team members: %v", err)
+	}
+	defer rows.Close()
+
+	members := []TeamMember{}
+	for rows.Next() {
+		var member TeamMember
+		var name sql.NullString
+		if err := rows.Scan(&member.UserUUID, &name, &member.Role, &member.JoinedAt); err != nil {
+			return nil, fmt.Errorf("error scanning team member: %v", err)
+		}
+		member.Name = name.String
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func fetchTeamsForUser(database *sql.DB, userID int) ([]Team, error) {
+	rows, err := database.Query(`SELECT t.id, t.uuid, t.name, t.created_at
+		FROM teams t
+		JOIN team_members tm ON tm.team_id = t.id AND tm.user_id = $1
+		ORDER BY t.name, t.id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching teams: %v", err)
+	}
+	defer rows.Close()
+
+	teams := []Team{}
+	for rows.Next() {
+		var team Team
+		var name sql.NullString
+		if err := rows.Scan(&team.ID, &team.UUID, &name, &team.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning team: %v", err)
+		}
+		team.Name = name.String
+		teams = append(teams, team)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range teams {
+		if teams[i].Members, err = fetchTeamMembers(database, teams[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return teams, nil
+}
+
+// fetchTeamRooms lists every room of the team, most recently active first.
+func fetchTeamRooms(database *sql.DB, teamID int) ([]RoomSummary, error) {
+	if err := roomActivity.flush(database); err != nil {
+		log.Printf("Error flushing room activity: %v", err)
+	}
+
+	rows, err := database.Query(`SELECT r.uuid, r.name, r.lastActive, r.archived_at IS NOT NULL, r.persistent, COUNT(ru.user_id)
+		FROM rooms r
+		LEFT JOIN room_users ru ON ru.room_id = r.id
+		WHERE r.team_id = $1
+		GROUP BY r.id
+		ORDER BY r.lastActive DESC`, teamID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching team rooms: %v", err)
+	}
+	defer rows.Close()
+
+	rooms := []RoomSummary{}
+	for rows.Next() {
+		var room RoomSummary
+		var name sql.NullString
+		if err := rows.Scan(&room.RoomUUID, &name, &room.LastActive, &room.Archived, &room.Persistent, &room.Members); err != nil {
+			return nil, fmt.Errorf("error scanning team room: %v", err)
+		}
+		room.Name = name.String
+		rooms = append(rooms, room)
+	}
+	return rooms, rows.Err()
+}
+
+// refreshTeamAdmins loads the admins of the room's team, who facilitate the
+// room, and marks the ones playing as admins.
+func refreshTeamAdmins(database *sql.DB, game *Game) error {
+	admins := map[int]bool{}
+	if game.teamID != 0 {
+		rows, err := database.Query("SELECT user_id FROM team_members WHERE team_id = $1 AND role = $2", game.teamID, teamRoleAdmin)
+		if err != nil {
+			return fmt.Errorf("error fetching team admins: %v", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var userID int
+			if err := rows.Scan(&userID); err != nil {
+				return fmt.Errorf("error scanning team admin: %v", err)
+			}
+			admins[userID] = true
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	for _, player := range game.Players {
+		if admins[player.ID] {
+			player.Admin = true
+		} else if game.teamAdmins[player.ID] && player.ID != game.admin {
+			player.Admin = false
+		}
+	}
+	game.teamAdmins = admins
+	return nil
+}
+
+// teamGames returns the loaded rooms of a team.
+func teamGames(teamID int) []*Game {
+	gamesMu.Lock()
+	defer gamesMu.Unlock()
+
+	var loaded []*Game
+	for _, game := range games {
+		if game.teamID == teamID {
+			loaded = append(loaded, game)
+		}
+	}
+	return loaded
+}
+
+// refreshTeamRooms reloads the team admins of the loaded rooms of a team
+// after its members change.
+func refreshTeamRooms(database *sql.DB, teamID int) {
+	for _, game := range teamGames(teamID) {
+		if err := refreshTeamAdmins(database, game); err != nil {
+			log.Printf("Error loading team admins of room %s: %v", game.roomUUID, err)
+			continue
+		}
+		sendGameState(game)
+	}
+}
+
+// setRoomTeam moves the room to a team, or out of its team when team is nil.
+func setRoomTeam(database *sql.DB, game *Game, team *Team) error {
+	teamID, teamUUID := 0, ""
+	if team != nil {
+		teamID, teamUUID = team.ID, team.UUID
+	}
+	if _, err := database.Exec("UPDATE rooms SET team_id = NULLIF($1, 0) WHERE id = $2", teamID, game.roomID); err != nil {
+		return err
+	}
+
+	game.teamID, game.teamUUID = teamID, teamUUID
+	if err := refreshTeamAdmins(database, game); err != nil {
+		return err
+	}
+	sendGameState(game)
+	return nil
+}
+
+// setTeamMember adds userID to the team or changes their role, keeping at
+// least one admin.
+func setTeamMember(database *sql.DB, team Team, userID int, role string) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if role != teamRoleAdmin {
+		if err := ensureOtherTeamAdmin(tx, team.ID, userID); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`INSERT INTO team_members (team_id, user_id, role) VALUES ($1, $2, $3)
+		ON CONFLICT (team_id, user_id) DO UPDATE SET role = EXCLUDED.role`, team.ID, userID, role)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func removeTeamMember(database *sql.DB, team Team, userID int) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := ensureOtherTeamAdmin(tx, team.ID, userID); err != nil {
+		return err
+	}
+	result, err := tx.Exec("DELETE FROM team_members WHERE team_id = $1 AND user_id = $2", team.ID, userID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return errNotTeamMember
+	}
+	return tx.Commit()
+}
+
+// ensureOtherTeamAdmin fails when userID is the last admin of the team. The
+// admins are locked so two demotions cannot both pass.
+func ensureOtherTeamAdmin(tx *sql.Tx, teamID int, userID int) error {
+	rows, err := tx.Query("SELECT user_id FROM team_members WHERE team_id = $1 AND role = $2 FOR UPDATE", teamID, teamRoleAdmin)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	others, isAdmin := 0, false
+	for rows.Next() {
+		var adminID int
+		if err := rows.Scan(&adminID); err != nil {
+			return err
+		}
+		if adminID == userID {
+			isAdmin = true
+		} else {
+			others++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if isAdmin && others == 0 {
+		return errLastTeamAdmin
+	}
+	return nil
+}
+
+func apiCreateTeam(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CreateTeamRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+		name := strings.TrimSpace(req.Name)
+		if name == "" {
+			handleError(w, errMissingField("name"))
+			return
+		}
+		if utf8.RuneCountInString(name) > 255 {
+			handleError(w, errInvalidField("name", "name must be at most 255 characters"))
+			return
+		}
+
+		userID, err := findUserID(database, req.UserUUID)
+		if handleError(w, err) {
+			return
+		}
+
+		tx, err := database.Begin()
+		if handleError(w, err) {
+			return
+		}
+		defer tx.Rollback()
+
+		// The creator is the first admin of the team
+		team := Team{UUID: generateUuid(), Name: name}
+		err = tx.QueryRow("INSERT INTO teams (uuid, name) VALUES ($1, $2) RETURNING id, created_at", team.UUID, team.Name).Scan(&team.ID, &team.CreatedAt)
+		if handleError(w, err) {
+			return
+		}
+		_, err = tx.Exec("INSERT INTO team_members (team_id, user_id, role) VALUES ($1, $2, $3)", team.ID, userID, teamRoleAdmin)
+		if handleError(w, err) {
+			return
+		}
+		if err := tx.Commit(); handleError(w, err) {
+			return
+		}
+
+		team.Members, err = fetchTeamMembers(database, team.ID)
+		if handleError(w, err) {
+			return
+		}
+		sendResponseWithStatus(w, http.StatusCreated, TeamResponse{Team: team})
+	}
+}
+
+func apiGetTeam(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		team, err := findTeam(database, mux.Vars(r)["teamUUID"])
+		if handleError(w, err) {
+			return
+		}
+		if _, err := requireTeamRole(database, team, r.URL.Query().Get("userUUID"), false); handleError(w, err) {
+			return
+		}
+
+		team.Members, err = fetchTeamMembers(database, team.ID)
+		if handleError(w, err) {
+			return
+		}
+		sendResponse(w, TeamResponse{Team: team})
+	}
+}
+
+func apiDeleteTeam(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		team, err := findTeam(database, mux.Vars(r)["teamUUID"])
+		if handleError(w, err) {
+			return
+		}
+		if _, err := requireTeamRole(database, team, r.URL.Query().Get("userUUID"), true); handleError(w, err) {
+			return
+		}
+
+		// Rooms of the team go back to being run by their owners only
+		if _, err := database.Exec("DELETE FROM teams WHERE id = $1", team.ID); handleError(w, err) {
+			return
+		}
+		for _, game := range teamGames(team.ID) {
+			game.teamID, game.teamUUID = 0, ""
+			if err := refreshTeamAdmins(database, game); err != nil {
+				log.Printf("Error loading team admins of room %s: %v", game.roomUUID, err)
+			}
+			sendGameState(game)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func apiListUserTeams(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := findUserID(database, mux.Vars(r)["userUUID"])
+		if handleError(w, err) {
+			return
+		}
+
+		teams, err := fetchTeamsForUser(database, userID)
+		if handleError(w, err) {
+			return
+		}
+		sendResponse(w, TeamsResponse{Teams: teams})
+	}
+}
+
+func apiSetTeamMember(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		var req TeamMemberRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+		if req.Role == "" {
+			req.Role = teamRoleMember
+		}
+		if req.Role != teamRoleAdmin && req.Role != teamRoleMember {
+			handleError(w, errInvalidField("role", `role must be "admin" or "member"`))
+			return
+		}
+
+		team, err := findTeam(database, vars["teamUUID"])
+		if handleError(w, err) {
+			return
+		}
+		if _, err := requireTeamRole(database, team, req.UserUUID, true); handleError(w, err) {
+			return
+		}
+		memberID, err := findUserID(database, vars["memberUUID"])
+		if handleError(w, err) {
+			return
+		}
+
+		if err := setTeamMember(database, team, memberID, req.Role); handleError(w, err) {
+			return
+		}
+		refreshTeamRooms(database, team.ID)
+
+		team.Members, err = fetchTeamMembers(database, team.ID)
+		if handleError(w, err) {
+			return
+		}
+		sendResponse(w, TeamResponse{Team: team})
+	}
+}
+
+func apiRemoveTeamMember(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		team, err := findTeam(database, vars["teamUUID"])
+		if handleError(w, err) {
+			return
+		}
+
+		// Members can leave on their own; admins remove anybody
+		userUUID := r.URL.Query().Get("userUUID")
+		if userUUID != vars["memberUUID"] {
+			if _, err := requireTeamRole(database, team, userUUID, true); handleError(w, err) {
+				return
+			}
+		}
+		memberID, err := findUserID(database, vars["memberUUID"])
+		if handleError(w, err) {
+			return
+		}
+
+		if err := removeTeamMember(database, team, memberID); handleError(w, err) {
+			return
+		}
+		refreshTeamRooms(database, team.ID)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func apiListTeamRooms(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		team, err := findTeam(database, mux.Vars(r)["teamUUID"])
+		if handleError(w, err) {
+			return
+		}
+		if _, err := requireTeamRole(database, team, r.URL.Query().Get("userUUID"), false); handleError(w, err) {
+			return
+		}
+
+		rooms, err := fetchTeamRooms(database, team.ID)
+		if handleError(w, err) {
+			return
+		}
+		sendResponse(w, RoomListResponse{Rooms: rooms})
+	}
+}
+
+func apiTeamAnalytics(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		team, err := findTeam(database, mux.Vars(r)["teamUUID"])
+		if handleError(w, err) {
+			return
+		}
+		if _, err := requireTeamRole(database, team, r.URL.Query().Get("userUUID"), false); handleError(w, err) {
+			return
+		}
+		from, to, err := parseAnalyticsPeriod(r)
+		if handleError(w, err) {
+			return
+		}
+
+		report, err := buildAnalytics(database, analyticsScope{teamID: team.ID}, from, to)
+		if handleError(w, err) {
+			return
+		}
+		report.TeamUUID = &team.UUID
+
+		sendResponse(w, report)
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+const maxTemplateIssues = 100
+
+var errTemplateNotFound = newAPIError(http.StatusNotFound, "template_not_found", "Template not found")
+
+// RoomTemplate is a saved room configuration for recurring ceremonies.
+// Rooms created from it get its name, deck, settings and issues.
+type RoomTemplate struct {
+	ID        int             `json:"-"`
+	UUID      string          `json:"uuid"`
+	Name      string          `json:"name"`
+	RoomName  string          `json:"roomName"`
+	Deck      []CardOption    `json:"deck"`
+	Settings  RoomSettings    `json:"settings"`
+	Issues    []TemplateIssue `json:"issues"`
+	CreatedAt time.Time       `json:"createdAt"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+type TemplateIssue struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Link        string `json:"link"`
+}
+
+// TemplateRequest creates or replaces a template. When RoomUUID is set the
+// room configuration is copied first and the other fields override it.
+type TemplateRequest struct {
+	Name     string             `json:"name"`
+	RoomUUID string             `json:"roomUUID"`
+	RoomName string             `json:"roomName"`
+	Deck     []CardOption       `json:"deck"`
+	Settings *RoomSettingsPatch `json:"settings"`
+	Issues   []TemplateIssue    `json:"issues"`
+}
+
+type TemplateResponse struct {
+	Template RoomTemplate `json:"template"`
+}
+
+type TemplatesResponse struct {
+	Templates []RoomTemplate `json:"templates"`
+}
+
+// expandRoomName fills the placeholders of a template room name, so
+// recurring rooms can be told apart.
+func expandRoomName(pattern string, now time.Time) string {
+	_, week := now.ISOWeek()
+	return strings.NewReplacer("{date}", now.Format("2006-01-02"), "{week}", fmt.Sprintf("%02d", week)).Replace(pattern)
+}
+
+// buildTemplate validates req into a template. userID must be the admin of
+// the room the template is copied from.
+func buildTemplate(database *sql.DB, userID int, req TemplateRequest) (RoomTemplate, error) {
+	template := RoomTemplate{
+		Name:     strings.TrimSpace(req.Name),
+		Settings: defaultRoomSettings(),
+		Deck:     []CardOption{},
+		Issues:   []TemplateIssue{},
+	}
+	if template.Name == "" {
+		return RoomTemplate{}, errMissingField("name")
+	}
+	if utf8.RuneCountInString(template.Name) > 255 {
+		return RoomTemplate{}, errInvalidField("name", "name must be at most 255 characters")
+	}
+
+	if req.RoomUUID != "" {
+		game, err := loadGame(database, req.RoomUUID)
+		if err != nil {
+			return RoomTemplate{}, err
+		}
+		if !isFacilitator(game, userID) {
+			return RoomTemplate{}, errForbidden
+		}
+		template.RoomName = game.name
+		template.Deck = append(template.Deck, game.deck...)
+		template.Settings = game.settings
+		for _, issue := range game.issues {
+			template.Issues = append(template.Issues, TemplateIssue{Title: issue.Title, Description: issue.Description, Link: issue.Link})
+		}
+	}
+
+	if req.RoomName != "" {
+		template.RoomName = req.RoomName
+	}
+	// Placeholders expand to a fixed length, so any date will do
+	if utf8.RuneCountInString(expandRoomName(template.RoomName, time.Now())) > 255 {
+		return RoomTemplate{}, errInvalidField("roomName", "roomName must be at most 255 characters once {date} and {week} are filled in")
+	}
+	if req.Deck != nil {
+		template.Deck = req.Deck
+	}
+	if req.Settings != nil {
+		template.Settings = req.Settings.apply(template.Settings)
+	}
+	if err := template.Settings.validate(); err != nil {
+		return RoomTemplate{}, err
+	}
+	if req.Issues != nil {
+		template.Issues = req.Issues
+	}
+	if len(template.Issues) > maxTemplateIssues {
+		return RoomTemplate{}, errInvalidField("issues", fmt.Sprintf("A template has at most %d issues", maxTemplateIssues))
+	}
+	for i, issue := range template.Issues {
+		if strings.TrimSpace(issue.Title) == "" {
+			return RoomTemplate{}, errInvalidField("issues", fmt.Sprintf("Issue %d has no title", i+1))
+		}
+		if utf8.RuneCountInString(issue.Title) > 255 {
+			return RoomTemplate{}, errInvalidField("issues", fmt.Sprintf("Title of issue %d is longer than 255 characters", i+1))
+		}
+	}
+	return template, nil
+}
+
+func scanTemplate(row interface{ Scan(...interface{}) error }) (RoomTemplate, error) {
+	var template RoomTemplate
+	var roomName sql.NullString
+	var deck, settings, issues []byte
+	err := row.Scan(&template.ID, &template.UUID, &template.Name, &roomName, &deck, &settings, &issues, &template.CreatedAt, &template.UpdatedAt)
+	if err != nil {
+		return RoomTemplate{}, err
+	}
+	template.RoomName = roomName.String
+	template.Settings = parseRoomSettings(settings)
+	if err := json.Unmarshal(deck, &template.Deck); err != nil || template.Deck == nil {
+		template.Deck = []CardOption{}
+	}
+	if err := json.Unmarshal(issues, &template.Issues); err != nil || template.Issues == nil {
+		template.Issues = []TemplateIssue{}
+	}
+	return template, nil
+}
+
+const templateColumns = "id, uuid, name, room_name, deck, settings, issues, created_at, updated_at"
+
+func fetchTemplates(database *sql.DB, ownerID int) ([]RoomTemplate, error) {
+	rows, err := database.Query("SELECT "+templateColumns+" FROM room_templates WHERE owner_id = $1 ORDER BY name, id", ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching templates: %v", err)
+	}
+	defer rows.Close()
+
+	templates := []RoomTemplate{}
+	for rows.Next() {
+		template, err := scanTemplate(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning template: %v", err)
+		}
+		templates = append(templates, template)
+	}
+	return templates, rows.Err()
+}
+
+// fetchTemplate returns a template of ownerID. Templates of other users are
+// reported as not found.
+func fetchTemplate(database *sql.DB, ownerID int, templateUUID string) (RoomTemplate, error) {
+	if !isValidUUID(templateUUID) {
+		return RoomTemplate{}, errTemplateNotFound
+	}
+	row := database.QueryRow("SELECT "+templateColumns+" FROM room_templates WHERE owner_id = $1 AND uuid = $2", ownerID, templateUUID)
+	template, err := scanTemplate(row)
+	if err == sql.ErrNoRows {
+		return RoomTemplate{}, errTemplateNotFound
+	}
+	return template, err
+}
+
+// saveTemplate inserts the template, or replaces it when it already has an id.
+func saveTemplate(database *sql.DB, ownerID int, template *RoomTemplate) error {
+	deck, err := json.Marshal(template.Deck)
+	if err != nil {
+		return err
+	}
+	settings, err := json.Marshal(template.Settings)
+	if err != nil {
+		return err
+	}
+	issues, err := json.Marshal(template.Issues)
+	if err != nil {
+		return err
+	}
+
+	if template.ID == 0 {
+		template.UUID = generateUuid()
+		return database.QueryRow(`INSERT INTO room_templates (uuid, owner_id, name, room_name, deck, settings, issues)
+			VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at, updated_at`,
+			template.UUID, ownerID, template.Name, template.RoomName, deck, settings, issues).Scan(&template.ID, &template.CreatedAt, &template.UpdatedAt)
+	}
+	return database.QueryRow(`UPDATE room_templates SET name = $1, room_name = $2, deck = $3, settings = $4, issues = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $6 AND owner_id = $7 RETURNING updated_at`,
+		template.Name, template.RoomName, deck, settings, issues, template.ID, ownerID).Scan(&template.UpdatedAt)
+}
+
+// applyTemplate fills a room request from the template of its user. Fields
+// set in the request win over the template.
+func applyTemplate(database *sql.DB, req RoomRequest) (RoomRequest, RoomSettings, []TemplateIssue, error) {
+	userID, err := findUserID(database, req.UserUUID)
+	if err != nil {
+		return req, RoomSettings{}, nil, err
+	}
+	template, err := fetchTemplate(database, userID, req.TemplateUUID)
+	if err != nil {
+		return req, RoomSettings{}, nil, err
+	}
+
+	if req.RoomName == "" {
+		req.RoomName = expandRoomName(template.RoomName, time.Now())
+	}
+	if len(req.Deck) == 0 {
+		req.Deck = template.Deck
+	}
+	// The legacy flags cannot tell false from missing, so they only switch
+	// the template settings on
+	settings := template.Settings
+	settings.AutoReveal = settings.AutoReveal || req.AutoShowCards
+	settings.Anonymous = settings.Anonymous || req.Anonymous
+	if req.KeepVoteAttribution != nil {
+		settings.KeepVoteAttribution = *req.KeepVoteAttribution
+	}
+	return req, settings, template.Issues, nil
+}
+
+func apiListTemplates(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := findUserID(database, mux.Vars(r)["userUUID"])
+		if handleError(w, err) {
+			return
+		}
+
+		templates, err := fetchTemplates(database, userID)
+		if handleError(w, err) {
+			return
+		}
+		sendResponse(w, TemplatesResponse{Templates: templates})
+	}
+}
+
+func apiGetTemplate(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		userID, err := findUserID(database, vars["userUUID"])
+		if handleError(w, err) {
+			return
+		}
+
+		template, err := fetchTemplate(database, userID, vars["templateUUID"])
+		if handleError(w, err) {
+			return
+		}
+		sendResponse(w, TemplateResponse{Template: template})
+	}
+}
+
+func apiCreateTemplate(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req TemplateRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+
+		userID, err := findUserID(database, mux.Vars(r)["userUUID"])
+		if handleError(w, err) {
+			return
+		}
+
+		template, err := buildTemplate(database, userID, req)
+		if handleError(w, err) {
+			return
+		}
+		if err := saveTemplate(database, userID, &template); handleError(w, err) {
+			return
+		}
+
+		sendResponseWithStatus(w, http.StatusCreated, TemplateResponse{Template: template})
+	}
+}
+
+func apiReplaceTemplate(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		var req TemplateRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+
+		userID, err := findUserID(database, vars["userUUID"])
+		if handleError(w, err) {
+			return
+		}
+		existing, err := fetchTemplate(database, userID, vars["templateUUID"])
+		if handleError(w, err) {
+			return
+		}
+
+		template, err := buildTemplate(database, userID, req)
+		if handleError(w, err) {
+			return
+		}
+		template.ID = existing.ID
+		template.UUID = existing.UUID
+		template.CreatedAt = existing.CreatedAt
+		if err := saveTemplate(database, userID, &template); handleError(w, err) {
+			return
+		}
+
+		sendResponse(w, TemplateResponse{Template: template})
+	}
+}
+
+func apiDeleteTemplate(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		userID, err := findUserID(database, vars["userUUID"])
+		if handleError(w, err) {
+			return
+		}
+		template, err := fetchTemplate(database, userID, vars["templateUUID"])
+		if handleError(w, err) {
+			return
+		}
+
+		if _, err := database.Exec("DELETE FROM room_templates WHERE id = $1", template.ID); handleError(w, err) {
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
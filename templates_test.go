@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpandRoomName(t *testing.T) {
+	tests := []struct {
+		pattern string
+		now     time.Time
+		want    string
+	}{
+		{"Refinement", time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC), "Refinement"},
+		{"Refinement {date}", time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC), "Refinement 2026-03-02"},
+		{"Sprint week {week}", time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC), "Sprint week 10"},
+		{"{week}/{week} {date}", time.Date(2026, 1, 7, 9, 0, 0, 0, time.UTC), "02/02 2026-01-07"},
+		{"New year {week}", time.Date(2027, 1, 1, 9, 0, 0, 0, time.UTC), "New year 53"},
+		{"{Date} {month}", time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC), "{Date} {month}"},
+		{"", time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC), ""},
+	}
+	for _, tt := range tests {
+		if got := expandRoomName(tt.pattern, tt.now); got != tt.want {
+			t.Errorf("expandRoomName(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestBuildTemplate(t *testing.T) {
+	admin := policyAdmin
+	tooLarge := maxRoomPlayers + 1
+	issues := func(n int) []TemplateIssue {
+		list := make([]TemplateIssue, n)
+		for i := range list {
+			list[i] = TemplateIssue{Title: fmt.Sprintf("Issue %d", i+1)}
+		}
+		return list
+	}
+
+	tests := []struct {
+		name      string
+		req       TemplateRequest
+		wantField string
+	}{
+		{"name only", TemplateRequest{Name: "Refinement"}, ""},
+		{"everything", TemplateRequest{Name: " Refinement ", RoomName: "Refinement {date}", Deck: []CardOption{{Value: "1", Label: "1"}},
+			Settings: &RoomSettingsPatch{RevealPolicy: &admin}, Issues: issues(maxTemplateIssues)}, ""},
+		{"blank name", TemplateRequest{Name: "  "}, "name"},
+		{"longest name", TemplateRequest{Name: strings.Repeat("é", 255)}, ""},
+		{"name too long", TemplateRequest{Name: strings.Repeat("é", 256)}, "name"},
+		{"longest room name", TemplateRequest{Name: "T", RoomName: strings.Repeat("é", 255)}, ""},
+		{"room name too long", TemplateRequest{Name: "T", RoomName: strings.Repeat("é", 256)}, "roomName"},
+		{"room name too long once the date is filled in", TemplateRequest{Name: "T", RoomName: strings.Repeat("é", 249) + "{date}"}, "roomName"},
+		{"room name with the week fits", TemplateRequest{Name: "T", RoomName: strings.Repeat("é", 249) + "{week}"}, ""},
+		{"invalid settings", TemplateRequest{Name: "T", Settings: &RoomSettingsPatch{MaxPlayers: &tooLarge}}, "maxPlayers"},
+		{"too many issues", TemplateRequest{Name: "T", Issues: issues(maxTemplateIssues + 1)}, "issues"},
+		{"issue without title", TemplateRequest{Name: "T", Issues: []TemplateIssue{{Title: "A"}, {Title: " ", Description: "B"}}}, "issues"},
+		{"issue title too long", TemplateRequest{Name: "T", Issues: []TemplateIssue{{Title: strings.Repeat("é", 256)}}}, "issues"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template, err := buildTemplate(nil, 1, tt.req)
+			if tt.wantField != "" {
+				if err == nil {
+					t.Fatalf("got template %+v, want an error on %s", template, tt.wantField)
+				}
+				if details, _ := toAPIError(err).Details.(map[string]string); details["field"] != tt.wantField {
+					t.Errorf("got error %v on %v, want one on %s", err, toAPIError(err).Details, tt.wantField)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if template.Name != strings.TrimSpace(tt.req.Name) {
+				t.Errorf("got name %q, want %q", template.Name, strings.TrimSpace(tt.req.Name))
+			}
+			if template.Deck == nil || template.Issues == nil {
+				t.Error("deck and issues must be empty lists, not null")
+			}
+		})
+	}
+
+	template, err := buildTemplate(nil, 1, TemplateRequest{Name: "T", Settings: &RoomSettingsPatch{RevealPolicy: &admin}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := defaultRoomSettings()
+	want.RevealPolicy = policyAdmin
+	if template.Settings != want {
+		t.Errorf("got settings %+v, want the defaults with the patch applied %+v", template.Settings, want)
+	}
+}
+
+func TestBuildTemplateFromRoom(t *testing.T) {
+	game := &Game{
+		roomUUID: generateUuid(),
+		admin:    1,
+		name:     "Refinement",
+		deck:     []CardOption{{Value: "1", Label: "1"}, {Value: "2", Label: "2"}},
+		settings: defaultRoomSettings(),
+		issues:   []Issue{{UUID: generateUuid(), Title: "Login", Link: "https://example.com/1"}},
+	}
+	game.settings.AutoReveal = true
+	gamesMu.Lock()
+	games[game.roomUUID] = game
+	gamesMu.Unlock()
+	t.Cleanup(func() {
+		gamesMu.Lock()
+		delete(games, game.roomUUID)
+		gamesMu.Unlock()
+	})
+
+	if _, err := buildTemplate(nil, 2, TemplateRequest{Name: "T", RoomUUID: game.roomUUID}); err != errForbidden {
+		t.Errorf("copying a room the user does not administer: got %v, want %v", err, errForbidden)
+	}
+
+	template, err := buildTemplate(nil, 1, TemplateRequest{Name: "T", RoomUUID: game.roomUUID, RoomName: "Refinement {week}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if template.RoomName != "Refinement {week}" || len(template.Deck) != 2 || !template.Settings.AutoReveal {
+		t.Errorf("got %+v, want the room copied with the request's room name", template)
+	}
+	if len(template.Issues) != 1 || template.Issues[0] != (TemplateIssue{Title: "Login", Link: "https://example.com/1"}) {
+		t.Errorf("got issues %+v, want the room's backlog", template.Issues)
+	}
+
+	// The copy must not share the room's deck
+	template.Deck[0].Label = "changed"
+	if game.deck[0].Label != "1" {
+		t.Error("changing the template deck changed the room")
+	}
+}
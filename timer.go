@@ -0,0 +1,285 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	timerRunning = "running"
+	timerPaused  = "paused"
+	timerExpired = "expired"
+
+	maxTimerSeconds = 3600
+)
+
+// timersMu guards the timer of every game, since timers expire on their own
+// goroutine.
+var timersMu sync.Mutex
+
+// roundTimer is the countdown of the current round. The server owns the
+// deadline; clients only display it.
+type roundTimer struct {
+	status     string
+	duration   time.Duration
+	deadline   time.Time
+	remaining  time.Duration // while paused
+	autoReveal bool
+	timer      *time.Timer
+}
+
+// TimerState is the countdown as sent to clients. Clients should count down
+// from RemainingSeconds at ServerTime instead of trusting their own clock
+// against Deadline.
+type TimerState struct {
+	Status           string     `json:"status"`
+	DurationSeconds  int        `json:"durationSeconds"`
+	RemainingSeconds int        `json:"remainingSeconds"`
+	Deadline         *time.Time `json:"deadline"`
+	ServerTime       time.Time  `json:"serverTime"`
+	AutoReveal       bool       `json:"autoReveal"`
+}
+
+// TimerRequest controls the countdown. action is start, pause, resume,
+// extend or cancel; durationSeconds is the length for start and the time
+// added for extend.
+type TimerRequest struct {
+	UserUUID        string `json:"userUUID"`
+	Action          string `json:"action"`
+	DurationSeconds int    `json:"durationSeconds"`
+	AutoReveal      *bool  `json:"autoReveal"`
+}
+
+func timerState(game *Game) *TimerState {
+	timersMu.Lock()
+	defer timersMu.Unlock()
+
+	t := game.timer
+	if t == nil {
+		return nil
+	}
+
+	now := time.Now()
+	state := &TimerState{
+		Status:          t.status,
+		DurationSeconds: int(t.duration / time.Second),
+		ServerTime:      now,
+		AutoReveal:      t.autoReveal,
+	}
+	switch t.status {
+	case timerRunning:
+		deadline := t.deadline
+		state.Deadline = &deadline
+		state.RemainingSeconds = int((deadline.Sub(now) + time.Second - 1) / time.Second)
+		if state.RemainingSeconds < 0 {
+			state.RemainingSeconds = 0
+		}
+	case timerPaused:
+		state.RemainingSeconds = int((t.remaining + time.Second - 1) / time.Second)
+	case timerExpired:
+		deadline := t.deadline
+		state.Deadline = &deadline
+	}
+	return state
+}
+
+func timerDuration(seconds int, field string) (time.Duration, error) {
+	if seconds <= 0 || seconds > maxTimerSeconds {
+		return 0, errInvalidField(field, "durationSeconds must be between 1 and 3600")
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func errTimerState(message string) error {
+	return newAPIError(http.StatusConflict, "invalid_timer_state", message)
+}
+
+// controlTimer applies an admin's timer action to the current round.
+func controlTimer(database *sql.DB, game *Game, userID int, req TimerRequest) error {
+	if game.archived {
+		return errRoomArchived
+	}
+	if !isFacilitator(game, userID) {
+		return errForbidden
+	}
+
+	timersMu.Lock()
+	defer timersMu.Unlock()
+
+	t := game.timer
+	now := time.Now()
+	switch req.Action {
+	case "start":
+		// Fields left out of the request fall back to the room's timer defaults
+		seconds := req.DurationSeconds
+		if seconds == 0 {
+			seconds = game.settings.TimerDefaultSeconds
+		}
+		duration, err := timerDuration(seconds, "durationSeconds")
+		if err != nil {
+			return err
+		}
+		autoReveal := game.settings.TimerAutoReveal
+		if req.AutoReveal != nil {
+			autoReveal = *req.AutoReveal
+		}
+		stopTimerLocked(game)
+		game.timer = &roundTimer{
+			status:     timerRunning,
+			duration:   duration,
+			deadline:   now.Add(duration),
+			autoReveal: autoReveal,
+		}
+	case "pause":
+		if t == nil || t.status != timerRunning {
+			return errTimerState("Only a running timer can be paused")
+		}
+		t.timer.Stop()
+		t.status = timerPaused
+		t.remaining = t.deadline.Sub(now)
+	case "resume":
+		if t == nil || t.status != timerPaused {
+			return errTimerState("Only a paused timer can be resumed")
+		}
+		t.status = timerRunning
+		t.deadline = now.Add(t.remaining)
+	case "extend":
+		extra, err := timerDuration(req.DurationSeconds, "durationSeconds")
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			return errTimerState("There is no timer to extend")
+		}
+		if t.status == timerExpired && game.showCards {
+			return errTimerState("The cards were already revealed")
+		}
+		t.duration += extra
+		switch t.status {
+		case timerRunning:
+			t.timer.Stop()
+			t.deadline = t.deadline.Add(extra)
+		case timerPaused:
+			t.remaining += extra
+		case timerExpired:
+			t.status = timerRunning
+			t.deadline = now.Add(extra)
+		}
+	case "cancel":
+		if t == nil {
+			return errTimerState("There is no timer to cancel")
+		}
+		stopTimerLocked(game)
+	case "":
+		return errMissingField("action")
+	default:
+		return errInvalidField("action", "action must be start, pause, resume, extend or cancel")
+	}
+
+	if t := game.timer; t != nil && t.status == timerRunning {
+		deadline := t.deadline
+		t.timer = time.AfterFunc(deadline.Sub(now), func() {
+			expireTimer(database, game, deadline)
+		})
+	}
+	return nil
+}
+
+// stopRoundTimer drops the countdown, e.g. when a new round starts.
+func stopRoundTimer(game *Game) {
+	timersMu.Lock()
+	defer timersMu.Unlock()
+	stopTimerLocked(game)
+}
+
+func stopTimerLocked(game *Game) {
+	if game.timer != nil && game.timer.timer != nil {
+		game.timer.timer.Stop()
+	}
+	game.timer = nil
+}
+
+// expireTimer runs when the deadline passes. deadline identifies the run of
+// the timer that scheduled it, so a timer that was paused, extended or
+// replaced in the meantime is left alone. It runs on the timer's goroutine,
+// so it waits for the room's lock like any websocket message.
+func expireTimer(database *sql.DB, game *Game, deadline time.Time) {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	timersMu.Lock()
+	t := game.timer
+	if t == nil || t.status != timerRunning || !t.deadline.Equal(deadline) {
+		timersMu.Unlock()
+		return
+	}
+	t.status = timerExpired
+	autoReveal := t.autoReveal
+	timersMu.Unlock()
+
+	gamesMu.Lock()
+	current := games[game.roomUUID] == game
+	gamesMu.Unlock()
+	if !current || game.archived {
+		return
+	}
+
+	if autoReveal && !game.showCards {
+		show := true
+		if _, err := setRoomShowCards(database, game.roomUUID, &show); err != nil {
+			log.Printf("Error revealing cards of room %s on timer expiry: %v", game.roomUUID, err)
+			sendGameState(game)
+		}
+		return
+	}
+	sendGameState(game)
+}
+
+func handleTimer(msg map[string]interface{}, game *Game, userID int, db *sql.DB) {
+	action, _ := msg["action"].(string)
+	duration, _ := msg["durationSeconds"].(float64)
+	var autoReveal *bool
+	if value, ok := msg["autoReveal"].(bool); ok {
+		autoReveal = &value
+	}
+
+	err := controlTimer(db, game, userID, TimerRequest{
+		Action:          action,
+		DurationSeconds: int(duration),
+		AutoReveal:      autoReveal,
+	})
+	if err != nil {
+		log.Printf("Error controlling timer: %v", err)
+	}
+}
+
+func apiControlTimer(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req TimerRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+
+		game, err := loadGame(database, mux.Vars(r)["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+		userID, err := findUserID(database, req.UserUUID)
+		if handleError(w, err) {
+			return
+		}
+
+		if err := controlTimer(database, game, userID, req); handleError(w, err) {
+			return
+		}
+		touchRoom(game)
+		sendGameState(game)
+
+		sendResponse(w, roundResource(game))
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpireTimerWaitsForTheRoom(t *testing.T) {
+	deadline := time.Now()
+	game := &Game{roomUUID: generateUuid(), timer: &roundTimer{status: timerRunning, deadline: deadline}}
+	gamesMu.Lock()
+	games[game.roomUUID] = game
+	gamesMu.Unlock()
+	t.Cleanup(func() {
+		gamesMu.Lock()
+		delete(games, game.roomUUID)
+		gamesMu.Unlock()
+	})
+
+	// A websocket message is being handled
+	game.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		expireTimer(nil, game, deadline)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("timer expired while the room was locked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if state := timerState(game); state.Status != timerRunning {
+		t.Fatalf("got status %s while the room was locked, want %s", state.Status, timerRunning)
+	}
+
+	game.mu.Unlock()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timer did not expire after the room was unlocked")
+	}
+	if state := timerState(game); state.Status != timerExpired {
+		t.Errorf("got status %s, want %s", state.Status, timerExpired)
+	}
+}
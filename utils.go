@@ -3,7 +3,13 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"io"
+	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -19,25 +25,128 @@ func generateRoomUUID() (string, error) {
 	return uuidStr, nil
 }
 
+// APIError is the error envelope returned by the REST handlers. Code is a
+// stable identifier clients can switch on; Message is meant for humans.
+type APIError struct {
+	Status  int         `json:"-"`
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+type ErrorResponse struct {
+	Error *APIError `json:"error"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+func newAPIError(status int, code string, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+func errMissingField(field string) *APIError {
+	apiErr := newAPIError(http.StatusBadRequest, "missing_field", field+" not provided")
+	apiErr.Details = map[string]string{"field": field}
+	return apiErr
+}
+
+func errInvalidField(field string, message string) *APIError {
+	apiErr := newAPIError(http.StatusBadRequest, "invalid_field", message)
+	apiErr.Details = map[string]string{"field": field}
+	return apiErr
+}
+
+var (
+	errRoomNotFound    = newAPIError(http.StatusNotFound, "room_not_found", "Room not found")
+	errUserNotFound    = newAPIError(http.StatusNotFound, "user_not_found", "User not found")
+	errIssueNotFound   = newAPIError(http.StatusNotFound, "issue_not_found", "Issue not found")
+	errForbidden       = newAPIError(http.StatusForbidden, "forbidden", "Only the room admin can perform this action")
+	errPlayerNotInRoom = newAPIError(http.StatusNotFound, "player_not_in_room", "Player is not a member of this room")
+)
+
+// toAPIError maps any error to the envelope sent to clients. Unknown errors
+// are logged and reported as a generic internal error so that database
+// messages never reach the browser.
+func toAPIError(err error) *APIError {
+	var apiErr *APIError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.Is(err, errRoomArchived):
+		return newAPIError(http.StatusConflict, "room_archived", "Room is archived and read-only")
+	case errors.Is(err, sql.ErrNoRows):
+		return newAPIError(http.StatusNotFound, "not_found", "Resource not found")
+	case errors.As(err, &syntaxErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return newAPIError(http.StatusBadRequest, "invalid_body", "Request body is not valid JSON")
+	case errors.As(err, &typeErr):
+		return errInvalidField(typeErr.Field, "Field "+typeErr.Field+" has the wrong type")
+	default:
+		log.Printf("Internal error: %v", err)
+		return newAPIError(http.StatusInternalServerError, "internal_error", "Internal server error")
+	}
+}
+
+func writeError(w http.ResponseWriter, apiErr *APIError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Status)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Error: apiErr,
+	})
+}
+
 func handleError(w http.ResponseWriter, err error) bool {
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, toAPIError(err))
 		return true
 	}
 	return false
 }
 
-func sendResponse(w http.ResponseWriter, data map[string]interface{}) {
+func sendResponse(w http.ResponseWriter, data interface{}) {
+	sendResponseWithStatus(w, http.StatusOK, data)
+}
+
+func sendResponseWithStatus(w http.ResponseWriter, status int, data interface{}) {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		handleError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	w.Write(jsonData)
 }
 
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// allowRate is a sliding window limit: it reports whether userID may send
+// another message now, and records the send when it may. sent holds the send
+// times of every user; a limit of 0 disables the check.
+func allowRate(sent map[int][]time.Time, userID int, now time.Time, limit int, window time.Duration) bool {
+	times := sent[userID]
+	for len(times) > 0 && now.Sub(times[0]) >= window {
+		times = times[1:]
+	}
+	if limit > 0 && len(times) >= limit {
+		sent[userID] = times
+		return false
+	}
+	sent[userID] = append(times, now)
+	return true
+}
+
 func getUserIDFromUUID(db *sql.DB, uuid string) (int, error) {
 	var id int
 	err := db.QueryRow("SELECT id FROM users WHERE uuid = $1", uuid).Scan(&id)
@@ -55,3 +164,44 @@ func getRoomIDFromUUID(db *sql.DB, uuid string) (int, error) {
 	}
 	return id, nil
 }
+
+// findRoomID is getRoomIDFromUUID for handlers: it validates the input and
+// reports unknown rooms as room_not_found.
+func findRoomID(db *sql.DB, uuid string) (int, error) {
+	if uuid == "" {
+		return 0, errMissingField("roomUUID")
+	}
+	if !isValidUUID(uuid) {
+		return 0, errRoomNotFound
+	}
+	id, err := getRoomIDFromUUID(db, uuid)
+	if err == sql.ErrNoRows {
+		return 0, errRoomNotFound
+	}
+	return id, err
+}
+
+// findUserID is getUserIDFromUUID for handlers: it validates the input and
+// reports unknown users as user_not_found.
+func findUserID(db *sql.DB, uuid string) (int, error) {
+	if uuid == "" {
+		return 0, errMissingField("userUUID")
+	}
+	if !isValidUUID(uuid) {
+		return 0, errUserNotFound
+	}
+	id, err := getUserIDFromUUID(db, uuid)
+	if err == sql.ErrNoRows {
+		return 0, errUserNotFound
+	}
+	return id, err
+}
+
+func isValidUUID(value string) bool {
+	_, err := uuid.Parse(value)
+	return err == nil
+}
+
+func decodeJSON(r *http.Request, v interface{}) error {
+	return json.NewDecoder(r.Body).Decode(v)
+}
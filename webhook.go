@@ -0,0 +1,621 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	eventRoundRevealed  = "round.revealed"
+	eventEstimateAgreed = "estimate.agreed"
+	eventIssueAdded     = "issue.added"
+	eventPlayerJoined   = "player.joined"
+	eventPlayerLeft     = "player.left"
+	eventPing           = "ping"
+)
+
+var webhookEvents = []string{
+	eventRoundRevealed,
+	eventEstimateAgreed,
+	eventIssueAdded,
+	eventPlayerJoined,
+	eventPlayerLeft,
+}
+
+type Webhook struct {
+	ID        int       `json:"-"`
+	UUID      string    `json:"uuid"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type WebhookDelivery struct {
+	UUID       string    `json:"uuid"`
+	Event      string    `json:"event"`
+	Attempt    int       `json:"attempt"`
+	StatusCode *int      `json:"statusCode"`
+	Error      string    `json:"error,omitempty"`
+	Success    bool      `json:"success"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// WebhookEvent is the JSON body POSTed to webhook URLs. The body is signed
+// with HMAC-SHA256 using the webhook secret and the hex digest is sent in
+// the X-Webhook-Signature header as "sha256=<digest>".
+type WebhookEvent struct {
+	ID         string      `json:"id"`
+	Event      string      `json:"event"`
+	OccurredAt time.Time   `json:"occurredAt"`
+	Room       WebhookRoom `json:"room"`
+	Data       interface{} `json:"data"`
+}
+
+type WebhookRoom struct {
+	RoomUUID string `json:"roomUUID"`
+	Name     string `json:"name"`
+}
+
+// WebhookVote has no userUUID or name for anonymous rooms that discard
+// attribution.
+type WebhookVote struct {
+	UserUUID string `json:"userUUID,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Vote     string `json:"vote"`
+}
+
+type RoundRevealedData struct {
+	Votes []WebhookVote `json:"votes"`
+	Stats RoundStats    `json:"stats"`
+}
+
+type EstimateAgreedData struct {
+	Issue Issue `json:"issue"`
+}
+
+type PlayerEventData struct {
+	UserUUID string `json:"userUUID"`
+	Name     string `json:"name"`
+}
+
+type CreateWebhookRequest struct {
+	UserUUID string   `json:"userUUID"`
+	URL      string   `json:"url"`
+	Events   []string `json:"events"`
+}
+
+type TestWebhookRequest struct {
+	UserUUID string `json:"userUUID"`
+}
+
+type WebhookResponse struct {
+	Webhook Webhook `json:"webhook"`
+}
+
+type WebhooksResponse struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+type WebhookDeliveriesResponse struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+type WebhookDeliveryResponse struct {
+	Delivery WebhookDelivery `json:"delivery"`
+}
+
+// webhookDispatcher delivers room events to the registered webhooks in the
+// background, retrying failed attempts with exponential backoff. Events wait
+// in a bounded queue for a fixed number of workers; when the queue is full
+// new events are dropped.
+type webhookDispatcher struct {
+	db          *sql.DB
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	queue       chan webhookJob
+}
+
+type webhookJob struct {
+	roomID  int
+	payload WebhookEvent
+}
+
+var webhooks *webhookDispatcher
+
+// allowPrivateWebhooks lets webhooks target loopback and private networks,
+// which is only useful when developing receivers locally. main sets it from
+// WEBHOOK_ALLOW_PRIVATE.
+var allowPrivateWebhooks = false
+
+var errPrivateWebhookTarget = errors.New("webhook target is not a public address")
+
+func newWebhookDispatcher(database *sql.DB) *webhookDispatcher {
+	return &webhookDispatcher{
+		db:          database,
+		client:      newWebhookClient(),
+		maxAttempts: envInt("WEBHOOK_MAX_ATTEMPTS", 5),
+		backoff:     time.Duration(envInt("WEBHOOK_BACKOFF_SECONDS", 2)) * time.Second,
+		queue:       make(chan webhookJob, max(envInt("WEBHOOK_QUEUE_SIZE", 256), 1)),
+	}
+}
+
+// newWebhookClient refuses to connect to non-public addresses. The check runs
+// on the address being dialed, after DNS resolution, so a host that passed
+// validation cannot later be pointed at the internal network.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			return checkWebhookIP(net.ParseIP(host))
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would be the dialed address and hide the real target
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}
+}
+
+func checkWebhookIP(ip net.IP) error {
+	if ip == nil {
+		return errPrivateWebhookTarget
+	}
+	if allowPrivateWebhooks {
+		return nil
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return errPrivateWebhookTarget
+	}
+	return nil
+}
+
+// start runs workers goroutines that deliver the queued events.
+func (d *webhookDispatcher) start(workers int) {
+	for i := 0; i < max(workers, 1); i++ {
+		go func() {
+			for job := range d.queue {
+				d.dispatch(job.roomID, job.payload)
+			}
+		}()
+	}
+}
+
+// emitRoomEvent notifies the room's webhooks. It never blocks the caller.
+func emitRoomEvent(game *Game, event string, data interface{}) {
+	if webhooks == nil || game == nil || game.roomID == 0 {
+		return
+	}
+
+	payload := WebhookEvent{
+		ID:         generateUuid(),
+		Event:      event,
+		OccurredAt: time.Now().UTC(),
+		Room:       WebhookRoom{RoomUUID: game.roomUUID, Name: game.name},
+		Data:       data,
+	}
+	select {
+	case webhooks.queue <- webhookJob{roomID: game.roomID, payload: payload}:
+	default:
+		log.Printf("Webhook queue is full, dropping %s for room %d", event, game.roomID)
+	}
+}
+
+func emitRoundRevealed(game *Game) {
+	data := RoundRevealedData{Votes: []WebhookVote{}}
+	var votes []string
+	for _, player := range game.Players {
+		if player.Voted && player.Vote != nil {
+			vote := WebhookVote{UserUUID: player.UUID, Name: player.Name, Vote: *player.Vote}
+			if discardsAttribution(game) {
+				vote = WebhookVote{Vote: *player.Vote}
+			}
+			data.Votes = append(data.Votes, vote)
+			votes = append(votes, *player.Vote)
+		}
+	}
+	data.Stats = computeRoundStats(votes)
+	emitRoomEvent(game, eventRoundRevealed, data)
+}
+
+func (d *webhookDispatcher) dispatch(roomID int, payload WebhookEvent) {
+	hooks, err := fetchWebhooks(d.db, roomID, true)
+	if err != nil {
+		log.Printf("Error fetching webhooks for room %d: %v", roomID, err)
+		return
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error marshalling webhook payload: %v", err)
+		return
+	}
+
+	for _, hook := range hooks {
+		if subscribed(hook, payload.Event) {
+			d.deliver(hook, payload, body)
+		}
+	}
+}
+
+func subscribed(hook Webhook, event string) bool {
+	if len(hook.Events) == 0 {
+		return true
+	}
+	for _, e := range hook.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *webhookDispatcher) deliver(hook Webhook, payload WebhookEvent, body []byte) {
+	for attempt := 1; attempt <= d.maxAttempts; attempt++ {
+		delivery := d.attempt(hook, payload, body, attempt)
+		if delivery.Success {
+			return
+		}
+		if attempt < d.maxAttempts {
+			time.Sleep(d.backoff * time.Duration(1<<(attempt-1)))
+		}
+	}
+	log.Printf("Webhook %s gave up on %s after %d attempts", hook.UUID, payload.Event, d.maxAttempts)
+}
+
+// attempt POSTs the payload once and records the outcome in webhook_deliveries.
+func (d *webhookDispatcher) attempt(hook Webhook, payload WebhookEvent, body []byte, attempt int) WebhookDelivery {
+	delivery := WebhookDelivery{
+		UUID:      generateUuid(),
+		Event:     payload.Event,
+		Attempt:   attempt,
+		CreatedAt: time.Now(),
+	}
+
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "planning-poker-webhooks/1.0")
+		req.Header.Set("X-Webhook-Event", payload.Event)
+		req.Header.Set("X-Webhook-Delivery", payload.ID)
+		req.Header.Set("X-Webhook-Signature", "sha256="+signPayload(hook.Secret, body))
+
+		var resp *http.Response
+		resp, err = d.client.Do(req)
+		if err == nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+			delivery.StatusCode = &resp.StatusCode
+			delivery.Success = resp.StatusCode >= 200 && resp.StatusCode < 300
+			if !delivery.Success {
+				delivery.Error = resp.Status
+			}
+		}
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+
+	_, err = d.db.Exec(`INSERT INTO webhook_deliveries (uuid, webhook_id, event, payload, attempt, status_code, error, success)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		delivery.UUID, hook.ID, delivery.Event, string(body), attempt, delivery.StatusCode, delivery.Error, delivery.Success)
+	if err != nil {
+		log.Printf("Error recording webhook delivery: %v", err)
+	}
+	return delivery
+}
+
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func generateWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func fetchWebhooks(db *sql.DB, roomID int, withSecrets bool) ([]Webhook, error) {
+	rows, err := db.Query("SELECT id, uuid, url, secret, events, created_at FROM webhooks WHERE room_id = $1 AND active ORDER BY id", roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := []Webhook{}
+	for rows.Next() {
+		var hook Webhook
+		var events string
+		if err := rows.Scan(&hook.ID, &hook.UUID, &hook.URL, &hook.Secret, &events, &hook.CreatedAt); err != nil {
+			return nil, err
+		}
+		hook.Events = []string{}
+		if events != "" {
+			hook.Events = strings.Split(events, ",")
+		}
+		if !withSecrets {
+			hook.Secret = ""
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, rows.Err()
+}
+
+func fetchWebhook(db *sql.DB, roomID int, webhookUUID string) (Webhook, error) {
+	hooks, err := fetchWebhooks(db, roomID, true)
+	if err != nil {
+		return Webhook{}, err
+	}
+	for _, hook := range hooks {
+		if hook.UUID == webhookUUID {
+			return hook, nil
+		}
+	}
+	return Webhook{}, newAPIError(http.StatusNotFound, "webhook_not_found", "Webhook not found")
+}
+
+func validateWebhookRequest(req CreateWebhookRequest) error {
+	parsed, err := url.Parse(req.URL)
+	if req.URL == "" {
+		return errMissingField("url")
+	}
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return errInvalidField("url", "url must be an absolute http or https URL")
+	}
+	if err := checkWebhookHost(parsed.Hostname()); err != nil {
+		return err
+	}
+
+	for _, event := range req.Events {
+		known := false
+		for _, e := range webhookEvents {
+			if e == event {
+				known = true
+				break
+			}
+		}
+		if !known {
+			apiErr := errInvalidField("events", "Unknown event "+event)
+			apiErr.Details = map[string]interface{}{"field": "events", "allowed": webhookEvents}
+			return apiErr
+		}
+	}
+	return nil
+}
+
+// checkWebhookHost resolves host and refuses it when any of its addresses is
+// loopback, link-local or private.
+func checkWebhookHost(host string) error {
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return errInvalidField("url", "url host cannot be resolved")
+	}
+	for _, ip := range ips {
+		if checkWebhookIP(ip) != nil {
+			return errInvalidField("url", "url must point to a public address")
+		}
+	}
+	return nil
+}
+
+func apiCreateWebhook(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CreateWebhookRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+		if err := validateWebhookRequest(req); handleError(w, err) {
+			return
+		}
+
+		roomID, err := findRoomID(database, mux.Vars(r)["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+		if _, err := requireRoomAdmin(database, roomID, req.UserUUID); handleError(w, err) {
+			return
+		}
+
+		secret, err := generateWebhookSecret()
+		if handleError(w, err) {
+			return
+		}
+
+		hook := Webhook{
+			UUID:   generateUuid(),
+			URL:    req.URL,
+			Events: req.Events,
+			Secret: secret,
+		}
+		if hook.Events == nil {
+			hook.Events = []string{}
+		}
+		err = database.QueryRow("INSERT INTO webhooks (uuid, room_id, url, secret, events) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at",
+			hook.UUID, roomID, hook.URL, hook.Secret, strings.Join(hook.Events, ",")).Scan(&hook.ID, &hook.CreatedAt)
+		if handleError(w, err) {
+			return
+		}
+
+		// The secret is only ever returned here
+		sendResponseWithStatus(w, http.StatusCreated, WebhookResponse{Webhook: hook})
+	}
+}
+
+func apiListWebhooks(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomID, err := findRoomID(database, mux.Vars(r)["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+		if _, err := requireRoomAdmin(database, roomID, r.URL.Query().Get("userUUID")); handleError(w, err) {
+			return
+		}
+
+		hooks, err := fetchWebhooks(database, roomID, false)
+		if handleError(w, err) {
+			return
+		}
+
+		sendResponse(w, WebhooksResponse{Webhooks: hooks})
+	}
+}
+
+func apiDeleteWebhook(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		roomID, err := findRoomID(database, vars["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+		if _, err := requireRoomAdmin(database, roomID, r.URL.Query().Get("userUUID")); handleError(w, err) {
+			return
+		}
+
+		hook, err := fetchWebhook(database, roomID, vars["webhookUUID"])
+		if handleError(w, err) {
+			return
+		}
+
+		// Deliveries are kept for the log, the webhook is only deactivated
+		_, err = database.Exec("UPDATE webhooks SET active = FALSE, updated_at = CURRENT_TIMESTAMP WHERE id = $1", hook.ID)
+		if handleError(w, err) {
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func apiListWebhookDeliveries(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		roomID, err := findRoomID(database, vars["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+		if _, err := requireRoomAdmin(database, roomID, r.URL.Query().Get("userUUID")); handleError(w, err) {
+			return
+		}
+
+		hook, err := fetchWebhook(database, roomID, vars["webhookUUID"])
+		if handleError(w, err) {
+			return
+		}
+
+		limit := 50
+		if value := r.URL.Query().Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 || parsed > 500 {
+				handleError(w, errInvalidField("limit", "limit must be between 1 and 500"))
+				return
+			}
+			limit = parsed
+		}
+
+		rows, err := database.Query(`SELECT uuid, event, attempt, status_code, error, success, created_at
+			FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2`, hook.ID, limit)
+		if handleError(w, err) {
+			return
+		}
+		defer rows.Close()
+
+		deliveries := []WebhookDelivery{}
+		for rows.Next() {
+			var delivery WebhookDelivery
+			var statusCode sql.NullInt64
+			var deliveryError sql.NullString
+			err := rows.Scan(&delivery.UUID, &delivery.Event, &delivery.Attempt, &statusCode, &deliveryError, &delivery.Success, &delivery.CreatedAt)
+			if handleError(w, err) {
+				return
+			}
+			if statusCode.Valid {
+				code := int(statusCode.Int64)
+				delivery.StatusCode = &code
+			}
+			delivery.Error = deliveryError.String
+			deliveries = append(deliveries, delivery)
+		}
+		if handleError(w, rows.Err()) {
+			return
+		}
+
+		sendResponse(w, WebhookDeliveriesResponse{Deliveries: deliveries})
+	}
+}
+
+// apiTestWebhook sends a single "ping" event synchronously and reports the
+// outcome, without retries.
+func apiTestWebhook(database *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		var req TestWebhookRequest
+		if err := decodeJSON(r, &req); handleError(w, err) {
+			return
+		}
+
+		roomID, err := findRoomID(database, vars["roomUUID"])
+		if handleError(w, err) {
+			return
+		}
+		if _, err := requireRoomAdmin(database, roomID, req.UserUUID); handleError(w, err) {
+			return
+		}
+
+		hook, err := fetchWebhook(database, roomID, vars["webhookUUID"])
+		if handleError(w, err) {
+			return
+		}
+
+		var roomName sql.NullString
+		database.QueryRow("SELECT name FROM rooms WHERE id = $1", roomID).Scan(&roomName)
+
+		payload := WebhookEvent{
+			ID:         generateUuid(),
+			Event:      eventPing,
+			OccurredAt: time.Now().UTC(),
+			Room:       WebhookRoom{RoomUUID: vars["roomUUID"], Name: roomName.String},
+			Data:       map[string]string{"message": fmt.Sprintf("Test delivery for webhook %s", hook.UUID)},
+		}
+		body, err := json.Marshal(payload)
+		if handleError(w, err) {
+			return
+		}
+
+		dispatcher := webhooks
+		if dispatcher == nil {
+			dispatcher = newWebhookDispatcher(database)
+		}
+		delivery := dispatcher.attempt(hook, payload, body, 1)
+
+		sendResponse(w, WebhookDeliveryResponse{Delivery: delivery})
+	}
+}
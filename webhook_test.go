@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+const webhookDeliveriesSchema = `CREATE TABLE webhook_deliveries (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	uuid TEXT, webhook_id INTEGER, event TEXT, payload TEXT, attempt INTEGER,
+	status_code INTEGER, error TEXT, success BOOLEAN DEFAULT FALSE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+)`
+
+// allowPrivateWebhooksForTest lets the dispatcher reach httptest servers,
+// which listen on loopback.
+func allowPrivateWebhooksForTest(t *testing.T) {
+	previous := allowPrivateWebhooks
+	allowPrivateWebhooks = true
+	t.Cleanup(func() { allowPrivateWebhooks = previous })
+}
+
+type webhookStub struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (s *webhookStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := http.StatusOK
+	if len(s.requests) < len(s.statuses) {
+		status = s.statuses[len(s.requests)]
+	}
+	s.requests = append(s.requests, r)
+	s.bodies = append(s.bodies, body)
+	w.WriteHeader(status)
+}
+
+func testDispatcher(t *testing.T, maxAttempts int) *webhookDispatcher {
+	return &webhookDispatcher{
+		db:          openTestDB(t, webhookDeliveriesSchema),
+		client:      newWebhookClient(),
+		maxAttempts: maxAttempts,
+		backoff:     time.Millisecond,
+	}
+}
+
+func testPayload(t *testing.T) (WebhookEvent, []byte) {
+	payload := WebhookEvent{ID: generateUuid(), Event: eventPing, OccurredAt: time.Now().UTC(), Data: map[string]string{"message": "hi"}}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload, body
+}
+
+func TestWebhookDeliverySignsAndRetries(t *testing.T) {
+	allowPrivateWebhooksForTest(t)
+	stub := &webhookStub{statuses: []int{http.StatusInternalServerError, http.StatusBadGateway}}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	d := testDispatcher(t, 5)
+	hook := Webhook{ID: 1, UUID: generateUuid(), URL: server.URL, Secret: "s3cret"}
+	payload, body := testPayload(t)
+	d.deliver(hook, payload, body)
+
+	if len(stub.requests) != 3 {
+		t.Fatalf("got %d attempts, want 3", len(stub.requests))
+	}
+	for i, req := range stub.requests {
+		if got, want := req.Header.Get("X-Webhook-Signature"), "sha256="+signPayload("s3cret", stub.bodies[i]); got != want {
+			t.Errorf("attempt %d: signature %q, want %q", i+1, got, want)
+		}
+		if req.Header.Get("X-Webhook-Event") != eventPing || req.Header.Get("X-Webhook-Delivery") != payload.ID {
+			t.Errorf("attempt %d: wrong event headers %v", i+1, req.Header)
+		}
+		if string(stub.bodies[i]) != string(body) {
+			t.Errorf("attempt %d: body %s, want %s", i+1, stub.bodies[i], body)
+		}
+	}
+
+	rows, err := d.db.Query("SELECT attempt, status_code, success FROM webhook_deliveries ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	want := []struct {
+		status  int
+		success bool
+	}{{500, false}, {502, false}, {200, true}}
+	i := 0
+	for ; rows.Next(); i++ {
+		var attempt, status int
+		var success bool
+		if err := rows.Scan(&attempt, &status, &success); err != nil {
+			t.Fatal(err)
+		}
+		if i >= len(want) || attempt != i+1 || status != want[i].status || success != want[i].success {
+			t.Errorf("delivery %d: attempt %d, status %d, success %v", i+1, attempt, status, success)
+		}
+	}
+	if i != len(want) {
+		t.Errorf("recorded %d deliveries, want %d", i, len(want))
+	}
+}
+
+func TestWebhookDeliveryGivesUp(t *testing.T) {
+	allowPrivateWebhooksForTest(t)
+	stub := &webhookStub{statuses: []int{500, 500, 500, 500}}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	d := testDispatcher(t, 3)
+	payload, body := testPayload(t)
+	d.deliver(Webhook{ID: 1, URL: server.URL}, payload, body)
+
+	if len(stub.requests) != 3 {
+		t.Errorf("got %d attempts, want 3", len(stub.requests))
+	}
+}
+
+func TestWebhookClientRefusesPrivateAddresses(t *testing.T) {
+	stub := &webhookStub{}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	// The host passes any earlier validation, the dialer still refuses it
+	d := testDispatcher(t, 1)
+	payload, body := testPayload(t)
+	delivery := d.attempt(Webhook{ID: 1, URL: server.URL}, payload, body, 1)
+
+	if delivery.Success || delivery.StatusCode != nil {
+		t.Errorf("delivery to %s succeeded", server.URL)
+	}
+	if len(stub.requests) != 0 {
+		t.Errorf("stub received %d requests", len(stub.requests))
+	}
+
+	_, err := newWebhookClient().Post(server.URL, "application/json", nil)
+	if !errors.Is(err, errPrivateWebhookTarget) {
+		t.Errorf("got error %v, want %v", err, errPrivateWebhookTarget)
+	}
+}
+
+func TestValidateWebhookRequestRejectsPrivateTargets(t *testing.T) {
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"http://localhost:8080/hook", false},
+		{"http://127.0.0.1/hook", false},
+		{"http://127.1.2.3/hook", false},
+		{"http://[::1]/hook", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"http://10.0.0.5/hook", false},
+		{"http://172.16.3.4/hook", false},
+		{"http://192.168.1.10/hook", false},
+		{"http://[fd00::1]/hook", false},
+		{"http://[fe80::1]/hook", false},
+		{"http://0.0.0.0/hook", false},
+		{"http://[::ffff:127.0.0.1]/hook", false},
+		{"ftp://93.184.216.34/hook", false},
+		{"https://93.184.216.34/hook", true},
+		{"https://[2606:4700::1111]/hook", true},
+	}
+	for _, tt := range tests {
+		err := validateWebhookRequest(CreateWebhookRequest{URL: tt.url})
+		if tt.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.url, err)
+		}
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("%s: accepted", tt.url)
+			} else if apiErr := toAPIError(err); apiErr.Status != http.StatusBadRequest {
+				t.Errorf("%s: got status %d, want 400", tt.url, apiErr.Status)
+			}
+		}
+	}
+}
+
+func TestEmitRoomEventDropsWhenQueueIsFull(t *testing.T) {
+	previous := webhooks
+	webhooks = &webhookDispatcher{queue: make(chan webhookJob, 1)}
+	t.Cleanup(func() { webhooks = previous })
+
+	game := &Game{roomID: 1, roomUUID: generateUuid()}
+	emitRoomEvent(game, eventIssueAdded, nil)
+	emitRoomEvent(game, eventIssueAdded, nil)
+
+	if len(webhooks.queue) != 1 {
+		t.Errorf("queue holds %d events, want 1", len(webhooks.queue))
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"log"
 	"net/http"
 	"sync"
@@ -25,31 +26,105 @@ func handleMessage(msg map[string]interface{}, game *Game, userUUID string, ws *
 	if err != nil {
 		log.Printf("Error getting user ID from UUID: %v", err)
 	}
+	if game.archived {
+		switch msg["type"] {
+		case "vote", "newIssue", "issueOrder", "updateIssue", "deleteIssue", "setEstimate", "selectIssue", "timer", "revote", "chat", "deleteChat", "comment", "deleteComment", "updateSettings":
+			// Archived rooms are read-only history
+			sendGameState(game, nil)
+			return
+		}
+	}
 	switch msg["type"] {
 	case "vote":
 		handleVote(msg, game, int(userID), db)
 		sendGameState(game, nil) // Enviar estado do jogo sem emojis
 	case "newPlayer":
 		handleNewPlayer(msg, game, int(userID), userUUID, ws)
+		if err := refreshScores(db, game); err != nil {
+			log.Printf("Error loading scores: %v", err)
+		}
+		if err := refreshObservers(db, game); err != nil {
+			log.Printf("Error loading observers: %v", err)
+		}
+		if err := refreshTeamAdmins(db, game); err != nil {
+			log.Printf("Error loading team admins: %v", err)
+		}
 		sendGameState(game, nil)
 	case "newAdmin":
 		handleNewAdmin(msg, game, int(userID), userUUID, ws)
+		if err := refreshScores(db, game); err != nil {
+			log.Printf("Error loading scores: %v", err)
+		}
+		if err := refreshObservers(db, game); err != nil {
+			log.Printf("Error loading observers: %v", err)
+		}
+		if err := refreshTeamAdmins(db, game); err != nil {
+			log.Printf("Error loading team admins: %v", err)
+		}
 		sendGameState(game, nil)
 	case "playerLeft":
 		handleLeaveRoom(game, int(userID))
 		sendGameState(game, nil)
 	case "emoji":
-		handleEmoji(msg, game, int(userID)) // A função `handleEmoji` já chama `sendGameState` com emojis
+		handleEmoji(msg, game, int(userID), ws) // Emojis are broadcast as their own event, without a gameState
 	case "newIssue":
-		handleNewIssue(msg, game, db)
+		handleNewIssue(msg, game, int(userID), db)
 		sendGameState(game, nil)
 	case "issueOrder":
-		handleIssueOrder(msg, game, db)
+		handleIssueOrder(msg, game, int(userID), db)
+		sendGameState(game, nil)
+	case "updateIssue":
+		handleUpdateIssue(msg, game, int(userID), db)
+		sendGameState(game, nil)
+	case "deleteIssue":
+		handleDeleteIssue(msg, game, int(userID), db)
+		sendGameState(game, nil)
+	case "setEstimate":
+		handleSetEstimate(msg, game, int(userID), db)
 		sendGameState(game, nil)
+	case "selectIssue":
+		handleSelectIssue(msg, game, int(userID), db)
+	case "timer":
+		handleTimer(msg, game, int(userID), db)
+		sendGameState(game, nil)
+	case "revote":
+		handleRevote(game, int(userID), db)
+	case "chat":
+		handleChat(msg, game, int(userID), ws, db) // Chat messages are broadcast as their own event
+	case "deleteChat":
+		handleDeleteChat(msg, game, int(userID), ws, db)
+	case "updateSettings":
+		handleUpdateSettings(msg, game, int(userID), db) // Broadcasts settingsChanged and the gameState itself
+	case "comment":
+		handleComment(msg, game, int(userID), db) // Comments are broadcast as their own event
+	case "deleteComment":
+		handleDeleteComment(msg, game, int(userID), db)
 	default:
 		sendGameState(game, nil)
 	}
-	game.lastActive = time.Now()
+	touchRoom(game)
+}
+
+// loadGame returns the in-memory game for the room, rehydrating it from the
+// database when it was never loaded or has been evicted.
+func loadGame(db *sql.DB, roomUUID string) (*Game, error) {
+	gamesMu.Lock()
+	defer gamesMu.Unlock()
+
+	game, exists := games[roomUUID]
+	if exists {
+		return game, nil
+	}
+
+	game, err := fetchGameFromDB(db, roomUUID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errRoomNotFound
+		}
+		return nil, err
+	}
+	games[roomUUID] = game
+	return game, nil
 }
 
 func sendGameState(game *Game, emojis ...[]EmojiMessage) {
@@ -72,69 +147,141 @@ func sendGameState(game *Game, emojis ...[]EmojiMessage) {
 		game.Players = []*Player{}
 	}
 	// Update showCards based on votes if autoShowCards is enabled
-	if game.autoShowCards {
-		allVoted := len(game.Players) > 0
+	if game.settings.AutoReveal {
+		allVoted := false
 		for _, player := range game.Players {
 			if player == nil {
 				log.Println("Player in Players slice is nil")
 				continue
 			}
+			if player.Observer {
+				continue
+			}
+			allVoted = true
 			if !player.Voted {
 				allVoted = false
 				break
 			}
 		}
-		if allVoted {
+		if allVoted && !game.showCards {
 			game.showCards = true
+			revealRound(game)
 		}
 	}
 
-	// Prepare the message to send to players
-	msg := map[string]interface{}{
-		"type":          "gameState",
-		"players":       game.Players,
-		"showCards":     game.showCards,
-		"autoShowCards": game.autoShowCards,
-		"roomUUID":      game.roomUUID,
-		"name":          game.name,
-		"admin":         game.admin,
-		"emojis":        emojiMessages, // Include the emojis in the game state
-		"deck":          game.deck,
-		"issues":        game.issues,
-	}
-
-	// Send the game state to each player
+	// Send the game state to each player. Every player gets their own
+	// message since votes are hidden from the others until the reveal.
 	for _, player := range game.Players {
 		if player == nil {
 			log.Println("Player is nil, skipping")
 			continue
 		}
+		sendToPlayer(player, gameStateMessage(game, player.ID, emojiMessages))
+	}
+}
 
-		// Try to send through all connections
-		for i, conn := range player.connections {
-			if conn == nil {
-				continue
-			}
+// broadcast sends the same message to every connection in the room.
+func broadcast(game *Game, msg interface{}) {
+	for _, player := range game.Players {
+		if player != nil {
+			sendToPlayer(player, msg)
+		}
+	}
+}
 
-			err := conn.WriteJSON(msg)
-			if err != nil {
-				log.Printf("Error in loop, writing JSON to WebSocket %d for player %d: %v", i, player.ID, err)
-				// Remove failed connection
-				player.connections[i] = nil
-			}
+func sendToPlayer(player *Player, msg interface{}) {
+	// Try to send through all connections
+	for i, conn := range player.connections {
+		if conn == nil {
+			continue
 		}
 
-		// Clean up nil connections
-		activeConns := make([]*websocket.Conn, 0)
-		for _, conn := range player.connections {
-			if conn != nil {
-				activeConns = append(activeConns, conn)
-			}
+		err := conn.WriteJSON(msg)
+		if err != nil {
+			log.Printf("Error in loop, writing JSON to WebSocket %d for player %d: %v", i, player.ID, err)
+			// Remove failed connection
+			player.connections[i] = nil
+		}
+	}
+
+	// Clean up nil connections
+	activeConns := make([]*websocket.Conn, 0)
+	for _, conn := range player.connections {
+		if conn != nil {
+			activeConns = append(activeConns, conn)
 		}
-		player.connections = activeConns
+	}
+	player.connections = activeConns
+}
+
+// roomState is the room as seen by someone who is not playing, such as an API
+// client: no vote is visible before the reveal.
+func roomState(game *Game) RoomState {
+	return roomStateFor(game, 0)
+}
+
+// roomStateFor is the room as seen by the player viewerID.
+func roomStateFor(game *Game, viewerID int) RoomState {
+	var currentIssue *string
+	if game.currentIssueUUID != "" {
+		currentIssue = &game.currentIssueUUID
+	}
+	var teamUUID *string
+	if game.teamUUID != "" {
+		teamUUID = &game.teamUUID
+	}
+
+	return RoomState{
+		Players:             visiblePlayers(game, viewerID),
+		ShowCards:           game.showCards,
+		AutoShowCards:       game.settings.AutoReveal,
+		RoomUUID:            game.roomUUID,
+		Name:                game.name,
+		Admin:               game.admin,
+		Deck:                game.deck,
+		Issues:              game.issues,
+		CurrentIssue:        currentIssue,
+		Timer:               timerState(game),
+		PreviousRounds:      previousRoundResults(game),
+		Anonymous:           game.settings.Anonymous,
+		KeepVoteAttribution: game.settings.KeepVoteAttribution,
+		AnonymousVotes:      anonymousVotes(game),
+		RecentEmojis:        recentEmojis(game),
+		Settings:            game.settings,
+		Persistent:          game.persistent,
+		Session:             game.session,
+		TeamUUID:            teamUUID,
 	}
 }
 
+func gameStateMessage(game *Game, viewerID int, emojiMessages []EmojiMessage) GameStateMessage {
+	return GameStateMessage{
+		Type:      "gameState",
+		RoomState: roomStateFor(game, viewerID),
+		Emojis:    emojiMessages, // Include the emojis in the game state
+	}
+}
+
+// visiblePlayers returns the players as viewerID may see them. Before the
+// reveal only the viewer's own vote is included; the others only show that
+// they voted. Anonymous rooms never include anybody else's vote.
+func visiblePlayers(game *Game, viewerID int) []*Player {
+	players := make([]*Player, 0, len(game.Players))
+	for _, player := range game.Players {
+		if player == nil {
+			continue
+		}
+		if player.ID == viewerID || (game.showCards && !game.settings.Anonymous) {
+			players = append(players, player)
+			continue
+		}
+		redacted := *player
+		redacted.Vote = nil
+		players = append(players, &redacted)
+	}
+	return players
+}
+
 func checkIfUserHasActiveConnections(game *Game, userID int) bool {
 	for _, player := range game.Players {
 		log.Printf("Checking player %d", player.ID)
@@ -197,31 +344,28 @@ func handleConnections(db *sql.DB) http.HandlerFunc {
 			}
 		}()
 
-		gamesMu.Lock()
-		game, gameExists := games[roomUUID]
-		if !gameExists {
-			game, err = fetchGameFromDB(db, roomUUID)
-			if err != nil {
-				log.Printf("Error fetching game from database: %v", err)
-				gamesMu.Unlock()
-				return
-			}
-			games[roomUUID] = game
+		game, err := loadGame(db, roomUUID)
+		if err != nil {
+			log.Printf("Error fetching game from database: %v", err)
+			return
 		}
-		gamesMu.Unlock()
 
+		game.mu.Lock()
 		for _, player := range game.Players {
 			if player.UUID == userUUID {
 				player.connections = append(player.connections, ws)
 				break
 			}
 		}
+		sendChatHistory(db, game, ws)
+		game.mu.Unlock()
 
 		for {
 			var msg map[string]interface{}
 			err := ws.ReadJSON(&msg)
 			//if close sent by client
 			if websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				game.mu.Lock()
 				userID, _ := getUserIDFromUUID(db, userUUID)
 				//remove the connection from the player
 				for _, player := range game.Players {
@@ -240,6 +384,7 @@ func handleConnections(db *sql.DB) http.HandlerFunc {
 					handleLeaveRoom(game, userID)
 					sendGameState(game, nil)
 				}
+				game.mu.Unlock()
 				break
 			}
 
@@ -248,7 +393,9 @@ func handleConnections(db *sql.DB) http.HandlerFunc {
 				break
 			}
 
+			game.mu.Lock()
 			handleMessage(msg, game, userUUID, ws, db)
+			game.mu.Unlock()
 		}
 	}
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVisiblePlayers(t *testing.T) {
+	five, eight := "5", "8"
+	newGame := func(showCards bool, anonymous bool) *Game {
+		return &Game{
+			showCards: showCards,
+			settings:  RoomSettings{Anonymous: anonymous},
+			Players: []*Player{
+				{ID: 1, Name: "Ana", Voted: true, Vote: &five},
+				nil,
+				{ID: 2, Name: "Bob", Voted: true, Vote: &eight},
+				{ID: 3, Name: "Eve"},
+			},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		showCards bool
+		anonymous bool
+		viewerID  int
+		want      map[int]*string
+	}{
+		{"before reveal the voter sees only their own vote", false, false, 1, map[int]*string{1: &five, 2: nil, 3: nil}},
+		{"before reveal a player who did not vote sees none", false, false, 3, map[int]*string{1: nil, 2: nil, 3: nil}},
+		{"before reveal the API sees none", false, false, 0, map[int]*string{1: nil, 2: nil, 3: nil}},
+		{"after reveal everyone sees every vote", true, false, 3, map[int]*string{1: &five, 2: &eight, 3: nil}},
+		{"after reveal the API sees every vote", true, false, 0, map[int]*string{1: &five, 2: &eight, 3: nil}},
+		{"anonymous rooms keep others' votes hidden after reveal", true, true, 2, map[int]*string{1: nil, 2: &eight, 3: nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			game := newGame(tt.showCards, tt.anonymous)
+			players := visiblePlayers(game, tt.viewerID)
+
+			got := make(map[int]*string)
+			for _, player := range players {
+				if player == nil {
+					t.Fatal("nil player in the visible players")
+				}
+				got[player.ID] = player.Vote
+				if want := player.ID != 3; player.Voted != want {
+					t.Errorf("player %d: got voted %v, want %v", player.ID, player.Voted, want)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got votes %v, want %v", votesOf(got), votesOf(tt.want))
+			}
+
+			// Hiding a vote must not clear it from the room
+			if game.Players[0].Vote != &five || game.Players[2].Vote != &eight {
+				t.Error("visiblePlayers changed the players of the room")
+			}
+		})
+	}
+}
+
+func votesOf(votes map[int]*string) map[int]string {
+	values := make(map[int]string, len(votes))
+	for id, vote := range votes {
+		values[id] = "<hidden>"
+		if vote != nil {
+			values[id] = *vote
+		}
+	}
+	return values
+}